@@ -1,23 +1,31 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/gorilla/mux"
+	common "github.com/jplindgren/rpg-vault/internal"
 	"github.com/jplindgren/rpg-vault/internal/characters"
+	"github.com/jplindgren/rpg-vault/internal/storage"
+	"github.com/jplindgren/rpg-vault/internal/validator"
+	"github.com/jplindgren/rpg-vault/internal/worlds"
 )
 
 func (app application) createCharacterHandler(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	worldId := vars["worldId"]
+	user := app.contextGetUser(r)
+	world, member := app.contextGetWorld(r)
 
 	var input struct {
 		Name       string
 		Intro      string
 		OwnerId    string
 		CoverImage string
+		Attributes map[string]interface{}
 	}
 
 	err := app.readJSON(w, r, &input)
@@ -26,12 +34,37 @@ func (app application) createCharacterHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 
+	// Characters belong to whoever creates them. Only game masters can create a
+	// character on behalf of another member of the world.
+	ownerId := user.Email
+	if input.OwnerId != "" && input.OwnerId != user.Email {
+		if !member.Can(worlds.RoleGameMaster) {
+			app.notPermittedResponse(w, r)
+			return
+		}
+
+		ok := app.validateCharacterOwner(w, r, world, input.OwnerId)
+		if !ok {
+			return
+		}
+		ownerId = input.OwnerId
+	}
+
 	character := &characters.Character{
-		WorldId:    worldId,
+		WorldId:    world.Id,
 		Name:       input.Name,
 		Intro:      input.Intro,
-		OwnerId:    input.OwnerId,
+		OwnerId:    ownerId,
 		CoverImage: input.CoverImage,
+		Attributes: input.Attributes,
+	}
+	if world.Sheet != nil {
+		character.Attributes = world.Sheet.ApplyDefaults(character.Attributes)
+	}
+
+	ok := app.validateCharacter(w, r, world, character)
+	if !ok {
+		return
 	}
 
 	err = app.services.Characters.Insert(character)
@@ -41,37 +74,34 @@ func (app application) createCharacterHandler(w http.ResponseWriter, r *http.Req
 	}
 
 	headers := make(http.Header)
-	headers.Set("Location", fmt.Sprintf("/v1/worlds/%s/characters/%s", worldId, character.Id))
-	app.writeJSON(w, http.StatusCreated, envelope{"character": character}, headers)
+	headers.Set("Location", fmt.Sprintf("/v1/worlds/%s/characters/%s", world.Id, character.Id))
+	err = app.writeJSON(w, http.StatusCreated, envelope{"character": character}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
 func (app application) updateCharacterHandler(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	worldId := vars["worldId"]
-	id := vars["id"]
-
-	character, err := app.services.Characters.Get(worldId, id)
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
+	character, ok := app.readCharacter(w, r)
+	if !ok {
 		return
 	}
 
-	if character == nil {
-		app.notFoundResponse(w, r)
+	if !app.canEditCharacter(r, character) {
+		app.notPermittedResponse(w, r)
 		return
 	}
 
+	// The attributes used to be sent as a string holding a JSON object, which is still
+	// accepted.
 	var input struct {
 		Name       *string
 		Intro      *string
-		Attributes *string
+		Attributes json.RawMessage
 		CoverImage *string
 	}
 
-	err = app.readJSON(w, r, &input)
+	err := app.readJSON(w, r, &input)
 	if err != nil {
 		app.badRequestResponse(w, r, err)
 		return
@@ -84,8 +114,7 @@ func (app application) updateCharacterHandler(w http.ResponseWriter, r *http.Req
 		character.Intro = *input.Intro
 	}
 	if input.Attributes != nil {
-		character.AttributesJSON = *input.Attributes
-		err = json.Unmarshal([]byte(character.AttributesJSON), &character.Attributes)
+		character.Attributes, err = readAttributes(input.Attributes)
 		if err != nil {
 			app.badRequestResponse(w, r, err)
 			return
@@ -95,32 +124,47 @@ func (app application) updateCharacterHandler(w http.ResponseWriter, r *http.Req
 		character.CoverImage = *input.CoverImage
 	}
 
-	err = app.services.Characters.Update(worldId, id, character)
+	world, _ := app.contextGetWorld(r)
+	ok = app.validateCharacter(w, r, world, character)
+	if !ok {
+		return
+	}
+
+	err = app.services.Characters.Update(character.WorldId, character.Id, character)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, common.ErrorRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
 	headers := make(http.Header)
-	headers.Set("Location", fmt.Sprintf("/v1/worlds/%s/characters/%s", worldId, character.Id))
-	app.writeJSON(w, http.StatusOK, envelope{"character": character}, headers)
+	headers.Set("Location", fmt.Sprintf("/v1/worlds/%s/characters/%s", character.WorldId, character.Id))
+	err = app.writeJSON(w, http.StatusOK, envelope{"character": character}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
+// getCharacterHandler returns a character along with their encumbrance, computed from
+// the weight of their inventory.
 func (app *application) getCharacterHandler(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	worldId := vars["worldId"]
-	id := vars["id"]
+	character, ok := app.readCharacter(w, r)
+	if !ok {
+		return
+	}
 
-	result, err := app.services.Characters.Get(worldId, id)
+	weight, err := app.services.Inventory.Weight(character.WorldId, character.Id)
 	if err != nil {
-		app.badRequestResponse(w, r, err)
+		app.serverErrorResponse(w, r, err)
 		return
 	}
+	character.SetEncumbrance(weight)
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"character": result}, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"character": character}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -128,15 +172,54 @@ func (app *application) getCharacterHandler(w http.ResponseWriter, r *http.Reque
 }
 
 func (app application) listCharacterHandler(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	worldId := vars["worldId"]
+	world, _ := app.contextGetWorld(r)
+
+	qs := r.URL.Query()
+	v := validator.New()
+
+	var filters characters.Filters
+	filters.Name = app.readString(qs, "name", "")
+	filters.OwnerId = app.readString(qs, "ownerId", "")
+	filters.Sort = app.readString(qs, "sort", "createdAt")
+
+	// Attribute filters are given as name:value pairs, e.g. attributes=class:wizard,level:3
+	for _, pair := range app.readCSV(qs, "attributes", nil) {
+		name, value, found := strings.Cut(pair, ":")
+		if !found {
+			v.AddError("attributes", "must be a list of name:value pairs")
+			break
+		}
+
+		if filters.Attributes == nil {
+			filters.Attributes = make(map[string]string)
+		}
+		filters.Attributes[name] = value
+	}
+
+	characters.ValidateFilters(v, filters)
+
+	// Cursors only make sense for the order they were issued for.
+	scope := "characters:" + world.Id + ":" + filters.Sort
+	limit, cursor := app.readPage(qs, scope, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
 
-	characters, err := app.services.Characters.List(worldId)
+	result, next, err := app.services.Characters.List(world.Id, filters, limit, cursor.Key)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
+		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"characters": characters}, nil)
+	nextCursor, err := app.cursors.Encode(scope, storage.Cursor{Key: next})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	meta := metadata{Limit: limit, NextCursor: nextCursor}
+	err = app.writeJSON(w, http.StatusOK, envelope{"characters": result, "metadata": meta}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -144,18 +227,222 @@ func (app application) listCharacterHandler(w http.ResponseWriter, r *http.Reque
 }
 
 func (app application) deleteCharacterHandler(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	worldId := vars["worldId"]
-	id := vars["id"]
+	character, ok := app.readCharacter(w, r)
+	if !ok {
+		return
+	}
+
+	if !app.canEditCharacter(r, character) {
+		app.notPermittedResponse(w, r)
+		return
+	}
 
-	err := app.services.Characters.Delete(worldId, id)
+	err := app.services.Characters.Delete(character.WorldId, character.Id)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
+		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, nil, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "character moved to the trash"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 }
+
+// updateCharacterOwnerHandler lets a game master hand a character over to another
+// member of the world.
+func (app application) updateCharacterOwnerHandler(w http.ResponseWriter, r *http.Request) {
+	world, _ := app.contextGetWorld(r)
+
+	character, ok := app.readCharacter(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		OwnerId string `json:"ownerId"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	ok = app.validateCharacterOwner(w, r, world, input.OwnerId)
+	if !ok {
+		return
+	}
+
+	err = app.services.Characters.UpdateOwner(character, input.OwnerId)
+	if err != nil {
+		switch {
+		case errors.Is(err, common.ErrorRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"character": character}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listMyCharactersHandler lists the characters owned by the current user across all
+// of their worlds. Characters of the worlds the user has left, was removed from, or
+// which are in the trash are left out, like the worlds themselves.
+func (app application) listMyCharactersHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	owned, err := app.services.Characters.ListByOwner(user.Email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	visible := make(map[string]bool)
+	result := []characters.Character{}
+	for _, character := range *owned {
+		allowed, checked := visible[character.WorldId]
+		if !checked {
+			_, _, err = app.resolveWorld(user, character.WorldId)
+			switch {
+			case err == nil:
+				allowed = true
+			case errors.Is(err, common.ErrorRecordNotFound):
+				allowed = false
+			default:
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+			visible[character.WorldId] = allowed
+		}
+
+		if allowed {
+			result = append(result, character)
+		}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"characters": result}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// canEditCharacter reports whether the current user may change a character. Players
+// can only change the characters they own, while game masters and the world owner can
+// change any character of the world.
+func (app *application) canEditCharacter(r *http.Request, character *characters.Character) bool {
+	user := app.contextGetUser(r)
+	_, member := app.contextGetWorld(r)
+
+	return member.Can(worlds.RoleGameMaster) || character.OwnerId == user.Email
+}
+
+// validateCharacter checks a character against the sheet of its world, and sets the
+// attributes the sheet computes. If it isn't valid, a failed validation response is
+// sent and false is returned.
+func (app *application) validateCharacter(w http.ResponseWriter, r *http.Request, world *worlds.World, character *characters.Character) bool {
+	v := validator.New()
+	characters.ValidateCharacter(v, character, world.Sheet)
+
+	if v.Valid() && world.Sheet != nil {
+		if character.Attributes == nil {
+			character.Attributes = make(map[string]interface{})
+		}
+
+		err := world.Sheet.Compute(character.Attributes)
+		if err != nil {
+			v.AddError("attributes", err.Error())
+		}
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return false
+	}
+
+	return true
+}
+
+// readAttributes decodes the attributes of a character, sent either as a JSON object or
+// as a string holding one. null clears them.
+func readAttributes(raw json.RawMessage) (map[string]interface{}, error) {
+	if trimmed := bytes.TrimSpace(raw); len(trimmed) > 0 && trimmed[0] == '"' {
+		var s string
+		err := json.Unmarshal(trimmed, &s)
+		if err != nil {
+			return nil, errors.New("body contains badly-formed attributes")
+		}
+		raw = json.RawMessage(s)
+	}
+
+	var attributes map[string]interface{}
+	err := json.Unmarshal(raw, &attributes)
+	if err != nil {
+		return nil, errors.New("body contains badly-formed attributes")
+	}
+
+	return attributes, nil
+}
+
+// validateCharacterOwner checks that a character can be assigned to the given user,
+// which must be the world owner or one of its accepted members. If not, a failed
+// validation response is sent and false is returned.
+func (app *application) validateCharacterOwner(w http.ResponseWriter, r *http.Request, world *worlds.World, ownerId string) bool {
+	v := validator.New()
+	v.Check(ownerId != "", "ownerId", "must be provided")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return false
+	}
+
+	if ownerId == world.UserId {
+		return true
+	}
+
+	member, err := app.services.Members.Get(world.Id, ownerId)
+	if err != nil {
+		switch {
+		case errors.Is(err, common.ErrorRecordNotFound):
+			v.AddError("ownerId", "must be a member of the world")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return false
+	}
+
+	if member.Status != worlds.MemberAccepted {
+		v.AddError("ownerId", "must be a member of the world")
+		app.failedValidationResponse(w, r, v.Errors)
+		return false
+	}
+
+	return true
+}
+
+// readCharacter loads the character named by the {id} route variable from the world
+// resolved by the requireWorldRole() middleware. Characters are keyed by their world,
+// so a character id from another world is reported as not found.
+func (app *application) readCharacter(w http.ResponseWriter, r *http.Request) (*characters.Character, bool) {
+	world, _ := app.contextGetWorld(r)
+	id := mux.Vars(r)["id"]
+
+	character, err := app.services.Characters.Get(world.Id, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, common.ErrorRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return character, true
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/jplindgren/rpg-vault/internal/worlds"
+)
+
+func TestCharacterAccess(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app)
+
+	owner, _ := app.newTestUser(t, "owner@example.com")
+	alice, _ := app.newTestUser(t, "alice@example.com")
+	bob, _ := app.newTestUser(t, "bob@example.com")
+	outsider, _ := app.newTestUser(t, "outsider@example.com")
+
+	worldId := ts.newTestWorld(t, owner, "Eberron")
+	ts.join(t, worldId, owner, "alice@example.com", alice, worlds.RolePlayer)
+	ts.join(t, worldId, owner, "bob@example.com", bob, worlds.RolePlayer)
+	otherWorldId := ts.newTestWorld(t, outsider, "Faerun")
+
+	newCharacter := func(token, worldId, name string) string {
+		code, data := ts.do(t, http.MethodPost, "/v1/worlds/"+worldId+"/characters", token, map[string]string{"name": name})
+		if code != http.StatusCreated {
+			t.Fatalf("creating character %s = %d, want %d", name, code, http.StatusCreated)
+		}
+		return field(data, "character", "id").(string)
+	}
+
+	world := "/v1/worlds/" + worldId + "/characters"
+	otherWorld := "/v1/worlds/" + otherWorldId + "/characters"
+	characterId := newCharacter(alice, worldId, "Lei")
+	character := world + "/" + characterId
+	otherCharacterId := newCharacter(outsider, otherWorldId, "Drizzt")
+	rename := map[string]string{"name": "Lei d'Cannith"}
+
+	tests := []struct {
+		name     string
+		token    string
+		method   string
+		path     string
+		body     interface{}
+		wantCode int
+	}{
+		{"owner of the character reads", alice, http.MethodGet, character, nil, http.StatusOK},
+		{"other player reads", bob, http.MethodGet, character, nil, http.StatusOK},
+		{"owner of the character edits", alice, http.MethodPatch, character, rename, http.StatusOK},
+		{"other player edits", bob, http.MethodPatch, character, rename, http.StatusForbidden},
+		{"owner of the world edits", owner, http.MethodPatch, character, rename, http.StatusOK},
+		{"other player deletes", bob, http.MethodDelete, character, nil, http.StatusForbidden},
+		{"player reassigns", alice, http.MethodPut, character + "/owner", map[string]string{"ownerId": "bob@example.com"}, http.StatusForbidden},
+		{"player creates for someone else", alice, http.MethodPost, world, map[string]string{"name": "Vi", "ownerId": "bob@example.com"}, http.StatusForbidden},
+		{"game master creates for an outsider", owner, http.MethodPost, world, map[string]string{"name": "Vi", "ownerId": "outsider@example.com"}, http.StatusUnprocessableEntity},
+		{"game master creates for a player", owner, http.MethodPost, world, map[string]string{"name": "Vi", "ownerId": "bob@example.com"}, http.StatusCreated},
+		{"outsider lists", outsider, http.MethodGet, world, nil, http.StatusNotFound},
+		{"outsider reads", outsider, http.MethodGet, character, nil, http.StatusNotFound},
+		{"outsider edits", outsider, http.MethodPatch, character, rename, http.StatusNotFound},
+		{"outsider deletes", outsider, http.MethodDelete, character, nil, http.StatusNotFound},
+		{"outsider creates", outsider, http.MethodPost, world, map[string]string{"name": "Vi"}, http.StatusNotFound},
+		{"character through another world", outsider, http.MethodGet, otherWorld + "/" + characterId, nil, http.StatusNotFound},
+		{"other world's character through this world", owner, http.MethodGet, world + "/" + otherCharacterId, nil, http.StatusNotFound},
+		{"owner of the character deletes", alice, http.MethodDelete, character, nil, http.StatusOK},
+		{"deleted character", alice, http.MethodGet, character, nil, http.StatusNotFound},
+	}
+
+	// The cases run in order, each one seeing the characters left by the previous ones.
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _ := ts.do(t, tt.method, tt.path, tt.token, tt.body)
+			if code != tt.wantCode {
+				t.Errorf("%s %s = %d, want %d", tt.method, tt.path, code, tt.wantCode)
+			}
+		})
+	}
+}
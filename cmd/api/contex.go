@@ -4,7 +4,9 @@ import (
 	"context"
 	"net/http"
 
+	"github.com/jplindgren/rpg-vault/internal/jwt"
 	"github.com/jplindgren/rpg-vault/internal/users"
+	"github.com/jplindgren/rpg-vault/internal/worlds"
 )
 
 // Define a custom contextKey type, with the underlying type string.
@@ -15,6 +17,21 @@ type contextKey string
 // in the request context.
 const userContextKey = contextKey("user")
 
+// tokenContextKey is used to store the authentication token of the current request.
+const tokenContextKey = contextKey("token")
+
+// claimsContextKey is used to store the claims of the JWT used by the current request.
+const claimsContextKey = contextKey("claims")
+
+// worldContextKey is used to store the world resolved by the requireWorldRole()
+// middleware, together with the current user's membership in it.
+const worldContextKey = contextKey("world")
+
+type worldAccess struct {
+	world  *worlds.World
+	member *worlds.Member
+}
+
 // The contextSetUser() method returns a new copy of the request with the provided
 // User struct added to the context. Note that we use our userContextKey constant as the
 // key.
@@ -35,3 +52,44 @@ func (app *application) contextGetUser(r *http.Request) *users.User {
 
 	return user
 }
+
+func (app *application) contextSetWorld(r *http.Request, world *worlds.World, member *worlds.Member) *http.Request {
+	ctx := context.WithValue(r.Context(), worldContextKey, &worldAccess{world: world, member: member})
+	return r.WithContext(ctx)
+}
+
+// The contextGetWorld() retrieves the world and membership added by requireWorldRole().
+// Just like contextGetUser(), a missing value means a route was registered without
+// the middleware, so we panic.
+func (app *application) contextGetWorld(r *http.Request) (*worlds.World, *worlds.Member) {
+	access, ok := r.Context().Value(worldContextKey).(*worldAccess)
+	if !ok {
+		panic("missing world value in request context")
+	}
+
+	return access.world, access.member
+}
+
+func (app *application) contextSetToken(r *http.Request, token *users.Token) *http.Request {
+	ctx := context.WithValue(r.Context(), tokenContextKey, token)
+	return r.WithContext(ctx)
+}
+
+// The contextGetToken() retrieves the authentication token used by the current request,
+// or nil for anonymous requests.
+func (app *application) contextGetToken(r *http.Request) *users.Token {
+	token, _ := r.Context().Value(tokenContextKey).(*users.Token)
+	return token
+}
+
+func (app *application) contextSetClaims(r *http.Request, claims *jwt.Claims) *http.Request {
+	ctx := context.WithValue(r.Context(), claimsContextKey, claims)
+	return r.WithContext(ctx)
+}
+
+// The contextGetClaims() retrieves the claims of the JWT used by the current request,
+// or nil if the request was not authenticated with a JWT.
+func (app *application) contextGetClaims(r *http.Request) *jwt.Claims {
+	claims, _ := r.Context().Value(claimsContextKey).(*jwt.Claims)
+	return claims
+}
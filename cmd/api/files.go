@@ -0,0 +1,39 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	common "github.com/jplindgren/rpg-vault/internal"
+	"github.com/jplindgren/rpg-vault/internal/uploader"
+)
+
+// serveFileHandler serves the files kept by the disk blob storage, like the world cover
+// images. They are public, like the objects of the S3 bucket. Only images are served
+// inline; anything else is sent as a download, so it never runs on the API origin.
+func (app *application) serveFileHandler(w http.ResponseWriter, r *http.Request) {
+	filePath := mux.Vars(r)["path"]
+
+	contents, err := app.services.Blobs.Read(filePath)
+	if err != nil {
+		switch {
+		case errors.Is(err, common.ErrorRecordNotFound), errors.Is(err, uploader.ErrorInvalidPath):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	contentType := http.DetectContentType(contents)
+	if !uploader.IsImage(contentType) {
+		contentType = "application/octet-stream"
+		w.Header().Set("Content-Disposition", "attachment")
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
+	w.Write(contents)
+}
@@ -10,5 +10,5 @@ import (
 func (app *application) healthcheckHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	fmt.Fprintln(w, "status: available")
-	fmt.Fprintf(w, "port: %d \n", app.config.port)
+	fmt.Fprintf(w, "port: %d \n", app.config.Port)
 }
@@ -11,11 +11,19 @@ import (
 	"strings"
 
 	"github.com/gorilla/mux"
+	"github.com/jplindgren/rpg-vault/internal/storage"
 	"github.com/jplindgren/rpg-vault/internal/validator"
 )
 
 type envelope map[string]interface{}
 
+// metadata is sent alongside the paginated listings. NextCursor is the cursor query
+// string parameter for the next page, and is left out on the last page.
+type metadata struct {
+	Limit      int    `json:"limit"`
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
 func (app *application) readIdParam(r *http.Request) (int64, error) {
 	vars := mux.Vars(r)
 	id, err := strconv.ParseInt(vars["id"], 10, 64)
@@ -148,6 +156,21 @@ func (app *application) readInt(qa url.Values, key string, defaultValue int, v *
 	return i
 }
 
+// The readPage() helper reads the limit and cursor query string parameters of the
+// paginated listings. Cursors are only valid for the scope they were issued for.
+func (app *application) readPage(qs url.Values, scope string, v *validator.Validator) (int, storage.Cursor) {
+	limit := app.readInt(qs, "limit", 20, v)
+	v.Check(limit > 0, "limit", "must be greater than zero")
+	v.Check(limit <= 100, "limit", "must be a maximum of 100")
+
+	cursor, err := app.cursors.Decode(scope, app.readString(qs, "cursor", ""))
+	if err != nil {
+		v.AddError("cursor", "is invalid")
+	}
+
+	return limit, cursor
+}
+
 func (app *application) readCSV(qa url.Values, key string, defaultValue []string) []string {
 	value := qa.Get(key)
 
@@ -158,3 +181,17 @@ func (app *application) readCSV(qa url.Values, key string, defaultValue []string
 	values := strings.Split(value, ",")
 	return values
 }
+
+// The background() helper accepts an arbitrary function as a parameter and runs it in a
+// background goroutine, recovering any panic so it can't bring down the application.
+func (app *application) background(fn func()) {
+	go func() {
+		defer func() {
+			if err := recover(); err != nil {
+				app.logger.PrintError(fmt.Errorf("%s", err), nil)
+			}
+		}()
+
+		fn()
+	}()
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	common "github.com/jplindgren/rpg-vault/internal"
+	"github.com/jplindgren/rpg-vault/internal/characters"
+	"github.com/jplindgren/rpg-vault/internal/items"
+	"github.com/jplindgren/rpg-vault/internal/validator"
+)
+
+// listInventoryHandler returns the whole inventory of a character, each entry along
+// with its item. Entries inside containers name them in containerId.
+func (app *application) listInventoryHandler(w http.ResponseWriter, r *http.Request) {
+	character, ok := app.readCharacter(w, r)
+	if !ok {
+		return
+	}
+
+	inventory, err := app.services.Inventory.List(character.WorldId, character.Id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"inventory": inventory}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) addInventoryEntryHandler(w http.ResponseWriter, r *http.Request) {
+	character, ok := app.readCharacter(w, r)
+	if !ok {
+		return
+	}
+
+	if !app.canEditCharacter(r, character) {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	var input struct {
+		ItemId      string
+		Quantity    *int
+		Equipped    bool
+		ContainerId string
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	entry := &items.Entry{
+		WorldId:     character.WorldId,
+		CharacterId: character.Id,
+		ItemId:      input.ItemId,
+		Quantity:    1,
+		Equipped:    input.Equipped,
+		ContainerId: input.ContainerId,
+	}
+	if input.Quantity != nil {
+		entry.Quantity = *input.Quantity
+	}
+
+	inventory, ok := app.validateEntry(w, r, entry)
+	if !ok {
+		return
+	}
+
+	if len(inventory) >= items.MaxEntries {
+		app.failedValidationResponse(w, r, map[string]string{"inventory": fmt.Sprintf("must not hold more than %d entries", items.MaxEntries)})
+		return
+	}
+
+	err = app.services.Inventory.Add(entry)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/worlds/%s/characters/%s/inventory/%s", character.WorldId, character.Id, entry.Id))
+	err = app.writeJSON(w, http.StatusCreated, envelope{"entry": entry}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateInventoryEntryHandler changes the quantity of an entry, equips or unequips it,
+// or moves it in or out of a container. An empty containerId takes it out.
+func (app *application) updateInventoryEntryHandler(w http.ResponseWriter, r *http.Request) {
+	character, entry, ok := app.readEntry(w, r)
+	if !ok {
+		return
+	}
+
+	if !app.canEditCharacter(r, character) {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Quantity    *int
+		Equipped    *bool
+		ContainerId *string
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Quantity != nil {
+		entry.Quantity = *input.Quantity
+	}
+	if input.Equipped != nil {
+		entry.Equipped = *input.Equipped
+	}
+	if input.ContainerId != nil {
+		entry.ContainerId = *input.ContainerId
+	}
+
+	_, ok = app.validateEntry(w, r, entry)
+	if !ok {
+		return
+	}
+
+	err = app.services.Inventory.Update(entry)
+	if err != nil {
+		switch {
+		case errors.Is(err, common.ErrorEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"entry": entry}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteInventoryEntryHandler removes an entry from an inventory. What it held stays
+// in the inventory.
+func (app *application) deleteInventoryEntryHandler(w http.ResponseWriter, r *http.Request) {
+	character, entry, ok := app.readEntry(w, r)
+	if !ok {
+		return
+	}
+
+	if !app.canEditCharacter(r, character) {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	err := app.services.Inventory.Delete(entry)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "entry successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// transferInventoryEntryHandler gives an entry, or a part of it, to another character
+// of the world. The quantity defaults to the whole entry, which takes what it holds
+// along. The transfer is atomic: a concurrent change to the entry fails it with an edit
+// conflict, leaving both inventories untouched.
+func (app *application) transferInventoryEntryHandler(w http.ResponseWriter, r *http.Request) {
+	character, entry, ok := app.readEntry(w, r)
+	if !ok {
+		return
+	}
+
+	if !app.canEditCharacter(r, character) {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	var input struct {
+		CharacterId string
+		Quantity    *int
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	quantity := entry.Quantity
+	if input.Quantity != nil {
+		quantity = *input.Quantity
+	}
+
+	v := validator.New()
+	v.Check(input.CharacterId != "", "characterId", "must be provided")
+	v.Check(input.CharacterId != character.Id, "characterId", "must be another character")
+	v.Check(quantity > 0 && quantity <= entry.Quantity, "quantity", "must be between 1 and the quantity of the entry")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	inventory, err := app.services.Inventory.List(character.WorldId, character.Id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	contents := items.Contents(entry, inventory)
+	v.Check(len(contents) == 0 || quantity == entry.Quantity, "quantity", "a container holding items can only be transferred whole")
+
+	_, err = app.services.Characters.Get(character.WorldId, input.CharacterId)
+	switch {
+	case errors.Is(err, common.ErrorRecordNotFound):
+		v.AddError("characterId", "must be a character of the world")
+	case err != nil:
+		app.serverErrorResponse(w, r, err)
+		return
+	default:
+		target, err := app.services.Inventory.List(character.WorldId, input.CharacterId)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		v.Check(len(target)+len(contents) < items.MaxEntries, "characterId", fmt.Sprintf("the inventory must not hold more than %d entries", items.MaxEntries))
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	moved, err := app.services.Inventory.Transfer(entry, contents, input.CharacterId, quantity)
+	if err != nil {
+		switch {
+		case errors.Is(err, items.ErrorTransferTooLarge):
+			app.failedValidationResponse(w, r, map[string]string{"entryId": err.Error()})
+		case errors.Is(err, common.ErrorEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"entry": moved}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// validateEntry checks an entry, and that its item belongs to the world and its
+// container, when it has one, to the same inventory. Containers can't hold themselves
+// or the entries holding them. It returns the inventory of the character, or sends a
+// failed validation response and returns false when the entry isn't valid.
+func (app *application) validateEntry(w http.ResponseWriter, r *http.Request, entry *items.Entry) ([]items.Entry, bool) {
+	v := validator.New()
+	if items.ValidateEntry(v, entry); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return nil, false
+	}
+
+	item, err := app.services.Items.Get(entry.WorldId, entry.ItemId)
+	switch {
+	case errors.Is(err, common.ErrorRecordNotFound):
+		v.AddError("itemId", "must be an item of the world")
+	case err != nil:
+		app.serverErrorResponse(w, r, err)
+		return nil, false
+	default:
+		entry.Item = item
+	}
+
+	inventory, err := app.services.Inventory.List(entry.WorldId, entry.CharacterId)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return nil, false
+	}
+
+	if entry.ContainerId != "" {
+		entries := make(map[string]*items.Entry, len(inventory))
+		for i := range inventory {
+			entries[inventory[i].Id] = &inventory[i]
+		}
+
+		container, found := entries[entry.ContainerId]
+		switch {
+		case !found:
+			v.AddError("containerId", "must be an entry of the inventory")
+		case container.Item == nil || !container.Item.Container:
+			v.AddError("containerId", "must be an entry of a container item")
+		default:
+			depth := 1
+			for ancestor := container; ancestor != nil; ancestor = entries[ancestor.ContainerId] {
+				if ancestor.Id == entry.Id {
+					v.AddError("containerId", "must not be the entry itself or one of the entries it holds")
+					break
+				}
+				if depth > items.MaxDepth {
+					v.AddError("containerId", fmt.Sprintf("containers must not be nested more than %d levels deep", items.MaxDepth))
+					break
+				}
+				depth++
+			}
+		}
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return nil, false
+	}
+
+	return inventory, true
+}
+
+// readEntry loads the character named by the {id} route variable and the entry of
+// their inventory named by the {entryId} one.
+func (app *application) readEntry(w http.ResponseWriter, r *http.Request) (*characters.Character, *items.Entry, bool) {
+	character, ok := app.readCharacter(w, r)
+	if !ok {
+		return nil, nil, false
+	}
+
+	entryId := mux.Vars(r)["entryId"]
+
+	entry, err := app.services.Inventory.Get(character.WorldId, character.Id, entryId)
+	if err != nil {
+		switch {
+		case errors.Is(err, common.ErrorRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, nil, false
+	}
+
+	return character, entry, true
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	common "github.com/jplindgren/rpg-vault/internal"
+	"github.com/jplindgren/rpg-vault/internal/items"
+	"github.com/jplindgren/rpg-vault/internal/storage"
+	"github.com/jplindgren/rpg-vault/internal/validator"
+)
+
+func (app *application) createItemHandler(w http.ResponseWriter, r *http.Request) {
+	world, _ := app.contextGetWorld(r)
+
+	var input struct {
+		Name        string
+		Description string
+		Weight      float64
+		Value       float64
+		Rarity      string
+		Container   bool
+		Properties  map[string]interface{}
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	item := &items.Item{
+		WorldId:     world.Id,
+		Name:        input.Name,
+		Description: input.Description,
+		Weight:      input.Weight,
+		Value:       input.Value,
+		Rarity:      input.Rarity,
+		Container:   input.Container,
+		Properties:  input.Properties,
+	}
+	if item.Rarity == "" {
+		item.Rarity = items.RarityCommon
+	}
+
+	v := validator.New()
+	if items.ValidateItem(v, item); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.services.Items.Insert(item)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/worlds/%s/items/%s", world.Id, item.Id))
+	err = app.writeJSON(w, http.StatusCreated, envelope{"item": item}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) getItemHandler(w http.ResponseWriter, r *http.Request) {
+	item, ok := app.readItem(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"item": item}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listItemsHandler(w http.ResponseWriter, r *http.Request) {
+	world, _ := app.contextGetWorld(r)
+
+	qs := r.URL.Query()
+	v := validator.New()
+
+	var filters items.Filters
+	filters.Name = app.readString(qs, "name", "")
+	filters.Rarity = app.readString(qs, "rarity", "")
+	filters.Sort = app.readString(qs, "sort", "name")
+
+	items.ValidateFilters(v, filters)
+
+	// Cursors only make sense for the order they were issued for.
+	scope := "items:" + world.Id + ":" + filters.Sort
+	limit, cursor := app.readPage(qs, scope, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	result, next, err := app.services.Items.List(world.Id, filters, limit, cursor.Key)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	nextCursor, err := app.cursors.Encode(scope, storage.Cursor{Key: next})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	meta := metadata{Limit: limit, NextCursor: nextCursor}
+	err = app.writeJSON(w, http.StatusOK, envelope{"items": result, "metadata": meta}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateItemHandler(w http.ResponseWriter, r *http.Request) {
+	item, ok := app.readItem(w, r)
+	if !ok {
+		return
+	}
+
+	// The properties, when sent, replace the current ones.
+	var input struct {
+		Name        *string
+		Description *string
+		Weight      *float64
+		Value       *float64
+		Rarity      *string
+		Container   *bool
+		Properties  map[string]interface{}
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Name != nil {
+		item.Name = *input.Name
+	}
+	if input.Description != nil {
+		item.Description = *input.Description
+	}
+	if input.Weight != nil {
+		item.Weight = *input.Weight
+	}
+	if input.Value != nil {
+		item.Value = *input.Value
+	}
+	if input.Rarity != nil {
+		item.Rarity = *input.Rarity
+	}
+	// Entries of the item holding other entries must be emptied before it stops being
+	// a container.
+	if input.Container != nil && item.Container && !*input.Container {
+		holds, err := app.services.Items.HoldsEntries(item.WorldId, item.Id)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		if holds {
+			app.failedValidationResponse(w, r, map[string]string{"container": "must stay on while entries of the item hold other entries"})
+			return
+		}
+	}
+	if input.Container != nil {
+		item.Container = *input.Container
+	}
+	if input.Properties != nil {
+		item.Properties = input.Properties
+	}
+
+	v := validator.New()
+	if items.ValidateItem(v, item); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.services.Items.Update(item)
+	if err != nil {
+		switch {
+		case errors.Is(err, common.ErrorRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/worlds/%s/items/%s", item.WorldId, item.Id))
+	err = app.writeJSON(w, http.StatusOK, envelope{"item": item}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteItemHandler deletes an item, removing it from the inventories holding it.
+func (app *application) deleteItemHandler(w http.ResponseWriter, r *http.Request) {
+	item, ok := app.readItem(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.services.Items.Delete(item.WorldId, item.Id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "item successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readItem loads the item named by the {id} route variable from the world resolved by
+// the requireWorldRole() middleware.
+func (app *application) readItem(w http.ResponseWriter, r *http.Request) (*items.Item, bool) {
+	world, _ := app.contextGetWorld(r)
+	id := mux.Vars(r)["id"]
+
+	item, err := app.services.Items.Get(world.Id, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, common.ErrorRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return item, true
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestItemContainer(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app)
+
+	owner, _ := app.newTestUser(t, "owner@example.com")
+	worldId := ts.newTestWorld(t, owner, "Eberron")
+	world := "/v1/worlds/" + worldId
+
+	create := func(path string, body map[string]interface{}, key string) string {
+		code, data := ts.do(t, http.MethodPost, world+path, owner, body)
+		if code != http.StatusCreated {
+			t.Fatalf("POST %s = %d, want %d", path, code, http.StatusCreated)
+		}
+		return field(data, key, "id").(string)
+	}
+
+	bag := create("/items", map[string]interface{}{"name": "Bag of Holding", "rarity": "uncommon", "container": true}, "item")
+	rope := create("/items", map[string]interface{}{"name": "Rope", "rarity": "common"}, "item")
+	inventory := "/characters/" + create("/characters", map[string]interface{}{"name": "Lei"}, "character") + "/inventory"
+	bagEntry := create(inventory, map[string]interface{}{"itemId": bag, "quantity": 1}, "entry")
+	ropeEntry := create(inventory, map[string]interface{}{"itemId": rope, "quantity": 1, "containerId": bagEntry}, "entry")
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		body     interface{}
+		wantCode int
+	}{
+		{"turn off a container holding entries", http.MethodPatch, "/items/" + bag, map[string]bool{"container": false}, http.StatusUnprocessableEntity},
+		{"empty the container", http.MethodDelete, inventory + "/" + ropeEntry, nil, http.StatusOK},
+		{"turn off an empty container", http.MethodPatch, "/items/" + bag, map[string]bool{"container": false}, http.StatusOK},
+		{"delete an item", http.MethodDelete, "/items/" + rope, nil, http.StatusOK},
+		{"update a deleted item", http.MethodPatch, "/items/" + rope, map[string]string{"name": "Silk Rope"}, http.StatusNotFound},
+	}
+
+	// The cases run in order, each one seeing the items left by the previous ones.
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _ := ts.do(t, tt.method, world+tt.path, owner, tt.body)
+			if code != tt.wantCode {
+				t.Errorf("%s %s = %d, want %d", tt.method, tt.path, code, tt.wantCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	common "github.com/jplindgren/rpg-vault/internal"
+	"github.com/jplindgren/rpg-vault/internal/locations"
+	"github.com/jplindgren/rpg-vault/internal/storage"
+	"github.com/jplindgren/rpg-vault/internal/uploader"
+	"github.com/jplindgren/rpg-vault/internal/validator"
+)
+
+func (app *application) createLocationHandler(w http.ResponseWriter, r *http.Request) {
+	world, _ := app.contextGetWorld(r)
+
+	var input struct {
+		ParentId    string
+		Name        string
+		Description string
+		MapImage    string
+		Pins        []locations.Pin
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	location := &locations.Location{
+		WorldId:     world.Id,
+		ParentId:    input.ParentId,
+		Name:        input.Name,
+		Description: input.Description,
+		MapImage:    input.MapImage,
+		Pins:        input.Pins,
+	}
+
+	ok := app.validateLocation(w, r, location, true)
+	if !ok {
+		return
+	}
+
+	err = app.services.Locations.Insert(location)
+	if err != nil {
+		switch {
+		case errors.Is(err, uploader.ErrorInvalidImage):
+			app.failedValidationResponse(w, r, map[string]string{"mapImage": err.Error()})
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/worlds/%s/locations/%s", world.Id, location.Id))
+	err = app.writeJSON(w, http.StatusCreated, envelope{"location": location}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// getLocationHandler returns a location along with the locations above it, from the top
+// level down to its parent, so clients can show where it is.
+func (app *application) getLocationHandler(w http.ResponseWriter, r *http.Request) {
+	location, ok := app.readLocation(w, r)
+	if !ok {
+		return
+	}
+
+	path, err := app.services.Locations.Path(location.WorldId, location.ParentId)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if path == nil {
+		path = []locations.Location{}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"location": location, "path": path}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listLocationsHandler(w http.ResponseWriter, r *http.Request) {
+	world, _ := app.contextGetWorld(r)
+
+	qs := r.URL.Query()
+	v := validator.New()
+
+	var filters locations.Filters
+	filters.Name = app.readString(qs, "name", "")
+	filters.ParentId = app.readString(qs, "parentId", "")
+	filters.Sort = app.readString(qs, "sort", "name")
+
+	locations.ValidateFilters(v, filters)
+
+	// Cursors only make sense for the order they were issued for.
+	scope := "locations:" + world.Id + ":" + filters.Sort
+	limit, cursor := app.readPage(qs, scope, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	result, next, err := app.services.Locations.List(world.Id, filters, limit, cursor.Key)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	nextCursor, err := app.cursors.Encode(scope, storage.Cursor{Key: next})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	meta := metadata{Limit: limit, NextCursor: nextCursor}
+	err = app.writeJSON(w, http.StatusOK, envelope{"locations": result, "metadata": meta}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateLocationHandler(w http.ResponseWriter, r *http.Request) {
+	location, ok := app.readLocation(w, r)
+	if !ok {
+		return
+	}
+
+	// An empty parentId moves the location to the top level, and an empty mapImage
+	// removes its map.
+	var input struct {
+		ParentId    *string
+		Name        *string
+		Description *string
+		MapImage    *string
+		Pins        []locations.Pin
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.ParentId != nil {
+		location.ParentId = *input.ParentId
+	}
+	if input.Name != nil {
+		location.Name = *input.Name
+	}
+	if input.Description != nil {
+		location.Description = *input.Description
+	}
+	if input.Pins != nil {
+		location.Pins = input.Pins
+	}
+
+	mapUpdated := false
+	if input.MapImage != nil {
+		mapUpdated = *input.MapImage != ""
+		location.MapImage = *input.MapImage
+	}
+
+	ok = app.validateLocation(w, r, location, input.Pins != nil)
+	if !ok {
+		return
+	}
+
+	err = app.services.Locations.Update(location, mapUpdated)
+	if err != nil {
+		switch {
+		case errors.Is(err, uploader.ErrorInvalidImage):
+			app.failedValidationResponse(w, r, map[string]string{"mapImage": err.Error()})
+		case errors.Is(err, common.ErrorRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/worlds/%s/locations/%s", location.WorldId, location.Id))
+	err = app.writeJSON(w, http.StatusOK, envelope{"location": location}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteLocationHandler deletes a location along with its sub-locations and their maps.
+func (app *application) deleteLocationHandler(w http.ResponseWriter, r *http.Request) {
+	location, ok := app.readLocation(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.services.Locations.Delete(location.WorldId, location.Id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "location successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// validateLocation checks a location, and that its parent and, when checkPins is true,
+// what its pins link belong to the world. The parent can't be the location itself or
+// one of its sub-locations, and pins can only link the direct sub-locations. If it isn't
+// valid, a failed validation response is sent and false is returned.
+func (app *application) validateLocation(w http.ResponseWriter, r *http.Request, location *locations.Location, checkPins bool) bool {
+	v := validator.New()
+	if locations.ValidateLocation(v, location); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return false
+	}
+
+	if location.ParentId != "" {
+		path, err := app.services.Locations.Path(location.WorldId, location.ParentId)
+		switch {
+		case errors.Is(err, common.ErrorRecordNotFound):
+			v.AddError("parentId", "must be a location of the world")
+		case err != nil:
+			app.serverErrorResponse(w, r, err)
+			return false
+		default:
+			// A location being moved takes its sub-locations along, so they must fit
+			// under the new parent as well.
+			height := 1
+			if location.Id != "" {
+				height, err = app.services.Locations.Height(location.WorldId, location.Id)
+				if err != nil {
+					app.serverErrorResponse(w, r, err)
+					return false
+				}
+			}
+
+			v.Check(len(path)+height <= locations.MaxDepth, "parentId", fmt.Sprintf("locations must not be nested more than %d levels deep", locations.MaxDepth))
+			for _, ancestor := range path {
+				v.Check(ancestor.Id != location.Id, "parentId", "must not be the location itself or one of its sub-locations")
+			}
+		}
+	}
+
+	if checkPins {
+		for i, pin := range location.Pins {
+			key := fmt.Sprintf("pins[%d]", i)
+
+			var err error
+			if pin.CharacterId != "" {
+				_, err = app.services.Characters.Get(location.WorldId, pin.CharacterId)
+			} else {
+				var linked *locations.Location
+				linked, err = app.services.Locations.Get(location.WorldId, pin.LocationId)
+				if err == nil && (location.Id == "" || linked.ParentId != location.Id) {
+					v.AddError(key, "must link a sub-location of the location")
+				}
+			}
+
+			switch {
+			case errors.Is(err, common.ErrorRecordNotFound):
+				v.AddError(key, "must link a character or a location of the world")
+			case err != nil:
+				app.serverErrorResponse(w, r, err)
+				return false
+			}
+		}
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return false
+	}
+
+	return true
+}
+
+// readLocation loads the location named by the {id} route variable from the world
+// resolved by the requireWorldRole() middleware.
+func (app *application) readLocation(w http.ResponseWriter, r *http.Request) (*locations.Location, bool) {
+	world, _ := app.contextGetWorld(r)
+	id := mux.Vars(r)["id"]
+
+	location, err := app.services.Locations.Get(world.Id, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, common.ErrorRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return location, true
+}
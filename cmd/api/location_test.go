@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/jplindgren/rpg-vault/internal/locations"
+)
+
+func TestLocationNesting(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app)
+
+	owner, _ := app.newTestUser(t, "owner@example.com")
+	worldId := ts.newTestWorld(t, owner, "Eberron")
+	otherWorldId := ts.newTestWorld(t, owner, "Faerun")
+
+	path := "/v1/worlds/" + worldId + "/locations"
+	newLocation := func(worldId, name, parentId string) string {
+		code, data := ts.do(t, http.MethodPost, "/v1/worlds/"+worldId+"/locations", owner, map[string]string{"name": name, "parentId": parentId})
+		if code != http.StatusCreated {
+			t.Fatalf("creating location %s = %d, want %d", name, code, http.StatusCreated)
+		}
+		return field(data, "location", "id").(string)
+	}
+
+	// A chain of locations as deep as allowed, and a location with a sub-location.
+	chain := make([]string, locations.MaxDepth)
+	for i := range chain {
+		parentId := ""
+		if i > 0 {
+			parentId = chain[i-1]
+		}
+		chain[i] = newLocation(worldId, fmt.Sprintf("Level %d", i+1), parentId)
+	}
+	tower := newLocation(worldId, "Tower", "")
+	room := newLocation(worldId, "Room", tower)
+	elsewhere := newLocation(otherWorldId, "Waterdeep", "")
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		parentId string
+		wantCode int
+	}{
+		{"create too deep", http.MethodPost, path, chain[locations.MaxDepth-1], http.StatusUnprocessableEntity},
+		{"create under an unknown parent", http.MethodPost, path, "missing", http.StatusUnprocessableEntity},
+		{"create under another world's location", http.MethodPost, path, elsewhere, http.StatusUnprocessableEntity},
+		{"move under itself", http.MethodPatch, path + "/" + tower, tower, http.StatusUnprocessableEntity},
+		{"move under its sub-location", http.MethodPatch, path + "/" + tower, room, http.StatusUnprocessableEntity},
+		{"move under a deep sub-location", http.MethodPatch, path + "/" + chain[0], chain[5], http.StatusUnprocessableEntity},
+		{"move with sub-locations too deep", http.MethodPatch, path + "/" + tower, chain[locations.MaxDepth-2], http.StatusUnprocessableEntity},
+		{"move with sub-locations as deep as allowed", http.MethodPatch, path + "/" + tower, chain[locations.MaxDepth-3], http.StatusOK},
+		{"move a leaf to the deepest level", http.MethodPatch, path + "/" + room, chain[locations.MaxDepth-2], http.StatusOK},
+		{"move to the top level", http.MethodPatch, path + "/" + tower, "", http.StatusOK},
+	}
+
+	// The cases run in order, each one seeing the tree left by the previous ones.
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := map[string]string{"parentId": tt.parentId}
+			if tt.method == http.MethodPost {
+				body["name"] = "Vault"
+			}
+
+			code, _ := ts.do(t, tt.method, tt.path, owner, body)
+			if code != tt.wantCode {
+				t.Errorf("%s %s = %d, want %d", tt.method, tt.path, code, tt.wantCode)
+			}
+		})
+	}
+}
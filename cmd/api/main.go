@@ -1,105 +1,194 @@
 package main
 
 import (
+	"crypto/rand"
 	"flag"
 	"fmt"
 	"net/http"
 	"os"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/jplindgren/rpg-vault/internal/clients"
+	"github.com/jplindgren/rpg-vault/internal/config"
 	"github.com/jplindgren/rpg-vault/internal/jsonlog"
+	"github.com/jplindgren/rpg-vault/internal/jwt"
+	"github.com/jplindgren/rpg-vault/internal/mailer"
+	"github.com/jplindgren/rpg-vault/internal/search"
 	"github.com/jplindgren/rpg-vault/internal/services"
+	"github.com/jplindgren/rpg-vault/internal/storage"
+	"github.com/jplindgren/rpg-vault/internal/uploader"
+	"github.com/jplindgren/rpg-vault/internal/users"
 )
 
-type config struct {
-	port    int
-	limiter struct {
-		rps     float64
-		burst   int
-		enabled bool
-	}
-	cors struct {
-		trustedOrigins []string
-	}
-	aws struct {
-		key    string
-		secret string
-		region string
-	}
-}
-
 type application struct {
-	config config
+	config config.Config
 	logger *jsonlog.Logger
 	//models   adapters.Models
 	services services.Services
+	mailer   mailer.Mailer
+	jwt      *jwt.Signer
+	denylist *jwt.Denylist
+	cursors  *storage.CursorSigner
 }
 
-const port = 4000
-
 func main() {
-	fmt.Println(os.Getenv("AWS_ACCESS_KEY_ID"))
-	fmt.Println(os.Getenv("AWS_SECRET_ACCESS_KEY"))
-	fmt.Println(os.Getenv("AWS_DEFAULT_REGION"))
-
-	var cfg config
-
-	flag.IntVar(&cfg.port, "port", 4000, "API server port")
-
-	// Create command line flags to read the setting values into the config struct.
-	// Notice that we use true as the default for the 'enabled' setting?
-	flag.Float64Var(&cfg.limiter.rps, "limiter-rps", 2, "Rate limiter maximum requests per second")
-	flag.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate limiter maximum burst")
-	flag.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable rate limiter")
-
-	flag.StringVar(&cfg.aws.key, "aws-key", os.Getenv("AWS_ACCESS_KEY_ID"), "Aws key")
-	flag.StringVar(&cfg.aws.secret, "aws-secret", os.Getenv("AWS_SECRET_ACCESS_KEY"), "Aws secret")
-	flag.StringVar(&cfg.aws.region, "aws-region", os.Getenv("AWS_DEFAULT_REGION"), "Aws region")
-
-	// Use the flag.Func() function to process the -cors-trusted-origins command line
-	// flag. In this we use the strings.Fields() function to split the flag value into a
-	// slice based on whitespace characters and assign it to our config struct.
-	// Importantly, if the -cors-trusted-origins flag is not present, contains the empty
-	// string, or contains only whitespace, then strings.Fields() will return an empty
-	// []string slice.
-	flag.Func("cors-trusted-origins", "Trusted CORS origins (space separated)", func(val string) error {
-		cfg.cors.trustedOrigins = strings.Fields(val)
-		return nil
-	})
-
+	// The settings are read from the file in -config, one per environment (see the
+	// config directory), with environment variables taking precedence over the file.
+	var configPath string
+	flag.StringVar(&configPath, "config", os.Getenv("RPG_VAULT_CONFIG"), "Path to the YAML config file")
 	flag.Parse()
 
-	// Initialize a new logger which writes messages to the standard out stream,
-	// prefixed with the current date and time.
-	//logger := log.New(os.Stdout, "", log.Ldate|log.Ltime)
-	logger := jsonlog.New(os.Stdout, jsonlog.LevelInfo)
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		jsonlog.New(os.Stdout, jsonlog.LevelInfo).PrintFatal(err, nil)
+	}
+
+	level, err := jsonlog.ParseLevel(cfg.LogLevel)
+	if err != nil {
+		jsonlog.New(os.Stdout, jsonlog.LevelInfo).PrintFatal(err, nil)
+	}
+
+	// Initialize a new logger which writes messages at or above the configured level
+	// to the standard out stream.
+	logger := jsonlog.New(os.Stdout, level)
+
+	mail, err := newMailer(cfg)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	store, err := newStore(cfg)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	blobs, err := newBlobStore(cfg)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	cursorSecret := []byte(cfg.Pagination.CursorSecret)
+	if len(cursorSecret) == 0 {
+		cursorSecret = make([]byte, 32)
+		_, err = rand.Read(cursorSecret)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+	}
 
 	app := &application{
-		logger: logger,
-		config: cfg,
-		services: services.NewServices(
-			clients.GetDynamodbClient(cfg.aws.key, cfg.aws.secret, cfg.aws.region),
-			clients.GetS3Client(cfg.aws.key, cfg.aws.secret, cfg.aws.region),
-		),
+		logger:   logger,
+		config:   cfg,
+		services: services.NewServices(store, blobs, search.NewIndex(), services.TableNames(cfg.Storage.Tables), users.PermissionSettings(cfg.Permissions)),
+		mailer:   mail,
+		cursors:  storage.NewCursorSigner(cursorSecret),
+	}
+
+	// The search index lives in memory, so it has to be rebuilt on every start.
+	indexed, err := app.services.Reindex()
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	logger.PrintInfo("search index built", map[string]string{
+		"documents": strconv.Itoa(indexed),
+	})
+
+	if cfg.Auth.Mode == "jwt" {
+		keys, err := jwt.ParseKeys(cfg.Auth.JWTKeys)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+
+		app.jwt, err = jwt.NewSigner(cfg.Auth.JWTIssuer, keys...)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+		app.denylist = jwt.NewDenylist()
 	}
 
 	router := app.routes()
 	composerHandler := app.recoverPanic(app.enabledCORS(app.rateLimit(app.authenticate(router))))
 
 	srv := &http.Server{
-		Addr:         fmt.Sprintf(":%d", port),
+		Addr:         fmt.Sprintf(":%d", cfg.Port),
 		Handler:      composerHandler,
 		IdleTimeout:  time.Minute,
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 30 * time.Second,
 	}
 
-	logger.PrintInfo("starting server on %s", map[string]string{
-		"port": strconv.Itoa(port),
+	app.background(app.purgeTrash)
+
+	if cfg.Search.ReindexInterval > 0 {
+		app.background(app.reindexSearch)
+	}
+
+	logger.PrintInfo("starting server", map[string]string{
+		"port":    strconv.Itoa(cfg.Port),
+		"storage": cfg.Storage.Kind,
 	})
-	err := srv.ListenAndServe()
+	err = srv.ListenAndServe()
 	logger.PrintFatal(err, nil)
 }
+
+func newMailer(cfg config.Config) (mailer.Mailer, error) {
+	switch cfg.Mailer.Kind {
+	case "smtp":
+		return mailer.NewSMTP(cfg.SMTP.Host, cfg.SMTP.Port, cfg.SMTP.Username, cfg.SMTP.Password, cfg.SMTP.Sender), nil
+	case "log":
+		if cfg.Mailer.Dir != "" {
+			return mailer.NewFileSink(cfg.Mailer.Dir)
+		}
+		return mailer.NewLogSink(os.Stdout), nil
+	default:
+		return nil, fmt.Errorf("unknown mailer %q", cfg.Mailer.Kind)
+	}
+}
+
+func newStore(cfg config.Config) (storage.Store, error) {
+	tables := services.Tables(services.TableNames(cfg.Storage.Tables))
+
+	switch cfg.Storage.Kind {
+	case "dynamodb":
+		return storage.NewDynamo(clients.GetDynamodbClient(cfg.AWS.Key, cfg.AWS.Secret, cfg.AWS.Region, cfg.AWS.Endpoint)), nil
+	case "memory":
+		return storage.NewMemory(tables...), nil
+	case "sqlite":
+		dsn := cfg.Storage.DSN
+		if dsn == "" {
+			dsn = "rpg-vault.db"
+		}
+		return storage.OpenSQL(storage.SQLite, dsn, tables...)
+	case "postgres":
+		return storage.OpenSQL(storage.Postgres, cfg.Storage.DSN, tables...)
+	default:
+		return nil, fmt.Errorf("unknown storage %q", cfg.Storage.Kind)
+	}
+}
+
+func newBlobStore(cfg config.Config) (uploader.BlobStore, error) {
+	switch cfg.Blobs.Kind {
+	case "s3":
+		s3Config := clients.S3Config{
+			Bucket:    cfg.Blobs.S3.Bucket,
+			Region:    cfg.Blobs.S3.Region,
+			Endpoint:  cfg.Blobs.S3.Endpoint,
+			PathStyle: cfg.Blobs.S3.PathStyle,
+			PublicURL: cfg.Blobs.PublicURL,
+		}
+		if s3Config.Region == "" {
+			s3Config.Region = cfg.AWS.Region
+		}
+		return clients.GetS3Client(cfg.AWS.Key, cfg.AWS.Secret, s3Config), nil
+	case "disk":
+		publicURL := cfg.Blobs.PublicURL
+		if publicURL == "" {
+			publicURL = fmt.Sprintf("http://localhost:%d/v1/files", cfg.Port)
+		}
+		return uploader.NewDiskStore(cfg.Blobs.Dir, publicURL)
+	default:
+		return nil, fmt.Errorf("unknown blob storage %q", cfg.Blobs.Kind)
+	}
+}
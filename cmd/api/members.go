@@ -0,0 +1,251 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	common "github.com/jplindgren/rpg-vault/internal"
+	"github.com/jplindgren/rpg-vault/internal/users"
+	"github.com/jplindgren/rpg-vault/internal/validator"
+	"github.com/jplindgren/rpg-vault/internal/worlds"
+)
+
+// resolveWorld looks up a world together with the user's membership in it. Worlds are
+// stored under their owner's key, so the membership is what tells us where to find a
+// shared world. A world the user has no accepted membership in is reported as
+// ErrorRecordNotFound, so we never leak the existence of other people's worlds.
+func (app *application) resolveWorld(user *users.User, worldId string) (*worlds.World, *worlds.Member, error) {
+	ownerId := user.Email
+
+	member, err := app.services.Members.Get(worldId, user.Email)
+	switch {
+	case err == nil && member.Status == worlds.MemberAccepted:
+		ownerId = member.OwnerId
+	case err == nil, errors.Is(err, common.ErrorRecordNotFound):
+		member = nil
+	default:
+		return nil, nil, err
+	}
+
+	world, err := app.services.Worlds.Get(ownerId, worldId)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Worlds created before memberships existed have no member record for their
+	// owner, so we build one on the fly.
+	if member == nil {
+		member = &worlds.Member{
+			WorldId: world.Id,
+			Email:   user.Email,
+			OwnerId: world.UserId,
+			Role:    worlds.RoleOwner,
+			Status:  worlds.MemberAccepted,
+		}
+	}
+
+	world.Role = member.Role
+	return world, member, nil
+}
+
+// authorizeWorld resolves the world for the current user and checks that they hold at
+// least the given role in it. Worlds the user can't see at all get a 404 Not Found,
+// while worlds they can see but not act upon get a 403 Forbidden. If the check fails
+// the matching error response is sent and false is returned.
+func (app *application) authorizeWorld(w http.ResponseWriter, r *http.Request, worldId, role string) (*worlds.World, *worlds.Member, bool) {
+	user := app.contextGetUser(r)
+
+	world, member, err := app.resolveWorld(user, worldId)
+	if err != nil {
+		switch {
+		case errors.Is(err, common.ErrorRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, nil, false
+	}
+
+	if !member.Can(role) {
+		app.notPermittedResponse(w, r)
+		return nil, nil, false
+	}
+
+	return world, member, true
+}
+
+func (app *application) listWorldMembersHandler(w http.ResponseWriter, r *http.Request) {
+	world, _ := app.contextGetWorld(r)
+
+	members, err := app.services.Members.List(world.Id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"members": members}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) inviteWorldMemberHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+	world, inviter := app.contextGetWorld(r)
+
+	var input struct {
+		Email string `json:"email"`
+		Role  string `json:"role"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	member := &worlds.Member{
+		WorldId:   world.Id,
+		Email:     input.Email,
+		OwnerId:   world.UserId,
+		Role:      input.Role,
+		Status:    worlds.MemberInvited,
+		InvitedBy: user.Email,
+	}
+
+	v := validator.New()
+	if worlds.ValidateMember(v, member); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Members can only invite people into roles below their own, so game masters
+	// invite players and viewers and only the owner can appoint other game masters.
+	if !worlds.Outranks(inviter.Role, member.Role) {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	if member.Email == world.UserId {
+		v.AddError("email", "this user is already a member of the world")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	_, err = app.services.Users.GetByEmail(member.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, common.ErrorRecordNotFound):
+			v.AddError("email", "no matching email address found")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.services.Members.Insert(member)
+	if err != nil {
+		switch {
+		case errors.Is(err, worlds.ErrorAlreadyMember):
+			v.AddError("email", "this user is already a member of the world")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/worlds/%s/members/%s", world.Id, member.Email))
+	err = app.writeJSON(w, http.StatusCreated, envelope{"member": member}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) acceptWorldInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	worldId := vars["worldId"]
+	user := app.contextGetUser(r)
+
+	member, err := app.services.Members.Get(worldId, user.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, common.ErrorRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if member.Status != worlds.MemberAccepted {
+		err = app.services.Members.Accept(member)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"member": member}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// removeWorldMemberHandler removes someone from a world. Any member can leave a world
+// (or decline an invitation) by removing themselves, while game masters and the owner
+// can remove members holding a lower role than their own. Removing someone else is
+// authorized before looking them up, so the members of a world can't be probed by
+// outsiders.
+func (app *application) removeWorldMemberHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	worldId := vars["worldId"]
+	email := vars["email"]
+	user := app.contextGetUser(r)
+
+	var member *worlds.Member
+	if email != user.Email {
+		var ok bool
+		_, member, ok = app.authorizeWorld(w, r, worldId, worlds.RoleGameMaster)
+		if !ok {
+			return
+		}
+	}
+
+	target, err := app.services.Members.Get(worldId, email)
+	if err != nil {
+		switch {
+		case errors.Is(err, common.ErrorRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if member != nil && !worlds.Outranks(member.Role, target.Role) {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	// The owner can't leave their own world, it has to be deleted instead.
+	if target.Role == worlds.RoleOwner {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	err = app.services.Members.Delete(worldId, email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "member successfully removed"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
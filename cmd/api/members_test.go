@@ -0,0 +1,131 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/jplindgren/rpg-vault/internal/worlds"
+)
+
+func TestRequireWorldRole(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app)
+
+	owner, _ := app.newTestUser(t, "owner@example.com")
+	gm, _ := app.newTestUser(t, "gm@example.com")
+	player, _ := app.newTestUser(t, "player@example.com")
+	viewer, _ := app.newTestUser(t, "viewer@example.com")
+	invitee, _ := app.newTestUser(t, "invitee@example.com")
+	outsider, _ := app.newTestUser(t, "outsider@example.com")
+
+	worldId := ts.newTestWorld(t, owner, "Eberron")
+	ts.join(t, worldId, owner, "gm@example.com", gm, worlds.RoleGameMaster)
+	ts.join(t, worldId, owner, "player@example.com", player, worlds.RolePlayer)
+	ts.join(t, worldId, owner, "viewer@example.com", viewer, worlds.RoleViewer)
+
+	code, _ := ts.do(t, http.MethodPost, "/v1/worlds/"+worldId+"/members", owner, map[string]string{"email": "invitee@example.com", "role": worlds.RolePlayer})
+	if code != http.StatusCreated {
+		t.Fatalf("inviting invitee@example.com = %d, want %d", code, http.StatusCreated)
+	}
+
+	world := "/v1/worlds/" + worldId
+	roll := map[string]string{"expression": "1d20"}
+	rename := map[string]string{"name": "Khorvaire"}
+
+	tests := []struct {
+		name     string
+		token    string
+		method   string
+		path     string
+		body     interface{}
+		wantCode int
+	}{
+		{"owner reads", owner, http.MethodGet, world, nil, http.StatusOK},
+		{"owner rolls", owner, http.MethodPost, world + "/rolls", roll, http.StatusCreated},
+		{"owner edits", owner, http.MethodPatch, world, rename, http.StatusOK},
+		{"gm reads", gm, http.MethodGet, world, nil, http.StatusOK},
+		{"gm rolls", gm, http.MethodPost, world + "/rolls", roll, http.StatusCreated},
+		{"gm edits", gm, http.MethodPatch, world, rename, http.StatusOK},
+		{"gm deletes", gm, http.MethodDelete, world, nil, http.StatusForbidden},
+		{"player reads", player, http.MethodGet, world, nil, http.StatusOK},
+		{"player rolls", player, http.MethodPost, world + "/rolls", roll, http.StatusCreated},
+		{"player edits", player, http.MethodPatch, world, rename, http.StatusForbidden},
+		{"viewer reads", viewer, http.MethodGet, world, nil, http.StatusOK},
+		{"viewer rolls", viewer, http.MethodPost, world + "/rolls", roll, http.StatusForbidden},
+		{"viewer edits", viewer, http.MethodPatch, world, rename, http.StatusForbidden},
+		{"pending invitee reads", invitee, http.MethodGet, world, nil, http.StatusNotFound},
+		{"outsider reads", outsider, http.MethodGet, world, nil, http.StatusNotFound},
+		{"outsider edits", outsider, http.MethodPatch, world, rename, http.StatusNotFound},
+		{"unknown world", owner, http.MethodGet, "/v1/worlds/missing", nil, http.StatusNotFound},
+		{"owner deletes", owner, http.MethodDelete, world, nil, http.StatusOK},
+	}
+
+	// The owner deletes the world last, as the other cases need it.
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _ := ts.do(t, tt.method, tt.path, tt.token, tt.body)
+			if code != tt.wantCode {
+				t.Errorf("%s %s = %d, want %d", tt.method, tt.path, code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestWorldMembers(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app)
+
+	owner, _ := app.newTestUser(t, "owner@example.com")
+	gm, _ := app.newTestUser(t, "gm@example.com")
+	player, _ := app.newTestUser(t, "player@example.com")
+	viewer, _ := app.newTestUser(t, "viewer@example.com")
+	app.newTestUser(t, "alice@example.com")
+	outsider, _ := app.newTestUser(t, "outsider@example.com")
+
+	worldId := ts.newTestWorld(t, owner, "Eberron")
+	ts.join(t, worldId, owner, "gm@example.com", gm, worlds.RoleGameMaster)
+	ts.join(t, worldId, owner, "player@example.com", player, worlds.RolePlayer)
+	ts.join(t, worldId, owner, "viewer@example.com", viewer, worlds.RoleViewer)
+
+	members := "/v1/worlds/" + worldId + "/members"
+	invite := func(role string) map[string]string {
+		return map[string]string{"email": "alice@example.com", "role": role}
+	}
+
+	tests := []struct {
+		name     string
+		token    string
+		method   string
+		path     string
+		body     interface{}
+		wantCode int
+	}{
+		{"player invites", player, http.MethodPost, members, invite(worlds.RoleViewer), http.StatusForbidden},
+		{"gm invites a gm", gm, http.MethodPost, members, invite(worlds.RoleGameMaster), http.StatusForbidden},
+		{"gm invites an owner", gm, http.MethodPost, members, invite(worlds.RoleOwner), http.StatusUnprocessableEntity},
+		{"gm invites an unknown user", gm, http.MethodPost, members, map[string]string{"email": "bob@example.com", "role": worlds.RolePlayer}, http.StatusUnprocessableEntity},
+		{"gm invites the owner", gm, http.MethodPost, members, map[string]string{"email": "owner@example.com", "role": worlds.RolePlayer}, http.StatusUnprocessableEntity},
+		{"gm invites a player", gm, http.MethodPost, members, invite(worlds.RolePlayer), http.StatusCreated},
+		{"gm invites them again", gm, http.MethodPost, members, invite(worlds.RolePlayer), http.StatusUnprocessableEntity},
+		{"outsider accepts", outsider, http.MethodPost, members + "/accept", nil, http.StatusNotFound},
+		{"outsider removes a member", outsider, http.MethodDelete, members + "/player@example.com", nil, http.StatusNotFound},
+		{"player removes a viewer", player, http.MethodDelete, members + "/viewer@example.com", nil, http.StatusForbidden},
+		{"gm leaves", gm, http.MethodDelete, members + "/gm@example.com", nil, http.StatusOK},
+		{"owner removes a missing member", owner, http.MethodDelete, members + "/bob@example.com", nil, http.StatusNotFound},
+		{"owner removes themselves", owner, http.MethodDelete, members + "/owner@example.com", nil, http.StatusForbidden},
+		{"viewer leaves", viewer, http.MethodDelete, members + "/viewer@example.com", nil, http.StatusOK},
+		{"viewer is gone", viewer, http.MethodGet, "/v1/worlds/" + worldId, nil, http.StatusNotFound},
+		{"owner removes a player", owner, http.MethodDelete, members + "/player@example.com", nil, http.StatusOK},
+		{"player is gone", player, http.MethodGet, "/v1/worlds/" + worldId, nil, http.StatusNotFound},
+	}
+
+	// The cases run in order, each one seeing the members left by the previous ones.
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _ := ts.do(t, tt.method, tt.path, tt.token, tt.body)
+			if code != tt.wantCode {
+				t.Errorf("%s %s = %d, want %d", tt.method, tt.path, code, tt.wantCode)
+			}
+		})
+	}
+}
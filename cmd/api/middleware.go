@@ -8,7 +8,9 @@ import (
 	"sync"
 	"time"
 
+	"github.com/gorilla/mux"
 	common "github.com/jplindgren/rpg-vault/internal"
+	"github.com/jplindgren/rpg-vault/internal/jwt"
 	"github.com/jplindgren/rpg-vault/internal/users"
 	"github.com/jplindgren/rpg-vault/internal/validator"
 	"github.com/tomasen/realip"
@@ -50,6 +52,27 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		// Extract the actual authentication token from the header parts.
 		token := headerParts[1]
 
+		// In JWT mode, signed tokens are verified locally. They carry everything we
+		// need to know about the user, so no database lookup is needed.
+		if app.jwt != nil && jwt.IsJWT(token) {
+			claims, err := app.jwt.Verify(token)
+			if err != nil || app.denylist.Denied(claims) {
+				app.notAuthorizedResponse(w, r)
+				return
+			}
+
+			authenticatedUser := &users.User{
+				Email:     claims.Subject,
+				Name:      claims.Name,
+				Activated: claims.Activated,
+			}
+
+			r = app.contextSetUser(r, authenticatedUser)
+			r = app.contextSetClaims(r, claims)
+			next.ServeHTTP(w, r)
+			return
+		}
+
 		// Validate the token to make sure it is in a sensible format.
 		v := validator.New()
 
@@ -58,13 +81,19 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 
-		// Retrieve the details of the user associated with the authentication token,
-		// again calling the invalidAuthenticationTokenResponse() helper if no
-		// matching record was found. IMPORTANT: Notice that we are using
-		// ScopeAuthentication as the first parameter here.
-		savedToken, err := app.services.Tokens.Get(token)
+		// Retrieve the authentication token, again calling the notAuthorizedResponse()
+		// helper if no matching record was found. IMPORTANT: Notice that we are using
+		// Authentication as the scope here, so activation or password reset tokens can't
+		// be used to authenticate, and expired tokens are rejected even if DynamoDB
+		// didn't get around to deleting them yet.
+		savedToken, err := app.services.Tokens.GetForScope(users.Authentication, token)
 		if err != nil {
-			app.notAuthorizedResponse(w, r)
+			switch {
+			case errors.Is(err, common.ErrorRecordNotFound):
+				app.notAuthorizedResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
 			return
 		}
 
@@ -79,7 +108,17 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 
+		// Keep track of when the session was last used. We only write it once a minute
+		// per token, so busy clients don't turn every request into a database write.
+		if time.Since(savedToken.LastUsedAt) > time.Minute {
+			err = app.services.Tokens.Touch(savedToken)
+			if err != nil {
+				app.logError(r, err)
+			}
+		}
+
 		r = app.contextSetUser(r, authenticatedUser)
+		r = app.contextSetToken(r, savedToken)
 		next.ServeHTTP(w, r)
 	})
 }
@@ -122,19 +161,19 @@ func (app *application) requireAuthenticatedUser(next http.HandlerFunc) http.Han
 // we require the user to have.
 func (app *application) requirePermission(code string, next http.HandlerFunc) http.HandlerFunc {
 	middleWare := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// user := app.contextGetUser(r)
-
-		// permissions, err := app.models.Permissions.GetAllForUser(user.ID)
-		// if err != nil {
-		// 	app.serverErrorResponse(w, r, err)
-		// 	return
-		// }
+		// Retrieve all the permissions for the user.
+		permissions, err := app.userPermissions(r)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
 
-		// isAllowed := permissions.Include(code)
-		// if !isAllowed {
-		// 	app.notPermittedResponse(w, r)
-		// 	return
-		// }
+		// Check if the slice includes the required permission. If it doesn't, then
+		// return a 403 Forbidden response.
+		if !permissions.Include(code) {
+			app.notPermittedResponse(w, r)
+			return
+		}
 
 		next.ServeHTTP(w, r)
 	})
@@ -142,6 +181,35 @@ func (app *application) requirePermission(code string, next http.HandlerFunc) ht
 	return app.requireActivatedUser(middleWare)
 }
 
+// The userPermissions() helper returns the permissions of the current user. JWTs already
+// carry them, so we only hit the database for opaque tokens.
+func (app *application) userPermissions(r *http.Request) (users.Permissions, error) {
+	if claims := app.contextGetClaims(r); claims != nil {
+		return users.Permissions(claims.Permissions), nil
+	}
+
+	return app.services.Permissions.GetAllForUser(app.contextGetUser(r).Email)
+}
+
+// requireWorldRole resolves the world named by the {worldId} route variable and checks
+// that the current user holds at least the given role in it before calling the next
+// handler. The world and the user's membership are added to the request context, so
+// handlers never have to look them up again. Must be wrapped by requirePermission(),
+// which guarantees an activated user.
+func (app *application) requireWorldRole(role string, next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		worldId := mux.Vars(r)["worldId"]
+
+		world, member, ok := app.authorizeWorld(w, r, worldId, role)
+		if !ok {
+			return
+		}
+
+		r = app.contextSetWorld(r, world, member)
+		next.ServeHTTP(w, r)
+	})
+}
+
 func (app *application) recoverPanic(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Create a deferred function (which will always be run in the event of a panic as Go unwinds the stack).
@@ -209,7 +277,7 @@ func (app *application) rateLimit(next http.Handler) http.Handler {
 	}()
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if app.config.limiter.enabled {
+		if app.config.Limiter.Enabled {
 			// Extract the client's IP address from the request.
 			// ip, _, err := net.SplitHostPort(r.RemoteAddr)
 			// if err != nil {
@@ -226,7 +294,7 @@ func (app *application) rateLimit(next http.Handler) http.Handler {
 
 			if _, found := clients[ip]; !found {
 				// Create and add a new client struct to the map if it doesn't already exist.
-				clients[ip] = &client{limiter: rate.NewLimiter(rate.Limit(app.config.limiter.rps), app.config.limiter.burst)}
+				clients[ip] = &client{limiter: rate.NewLimiter(rate.Limit(app.config.Limiter.RPS), app.config.Limiter.Burst)}
 			}
 
 			// Update the last seen time for the client.
@@ -262,8 +330,8 @@ func (app *application) enabledCORS(next http.Handler) http.Handler {
 		if origin != "" {
 			// Loop through the list of trusted origins, checking to see if the request origin exactly matches
 			// one of them. If there are no trusted origins, then the loop won't be iterated.
-			for i := range app.config.cors.trustedOrigins {
-				if origin == app.config.cors.trustedOrigins[i] {
+			for i := range app.config.CORS.TrustedOrigins {
+				if origin == app.config.CORS.TrustedOrigins[i] {
 					w.Header().Set("Access-Control-Allow-Origin", "*")
 
 					// Check if the request has the HTTP method OPTIONS and contains the
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	common "github.com/jplindgren/rpg-vault/internal"
+	"github.com/jplindgren/rpg-vault/internal/users"
+	"github.com/jplindgren/rpg-vault/internal/validator"
+)
+
+// getUserPermissionsHandler returns the permission codes of a user. Only
+// administrators can see the codes of other users.
+func (app *application) getUserPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	email, ok := app.readUserEmail(w, r)
+	if !ok {
+		return
+	}
+
+	permissions, err := app.services.Permissions.GetAllForUser(email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"permissions": permissions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateUserPermissionsHandler grants and revokes permission codes of a user. The
+// codes carried by the access tokens the user already has only change when they are
+// refreshed.
+func (app *application) updateUserPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	email, ok := app.readUserEmail(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Grant  []string `json:"grant"`
+		Revoke []string `json:"revoke"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(len(input.Grant)+len(input.Revoke) > 0, "grant", "must provide codes to grant or revoke")
+	for _, code := range input.Grant {
+		v.Check(validator.PermittedValue(code, users.PermissionCodes...), "grant", fmt.Sprintf("unknown permission %q", code))
+	}
+	for _, code := range input.Revoke {
+		v.Check(validator.PermittedValue(code, users.PermissionCodes...), "revoke", fmt.Sprintf("unknown permission %q", code))
+		v.Check(!validator.PermittedValue(code, input.Grant...), "revoke", fmt.Sprintf("permission %q can't be granted and revoked at once", code))
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.services.Permissions.AddForUser(email, input.Grant...)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.services.Permissions.RemoveForUser(email, input.Revoke...)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	permissions, err := app.services.Permissions.GetAllForUser(email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"permissions": permissions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readUserEmail returns the email named by the {email} route variable, or sends a not
+// found response and returns false when there is no such user.
+func (app *application) readUserEmail(w http.ResponseWriter, r *http.Request) (string, bool) {
+	email := mux.Vars(r)["email"]
+
+	_, err := app.services.Users.GetByEmail(email)
+	if err != nil {
+		switch {
+		case errors.Is(err, common.ErrorRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return "", false
+	}
+
+	return email, true
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/jplindgren/rpg-vault/internal/users"
+)
+
+func TestRequirePermission(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app)
+
+	reader, _ := app.newTestUser(t, "reader@example.com", users.PermissionWorldsRead)
+	writer, _ := app.newTestUser(t, "writer@example.com")
+
+	tests := []struct {
+		name     string
+		token    string
+		method   string
+		path     string
+		body     interface{}
+		wantCode int
+	}{
+		{"anonymous", "", http.MethodGet, "/v1/worlds", nil, http.StatusUnauthorized},
+		{"read with worlds:read", reader, http.MethodGet, "/v1/worlds", nil, http.StatusOK},
+		{"write without worlds:write", reader, http.MethodPost, "/v1/worlds", map[string]string{"name": "Eberron"}, http.StatusForbidden},
+		{"characters without characters:read", reader, http.MethodGet, "/v1/characters", nil, http.StatusForbidden},
+		{"write with worlds:write", writer, http.MethodPost, "/v1/worlds", map[string]string{"name": "Eberron"}, http.StatusCreated},
+		{"admin endpoint without users:admin", writer, http.MethodGet, "/v1/users/reader@example.com/permissions", nil, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _ := ts.do(t, tt.method, tt.path, tt.token, tt.body)
+			if code != tt.wantCode {
+				t.Errorf("%s %s = %d, want %d", tt.method, tt.path, code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestUserPermissionsHandlers(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app)
+
+	admin, _ := app.newTestUser(t, "admin@example.com", users.PermissionUsersAdmin)
+	app.newTestUser(t, "alice@example.com", users.PermissionWorldsRead)
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		body     interface{}
+		wantCode int
+		want     []string
+	}{
+		{"get", http.MethodGet, "/v1/users/alice@example.com/permissions", nil, http.StatusOK, []string{users.PermissionWorldsRead}},
+		{"unknown user", http.MethodGet, "/v1/users/bob@example.com/permissions", nil, http.StatusNotFound, nil},
+		{"grant", http.MethodPatch, "/v1/users/alice@example.com/permissions", map[string][]string{"grant": {users.PermissionWorldsWrite}}, http.StatusOK, []string{users.PermissionWorldsRead, users.PermissionWorldsWrite}},
+		{"revoke", http.MethodPatch, "/v1/users/alice@example.com/permissions", map[string][]string{"revoke": {users.PermissionWorldsRead}}, http.StatusOK, []string{users.PermissionWorldsWrite}},
+		{"nothing to change", http.MethodPatch, "/v1/users/alice@example.com/permissions", map[string][]string{}, http.StatusUnprocessableEntity, nil},
+		{"unknown code", http.MethodPatch, "/v1/users/alice@example.com/permissions", map[string][]string{"grant": {"worlds:burn"}}, http.StatusUnprocessableEntity, nil},
+		{"grant and revoke the same code", http.MethodPatch, "/v1/users/alice@example.com/permissions", map[string][]string{"grant": {users.PermissionWorldsRead}, "revoke": {users.PermissionWorldsRead}}, http.StatusUnprocessableEntity, nil},
+		{"update an unknown user", http.MethodPatch, "/v1/users/bob@example.com/permissions", map[string][]string{"grant": {users.PermissionWorldsRead}}, http.StatusNotFound, nil},
+	}
+
+	// The cases run in order, each one seeing the codes left by the previous ones.
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, data := ts.do(t, tt.method, tt.path, admin, tt.body)
+			if code != tt.wantCode {
+				t.Fatalf("%s %s = %d, want %d", tt.method, tt.path, code, tt.wantCode)
+			}
+			if tt.want == nil {
+				return
+			}
+
+			var got []string
+			for _, code := range data["permissions"].([]interface{}) {
+				got = append(got, code.(string))
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("permissions = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	common "github.com/jplindgren/rpg-vault/internal"
+	"github.com/jplindgren/rpg-vault/internal/characters"
+	"github.com/jplindgren/rpg-vault/internal/dice"
+	"github.com/jplindgren/rpg-vault/internal/rolls"
+	"github.com/jplindgren/rpg-vault/internal/storage"
+	"github.com/jplindgren/rpg-vault/internal/users"
+	"github.com/jplindgren/rpg-vault/internal/validator"
+)
+
+// rollDiceHandler rolls a dice expression and adds the roll to the log of the world.
+// Expressions referring to attributes, like "1d20+@str_mod", take them from a character
+// the user is allowed to edit.
+func (app *application) rollDiceHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+	world, _ := app.contextGetWorld(r)
+
+	var input struct {
+		Expression  string
+		Mode        string
+		CharacterId string
+		Reason      string
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	roll := &rolls.Roll{
+		WorldId:     world.Id,
+		UserId:      user.Email,
+		CharacterId: input.CharacterId,
+		Expression:  input.Expression,
+		Mode:        input.Mode,
+		Reason:      input.Reason,
+	}
+
+	v := validator.New()
+	if rolls.ValidateRoll(v, roll); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	expression, err := dice.Parse(roll.Expression)
+	if err != nil {
+		v.AddError("expression", err.Error())
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	roll.Expression = expression.String()
+
+	if roll.Mode != "" {
+		err = expression.SetMode(roll.Mode)
+		if err != nil {
+			v.AddError("mode", err.Error())
+		}
+	}
+
+	v.Check(roll.CharacterId != "" || len(expression.Attributes()) == 0, "characterId", "must be provided to refer to attributes")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	var values map[string]float64
+	if roll.CharacterId != "" {
+		character, ok := app.readRollCharacter(w, r, roll.CharacterId)
+		if !ok {
+			return
+		}
+
+		values = numericAttributes(character)
+		for _, name := range expression.Attributes() {
+			_, found := values[name]
+			v.Check(found, "expression", "the character has no numeric attribute "+name)
+		}
+		if !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+	}
+
+	roll.Result, err = expression.Roll(values)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.services.Rolls.Insert(roll)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"roll": roll}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readRollCharacter loads the character a roll refers to, which must belong to the
+// world and be editable by the user, who must also be allowed to read characters.
+func (app *application) readRollCharacter(w http.ResponseWriter, r *http.Request, id string) (*characters.Character, bool) {
+	world, _ := app.contextGetWorld(r)
+
+	permissions, err := app.userPermissions(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return nil, false
+	}
+	if !permissions.Include(users.PermissionCharactersRead) {
+		app.notPermittedResponse(w, r)
+		return nil, false
+	}
+
+	character, err := app.services.Characters.Get(world.Id, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, common.ErrorRecordNotFound):
+			app.failedValidationResponse(w, r, map[string]string{"characterId": "must be a character of the world"})
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	if !app.canEditCharacter(r, character) {
+		app.notPermittedResponse(w, r)
+		return nil, false
+	}
+
+	return character, true
+}
+
+// numericAttributes returns the attributes of a character that dice expressions can
+// refer to.
+func numericAttributes(character *characters.Character) map[string]float64 {
+	values := make(map[string]float64)
+	for name, value := range character.Attributes {
+		if n, ok := value.(float64); ok {
+			values[name] = n
+		}
+	}
+	return values
+}
+
+// listRollsHandler returns a page of the roll log of a world, newest first.
+func (app *application) listRollsHandler(w http.ResponseWriter, r *http.Request) {
+	world, _ := app.contextGetWorld(r)
+
+	v := validator.New()
+	scope := "rolls:" + world.Id
+	limit, cursor := app.readPage(r.URL.Query(), scope, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	result, next, err := app.services.Rolls.List(world.Id, limit, cursor.Key)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	nextCursor, err := app.cursors.Encode(scope, storage.Cursor{Key: next})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	meta := metadata{Limit: limit, NextCursor: nextCursor}
+	err = app.writeJSON(w, http.StatusOK, envelope{"rolls": result, "metadata": meta}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"net/http"
 
 	"github.com/gorilla/mux"
+	"github.com/jplindgren/rpg-vault/internal/worlds"
 )
 
 func (app *application) routes() *mux.Router {
@@ -14,22 +15,69 @@ func (app *application) routes() *mux.Router {
 
 	router.HandleFunc("/v1/healthcheck", app.healthcheckHandler).Methods("GET")
 
+	// Files are only served by the API when they are kept on the local disk.
+	if app.config.Blobs.Kind == "disk" {
+		router.HandleFunc("/v1/files/{path:.+}", app.serveFileHandler).Methods("GET")
+	}
+
 	router.HandleFunc("/v1/worlds", app.requirePermission("worlds:write", app.createNewWorldHandler)).Methods("POST")
-	router.HandleFunc("/v1/worlds/{id}", app.requirePermission("worlds:read", app.getWorldHandler)).Methods("GET")
+	router.HandleFunc("/v1/worlds/{worldId}", app.requirePermission("worlds:read", app.requireWorldRole(worlds.RoleViewer, app.getWorldHandler))).Methods("GET")
 	router.HandleFunc("/v1/worlds", app.requirePermission("worlds:read", app.listMyWorldsHandler)).Methods("GET")
-	router.HandleFunc("/v1/worlds/{id}", app.requirePermission("worlds:write", app.updateWorldHandler)).Methods("PATCH")
-	router.HandleFunc("/v1/worlds/{id}", app.requirePermission("worlds:write", app.deleteWorldHandler)).Methods("DELETE")
+	router.HandleFunc("/v1/worlds/{worldId}", app.requirePermission("worlds:write", app.requireWorldRole(worlds.RoleGameMaster, app.updateWorldHandler))).Methods("PATCH")
+	router.HandleFunc("/v1/worlds/{worldId}", app.requirePermission("worlds:write", app.requireWorldRole(worlds.RoleOwner, app.deleteWorldHandler))).Methods("DELETE")
+	router.HandleFunc("/v1/worlds/{worldId}/restore", app.requirePermission("worlds:write", app.restoreWorldHandler)).Methods("POST")
+
+	router.HandleFunc("/v1/worlds/{worldId}/members", app.requirePermission("worlds:read", app.requireWorldRole(worlds.RoleViewer, app.listWorldMembersHandler))).Methods("GET")
+	router.HandleFunc("/v1/worlds/{worldId}/members", app.requirePermission("worlds:write", app.requireWorldRole(worlds.RoleGameMaster, app.inviteWorldMemberHandler))).Methods("POST")
+	router.HandleFunc("/v1/worlds/{worldId}/members/accept", app.requirePermission("worlds:read", app.acceptWorldInvitationHandler)).Methods("POST")
+	router.HandleFunc("/v1/worlds/{worldId}/members/{email}", app.requirePermission("worlds:read", app.removeWorldMemberHandler)).Methods("DELETE")
+
+	router.HandleFunc("/v1/worlds/{worldId}/characters", app.requirePermission("characters:write", app.requireWorldRole(worlds.RolePlayer, app.createCharacterHandler))).Methods("POST")
+	router.HandleFunc("/v1/worlds/{worldId}/characters/{id}", app.requirePermission("characters:read", app.requireWorldRole(worlds.RoleViewer, app.getCharacterHandler))).Methods("GET")
+	router.HandleFunc("/v1/worlds/{worldId}/characters", app.requirePermission("characters:read", app.requireWorldRole(worlds.RoleViewer, app.listCharacterHandler))).Methods("GET")
+	router.HandleFunc("/v1/worlds/{worldId}/characters/{id}", app.requirePermission("characters:write", app.requireWorldRole(worlds.RolePlayer, app.updateCharacterHandler))).Methods("PATCH")
+	router.HandleFunc("/v1/worlds/{worldId}/characters/{id}", app.requirePermission("characters:write", app.requireWorldRole(worlds.RolePlayer, app.deleteCharacterHandler))).Methods("DELETE")
+	router.HandleFunc("/v1/worlds/{worldId}/characters/{id}/restore", app.requirePermission("characters:write", app.requireWorldRole(worlds.RolePlayer, app.restoreCharacterHandler))).Methods("POST")
+	router.HandleFunc("/v1/worlds/{worldId}/characters/{id}/owner", app.requirePermission("characters:write", app.requireWorldRole(worlds.RoleGameMaster, app.updateCharacterOwnerHandler))).Methods("PUT")
+	router.HandleFunc("/v1/worlds/{worldId}/characters/{id}/inventory", app.requirePermission("characters:read", app.requireWorldRole(worlds.RoleViewer, app.listInventoryHandler))).Methods("GET")
+	router.HandleFunc("/v1/worlds/{worldId}/characters/{id}/inventory", app.requirePermission("characters:write", app.requireWorldRole(worlds.RolePlayer, app.addInventoryEntryHandler))).Methods("POST")
+	router.HandleFunc("/v1/worlds/{worldId}/characters/{id}/inventory/{entryId}", app.requirePermission("characters:write", app.requireWorldRole(worlds.RolePlayer, app.updateInventoryEntryHandler))).Methods("PATCH")
+	router.HandleFunc("/v1/worlds/{worldId}/characters/{id}/inventory/{entryId}", app.requirePermission("characters:write", app.requireWorldRole(worlds.RolePlayer, app.deleteInventoryEntryHandler))).Methods("DELETE")
+	router.HandleFunc("/v1/worlds/{worldId}/characters/{id}/inventory/{entryId}/transfer", app.requirePermission("characters:write", app.requireWorldRole(worlds.RolePlayer, app.transferInventoryEntryHandler))).Methods("POST")
+	router.HandleFunc("/v1/worlds/{worldId}/locations", app.requirePermission("worlds:write", app.requireWorldRole(worlds.RoleGameMaster, app.createLocationHandler))).Methods("POST")
+	router.HandleFunc("/v1/worlds/{worldId}/locations/{id}", app.requirePermission("worlds:read", app.requireWorldRole(worlds.RoleViewer, app.getLocationHandler))).Methods("GET")
+	router.HandleFunc("/v1/worlds/{worldId}/locations", app.requirePermission("worlds:read", app.requireWorldRole(worlds.RoleViewer, app.listLocationsHandler))).Methods("GET")
+	router.HandleFunc("/v1/worlds/{worldId}/locations/{id}", app.requirePermission("worlds:write", app.requireWorldRole(worlds.RoleGameMaster, app.updateLocationHandler))).Methods("PATCH")
+	router.HandleFunc("/v1/worlds/{worldId}/locations/{id}", app.requirePermission("worlds:write", app.requireWorldRole(worlds.RoleGameMaster, app.deleteLocationHandler))).Methods("DELETE")
+
+	router.HandleFunc("/v1/worlds/{worldId}/items", app.requirePermission("worlds:write", app.requireWorldRole(worlds.RoleGameMaster, app.createItemHandler))).Methods("POST")
+	router.HandleFunc("/v1/worlds/{worldId}/items/{id}", app.requirePermission("worlds:read", app.requireWorldRole(worlds.RoleViewer, app.getItemHandler))).Methods("GET")
+	router.HandleFunc("/v1/worlds/{worldId}/items", app.requirePermission("worlds:read", app.requireWorldRole(worlds.RoleViewer, app.listItemsHandler))).Methods("GET")
+	router.HandleFunc("/v1/worlds/{worldId}/items/{id}", app.requirePermission("worlds:write", app.requireWorldRole(worlds.RoleGameMaster, app.updateItemHandler))).Methods("PATCH")
+	router.HandleFunc("/v1/worlds/{worldId}/items/{id}", app.requirePermission("worlds:write", app.requireWorldRole(worlds.RoleGameMaster, app.deleteItemHandler))).Methods("DELETE")
+
+	router.HandleFunc("/v1/worlds/{worldId}/rolls", app.requirePermission("worlds:read", app.requireWorldRole(worlds.RolePlayer, app.rollDiceHandler))).Methods("POST")
+	router.HandleFunc("/v1/worlds/{worldId}/rolls", app.requirePermission("worlds:read", app.requireWorldRole(worlds.RoleViewer, app.listRollsHandler))).Methods("GET")
+
+	router.HandleFunc("/v1/characters", app.requirePermission("characters:read", app.listMyCharactersHandler)).Methods("GET")
 
-	router.HandleFunc("/v1/worlds/{worldId}/characters", app.requirePermission("characters:write", app.createCharacterHandler)).Methods("POST")
-	router.HandleFunc("/v1/worlds/{worldId}/characters/{id}", app.requirePermission("characters:read", app.getCharacterHandler)).Methods("GET")
-	router.HandleFunc("/v1/worlds/{worldId}/characters", app.requirePermission("characters:read", app.listCharacterHandler)).Methods("GET")
-	router.HandleFunc("/v1/worlds/{worldId}/characters/{id}", app.requirePermission("characters:write", app.updateCharacterHandler)).Methods("PATCH")
-	router.HandleFunc("/v1/worlds/{worldId}/characters/{id}", app.requirePermission("characters:write", app.deleteCharacterHandler)).Methods("DELETE")
+	router.HandleFunc("/v1/systems", app.requirePermission("worlds:read", app.listSystemsHandler)).Methods("GET")
+	router.HandleFunc("/v1/search", app.requirePermission("worlds:read", app.searchHandler)).Methods("GET")
+	router.HandleFunc("/v1/trash", app.requirePermission("worlds:read", app.listTrashHandler)).Methods("GET")
 
 	router.HandleFunc("/v1/users", app.registerUserHandler).Methods("POST")
-	//router.HandleFunc("/v1/users/activated", app.activateUserHandler).Me	thods("PUT")
+	router.HandleFunc("/v1/users/activated", app.activateUserHandler).Methods("PUT")
+	router.HandleFunc("/v1/users/me", app.requireActivatedUser(app.updateMyUserHandler)).Methods("PATCH")
+	router.HandleFunc("/v1/users/password", app.updateUserPasswordHandler).Methods("PUT")
+	router.HandleFunc("/v1/users/{email}/permissions", app.requirePermission("users:admin", app.getUserPermissionsHandler)).Methods("GET")
+	router.HandleFunc("/v1/users/{email}/permissions", app.requirePermission("users:admin", app.updateUserPermissionsHandler)).Methods("PATCH")
 
 	router.HandleFunc("/v1/tokens/authentication", app.generateAuthenticationTokenHandler).Methods("POST")
+	router.HandleFunc("/v1/tokens/refresh", app.refreshAuthenticationTokenHandler).Methods("POST")
+	router.HandleFunc("/v1/tokens/authentication", app.requireAuthenticatedUser(app.listSessionsHandler)).Methods("GET")
+	router.HandleFunc("/v1/tokens/authentication", app.requireAuthenticatedUser(app.deleteAuthenticationTokenHandler)).Methods("DELETE")
+	router.HandleFunc("/v1/tokens", app.requireAuthenticatedUser(app.deleteAllTokensHandler)).Methods("DELETE")
+	router.HandleFunc("/v1/tokens/password-reset", app.createPasswordResetTokenHandler).Methods("POST")
 
 	return router
 }
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	common "github.com/jplindgren/rpg-vault/internal"
+	"github.com/jplindgren/rpg-vault/internal/search"
+	"github.com/jplindgren/rpg-vault/internal/users"
+	"github.com/jplindgren/rpg-vault/internal/validator"
+	"github.com/jplindgren/rpg-vault/internal/worlds"
+)
+
+func (app *application) searchHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	qs := r.URL.Query()
+
+	v := validator.New()
+
+	query := app.readString(qs, "q", "")
+	v.Check(query != "", "q", "must be provided")
+	v.Check(len(query) <= 200, "q", "must not be more than 200 bytes long")
+
+	limit := app.readInt(qs, "limit", 20, v)
+	v.Check(limit > 0, "limit", "must be greater than zero")
+	v.Check(limit <= 100, "limit", "must be a maximum of 100")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	memberships, err := app.services.Members.ListForUser(user.Email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	scope := search.Scope{
+		UserId: user.Email,
+		Shared: make(map[string]bool),
+		Kinds:  []string{search.KindWorld},
+	}
+
+	for _, member := range *memberships {
+		if member.Status == worlds.MemberAccepted {
+			scope.Shared[member.WorldId] = true
+		}
+	}
+
+	// Characters are only searched for users allowed to read them.
+	permissions, err := app.userPermissions(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if permissions.Include(users.PermissionCharactersRead) {
+		scope.Kinds = append(scope.Kinds, search.KindCharacter)
+	}
+
+	results := app.services.Search.Search(query, scope, limit)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"results": results}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// reindexSearch runs for as long as the API does, rebuilding the search index every
+// reindex interval, so an instance picks up the changes made through the others.
+func (app *application) reindexSearch() {
+	for {
+		time.Sleep(app.config.Search.ReindexInterval)
+
+		var indexed int
+		err := common.Guard(func() error {
+			var err error
+			indexed, err = app.services.Reindex()
+			return err
+		})
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{"job": "reindex search"})
+			continue
+		}
+
+		app.logger.PrintInfo("search index rebuilt", map[string]string{
+			"documents": strconv.Itoa(indexed),
+		})
+	}
+}
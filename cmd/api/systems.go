@@ -0,0 +1,16 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/jplindgren/rpg-vault/internal/sheets"
+)
+
+// listSystemsHandler returns the game systems worlds can be created from, along with
+// their sheets and dice conventions.
+func (app *application) listSystemsHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.writeJSON(w, http.StatusOK, envelope{"systems": sheets.Systems()}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	common "github.com/jplindgren/rpg-vault/internal"
+	"github.com/jplindgren/rpg-vault/internal/config"
+	"github.com/jplindgren/rpg-vault/internal/jsonlog"
+	"github.com/jplindgren/rpg-vault/internal/mailer"
+	"github.com/jplindgren/rpg-vault/internal/search"
+	"github.com/jplindgren/rpg-vault/internal/services"
+	"github.com/jplindgren/rpg-vault/internal/storage"
+	"github.com/jplindgren/rpg-vault/internal/uploader"
+	"github.com/jplindgren/rpg-vault/internal/users"
+)
+
+// newTestApplication returns an application keeping everything in memory, with the
+// default settings.
+func newTestApplication(t *testing.T) *application {
+	cfg := config.Default()
+	cfg.Storage.Kind = "memory"
+	cfg.Blobs.Kind = "disk"
+	cfg.Blobs.Dir = t.TempDir()
+
+	store := storage.NewMemory(services.Tables(services.TableNames(cfg.Storage.Tables))...)
+
+	blobs, err := uploader.NewDiskStore(cfg.Blobs.Dir, "http://localhost/v1/files")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &application{
+		config:   cfg,
+		logger:   jsonlog.New(io.Discard, jsonlog.LevelOff),
+		services: services.NewServices(store, blobs, search.NewIndex(), services.TableNames(cfg.Storage.Tables), users.PermissionSettings(cfg.Permissions)),
+		mailer:   mailer.NewLogSink(io.Discard),
+		cursors:  storage.NewCursorSigner([]byte("0123456789abcdef0123456789abcdef")),
+	}
+}
+
+type testServer struct {
+	*httptest.Server
+}
+
+// newTestServer serves the routes of the application behind the authentication
+// middleware, leaving out rate limiting and CORS.
+func newTestServer(t *testing.T, app *application) *testServer {
+	ts := httptest.NewServer(app.recoverPanic(app.authenticate(app.routes())))
+	t.Cleanup(ts.Close)
+	return &testServer{ts}
+}
+
+// do sends a request with a JSON body, authenticated with token unless it's empty, and
+// returns the status code and the decoded JSON response.
+func (ts *testServer) do(t *testing.T, method, path, token string, body interface{}) (int, map[string]interface{}) {
+	var buf bytes.Buffer
+	if body != nil {
+		err := json.NewEncoder(&buf).Encode(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	req, err := http.NewRequest(method, ts.URL+path, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	res, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	var decoded map[string]interface{}
+	err = json.NewDecoder(res.Body).Decode(&decoded)
+	if err != nil && err != io.EOF {
+		t.Fatalf("%s %s: decoding the response: %v", method, path, err)
+	}
+
+	return res.StatusCode, decoded
+}
+
+// newTestUser registers an activated user holding the default permissions and returns
+// their authentication and refresh tokens. The password isn't set, as the tokens are
+// issued directly.
+func (app *application) newTestUser(t *testing.T, email string, permissions ...string) (string, string) {
+	user := users.NewUser(email, "Test User", common.GetIsoString(), []byte("not a hash"), 1, true)
+	if permissions == nil {
+		permissions = app.config.Permissions.Defaults
+	}
+
+	err := app.services.Users.Insert(user, permissions...)
+	if err != nil {
+		t.Fatalf("inserting user %s: %v", email, err)
+	}
+
+	env, err := app.newTokenPair(user, common.GenerateToken())
+	if err != nil {
+		t.Fatalf("issuing tokens for %s: %v", email, err)
+	}
+
+	return env["authentication_token"].(*users.Token).Plaintext, env["refresh_token"].(*users.Token).Plaintext
+}
+
+// field walks a decoded JSON response down the given keys.
+func field(data map[string]interface{}, keys ...string) interface{} {
+	var value interface{} = data
+	for _, key := range keys {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = m[key]
+	}
+	return value
+}
+
+// newTestWorld creates a world owned by the user of token and returns its id.
+func (ts *testServer) newTestWorld(t *testing.T, token, name string) string {
+	code, data := ts.do(t, http.MethodPost, "/v1/worlds", token, map[string]string{"name": name})
+	if code != http.StatusCreated {
+		t.Fatalf("creating world %s = %d, want %d", name, code, http.StatusCreated)
+	}
+	return field(data, "world", "id").(string)
+}
+
+// join invites email into the world with the given role on behalf of the owner, and
+// accepts the invitation with token.
+func (ts *testServer) join(t *testing.T, worldId, owner, email, token, role string) {
+	path := "/v1/worlds/" + worldId + "/members"
+
+	code, _ := ts.do(t, http.MethodPost, path, owner, map[string]string{"email": email, "role": role})
+	if code != http.StatusCreated {
+		t.Fatalf("inviting %s = %d, want %d", email, code, http.StatusCreated)
+	}
+
+	code, _ = ts.do(t, http.MethodPost, path+"/accept", token, nil)
+	if code != http.StatusOK {
+		t.Fatalf("accepting the invitation of %s = %d, want %d", email, code, http.StatusOK)
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	common "github.com/jplindgren/rpg-vault/internal"
+	"github.com/jplindgren/rpg-vault/internal/characters"
+	"github.com/jplindgren/rpg-vault/internal/storage"
+	"github.com/jplindgren/rpg-vault/internal/users"
+	"github.com/jplindgren/rpg-vault/internal/validator"
+	"github.com/jplindgren/rpg-vault/internal/worlds"
+)
+
+// The trash lists the deleted worlds of the user first, then their deleted characters,
+// and the cursor names the set it stopped in.
+const (
+	trashSetWorlds     = "worlds"
+	trashSetCharacters = "characters"
+)
+
+func (app *application) listTrashHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	v := validator.New()
+	limit, cursor := app.readPage(r.URL.Query(), "trash:"+user.Email, v)
+	v.Check(validator.PermittedValue(cursor.Set, "", trashSetWorlds, trashSetCharacters), "cursor", "is invalid")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Characters are only listed for users allowed to read them.
+	permissions, err := app.userPermissions(r)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	listCharacters := permissions.Include(users.PermissionCharactersRead)
+
+	deletedWorlds := []worlds.World{}
+	deletedCharacters := []characters.Character{}
+	var next storage.Cursor
+
+	if cursor.Set != trashSetCharacters {
+		result, key, err := app.services.Worlds.ListDeleted(user.Email, limit, cursor.Key)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		deletedWorlds = append(deletedWorlds, *result...)
+
+		switch {
+		case key != nil:
+			next = storage.Cursor{Set: trashSetWorlds, Key: key}
+		case !listCharacters:
+			// The worlds are all there is to list.
+		case len(deletedWorlds) == limit:
+			next = storage.Cursor{Set: trashSetCharacters}
+		default:
+			cursor = storage.Cursor{Set: trashSetCharacters}
+		}
+	}
+
+	if cursor.Set == trashSetCharacters && listCharacters {
+		result, key, err := app.services.Characters.ListDeleted(user.Email, limit-len(deletedWorlds), cursor.Key)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		deletedCharacters = append(deletedCharacters, *result...)
+
+		if key != nil {
+			next = storage.Cursor{Set: trashSetCharacters, Key: key}
+		}
+	}
+
+	nextCursor, err := app.cursors.Encode("trash:"+user.Email, next)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	meta := metadata{Limit: limit, NextCursor: nextCursor}
+	err = app.writeJSON(w, http.StatusOK, envelope{"worlds": deletedWorlds, "characters": deletedCharacters, "metadata": meta}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// restoreWorldHandler takes a world out of the trash. Only the owner of a world can
+// delete it, so only they can restore it.
+func (app *application) restoreWorldHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+	worldId := mux.Vars(r)["worldId"]
+
+	world, err := app.services.Worlds.Restore(user.Email, worldId)
+	if err != nil {
+		switch {
+		case errors.Is(err, common.ErrorRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	world.Role = worlds.RoleOwner
+	err = app.writeJSON(w, http.StatusOK, envelope{"world": world}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// restoreCharacterHandler takes a character out of the trash. The same members who can
+// delete a character can restore it, as long as its world isn't in the trash itself.
+func (app *application) restoreCharacterHandler(w http.ResponseWriter, r *http.Request) {
+	world, _ := app.contextGetWorld(r)
+	id := mux.Vars(r)["id"]
+
+	character, err := app.services.Characters.GetDeleted(world.Id, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, common.ErrorRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !app.canEditCharacter(r, character) {
+		app.notPermittedResponse(w, r)
+		return
+	}
+
+	character, err = app.services.Characters.Restore(world.Id, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, common.ErrorRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"character": character}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// purgeTrash runs for as long as the API does, permanently deleting the worlds and
+// characters which have been in the trash for longer than the retention period.
+// Failures, panics included, are logged and the purge tries again on the next run.
+func (app *application) purgeTrash() {
+	for {
+		time.Sleep(app.config.Trash.PurgeInterval)
+
+		before := time.Now().Add(-app.config.Trash.Retention)
+
+		var purgedWorlds, purgedCharacters int
+		err := common.Guard(func() error {
+			var err error
+			purgedWorlds, err = app.services.Worlds.PurgeDeleted(before)
+			return err
+		})
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{"job": "purge trash"})
+		}
+
+		err = common.Guard(func() error {
+			var err error
+			purgedCharacters, err = app.services.Characters.PurgeDeleted(before)
+			return err
+		})
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{"job": "purge trash"})
+		}
+
+		if purgedWorlds > 0 || purgedCharacters > 0 {
+			app.logger.PrintInfo("trash purged", map[string]string{
+				"worlds":     strconv.Itoa(purgedWorlds),
+				"characters": strconv.Itoa(purgedCharacters),
+			})
+		}
+	}
+}
@@ -1,9 +1,13 @@
 package main
 
 import (
+	"bytes"
+	"errors"
 	"net/http"
 	"time"
 
+	common "github.com/jplindgren/rpg-vault/internal"
+	"github.com/jplindgren/rpg-vault/internal/jwt"
 	"github.com/jplindgren/rpg-vault/internal/users"
 	"github.com/jplindgren/rpg-vault/internal/validator"
 )
@@ -30,9 +34,13 @@ func (app application) generateAuthenticationTokenHandler(w http.ResponseWriter,
 	}
 
 	user, err := app.services.Users.GetByEmail(input.Email)
-
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, common.ErrorRecordNotFound):
+			app.invalidCredentialsResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
@@ -47,15 +55,314 @@ func (app application) generateAuthenticationTokenHandler(w http.ResponseWriter,
 		return
 	}
 
-	token, err := app.services.Tokens.New(input.Email, time.Hour*24, users.Authentication)
+	// Every login starts a new token family.
+	env, err := app.newTokenPair(user, common.GenerateToken())
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+}
+
+// refreshAuthenticationTokenHandler exchanges a refresh token for a new authentication
+// token and a new refresh token. Refresh tokens can only be used once: presenting one
+// that has already been exchanged means it has leaked, so the whole token family is
+// revoked and the user has to log in again.
+func (app application) refreshAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if users.ValidateTokenPlaintext(v, input.RefreshToken); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	token, err := app.services.Tokens.GetForScope(users.ScopeRefresh, input.RefreshToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, common.ErrorRecordNotFound):
+			app.invalidCredentialsResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !token.Used {
+		err = app.services.Tokens.MarkUsed(token)
+	} else {
+		err = users.ErrorTokenReused
+	}
+
+	if err != nil {
+		switch {
+		case errors.Is(err, users.ErrorTokenReused):
+			app.revokeTokenFamily(w, r, token)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user, err := app.services.Users.GetByEmail(token.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, common.ErrorRecordNotFound):
+			app.invalidCredentialsResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	env, err := app.newTokenPair(user, token.Family)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// revokeTokenFamily handles the reuse of a refresh token by deleting every token of its
+// family and rejecting the request.
+func (app *application) revokeTokenFamily(w http.ResponseWriter, r *http.Request, token *users.Token) {
+	app.logger.PrintInfo("refresh token reused, revoking token family", map[string]string{
+		"email":  token.Email,
+		"family": token.Family,
+	})
+
+	err := app.services.Tokens.DeleteFamily(token.Email, token.Family)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// JWTs of the family can't be deleted, so deny every token of the user instead.
+	if app.denylist != nil {
+		app.denylist.RevokeAll(token.Email, app.config.Auth.JWTTTL)
+	}
+
+	app.invalidCredentialsResponse(w, r)
+}
+
+// newTokenPair issues an authentication token together with the refresh token used to
+// renew it, both belonging to the given token family.
+func (app *application) newTokenPair(user *users.User, family string) (envelope, error) {
+	token, err := app.newAuthenticationToken(user, family)
+	if err != nil {
+		return nil, err
+	}
+
+	refresh, err := app.services.Tokens.NewInFamily(user.Email, app.config.Auth.RefreshTTL, users.ScopeRefresh, family)
+	if err != nil {
+		return nil, err
+	}
+
+	return envelope{"authentication_token": token, "refresh_token": refresh}, nil
+}
+
+// newAuthenticationToken issues an authentication token for the user: a signed JWT in
+// "jwt" mode, or an opaque token stored in the database otherwise.
+func (app *application) newAuthenticationToken(user *users.User, family string) (*users.Token, error) {
+	if app.jwt == nil {
+		return app.services.Tokens.NewInFamily(user.Email, app.config.Auth.TokenTTL, users.Authentication, family)
+	}
+
+	permissions, err := app.services.Permissions.GetAllForUser(user.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	expiry := now.Add(app.config.Auth.JWTTTL)
+
+	claims := jwt.Claims{
+		ID:           common.GenerateToken(),
+		Subject:      user.Email,
+		IssuedAt:     now.Unix(),
+		IssuedAtNano: now.UnixNano(),
+		NotBefore:    now.Unix(),
+		ExpiresAt:    expiry.Unix(),
+		Name:         user.Name,
+		Activated:    user.Activated,
+		Permissions:  permissions,
+		Family:       family,
+	}
+
+	plaintext, err := app.jwt.Sign(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	return &users.Token{
+		Plaintext: plaintext,
+		Email:     user.Email,
+		Expiry:    expiry,
+		Scope:     users.Authentication,
+		CreatedAt: now,
+		Family:    family,
+	}, nil
+}
+
+// Generate a password reset token and send it to the user's email address.
+func (app application) createPasswordResetTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if users.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.services.Users.GetByEmail(input.Email)
+	if err != nil {
+		switch {
+		case errors.Is(err, common.ErrorRecordNotFound):
+			v.AddError("email", "no matching email address found")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Return an error message if the user is not activated.
+	if !user.Activated {
+		v.AddError("email", "user account must be activated")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Otherwise, create a new password reset token with a 45-minute expiry time.
+	token, err := app.services.Tokens.New(user.Email, 45*time.Minute, users.ScopePasswordReset)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": token}, nil)
+	app.background(func() {
+		data := map[string]interface{}{
+			"passwordResetToken": token.Plaintext,
+		}
+
+		err := app.mailer.Send(user.Email, "token_password_reset.tmpl", data)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	})
+
+	env := envelope{"message": "an email will be sent to you containing password reset instructions"}
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// listSessionsHandler lists the active authentication tokens of the current user.
+func (app application) listSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+	current := app.contextGetToken(r)
+
+	tokens, err := app.services.Tokens.ListActiveForUser(users.Authentication, user.Email)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
+
+	sessions := make([]users.Session, len(tokens))
+	for i, token := range tokens {
+		sessions[i] = token.Session()
+		sessions[i].Current = current != nil && bytes.Equal(token.Hash, current.Hash)
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"sessions": sessions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteAuthenticationTokenHandler logs out the current session by revoking the token
+// used to authenticate the request, along with the refresh tokens of its family.
+func (app application) deleteAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var email, family string
+
+	// JWTs can't be deleted, so we deny them until they expire instead.
+	if claims := app.contextGetClaims(r); claims != nil {
+		app.denylist.Revoke(claims)
+		email, family = claims.Subject, claims.Family
+	} else {
+		token := app.contextGetToken(r)
+		if token == nil {
+			app.authenticationRequiredResponse(w, r)
+			return
+		}
+
+		err := app.services.Tokens.Delete(token)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		email, family = token.Email, token.Family
+	}
+
+	// Tokens issued before refresh tokens existed don't belong to any family.
+	if family != "" {
+		err := app.services.Tokens.DeleteFamily(email, family)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"message": "you have been logged out"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteAllTokensHandler revokes every token of the current user, logging them out of
+// all sessions.
+func (app application) deleteAllTokensHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	err := app.services.Tokens.DeleteAll(user.Email)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if app.denylist != nil {
+		app.denylist.RevokeAll(user.Email, app.config.Auth.JWTTTL)
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "all your tokens have been revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
 }
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/jplindgren/rpg-vault/internal/users"
+)
+
+func TestAuthenticate(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app)
+
+	valid, _ := app.newTestUser(t, "alice@example.com")
+
+	expired, err := app.services.Tokens.New("alice@example.com", -time.Minute, users.Authentication)
+	if err != nil {
+		t.Fatal(err)
+	}
+	activation, err := app.services.Tokens.New("alice@example.com", time.Hour, users.ScopeActivation)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		header   string
+		wantCode int
+	}{
+		{"valid", "Bearer " + valid, http.StatusOK},
+		{"expired", "Bearer " + expired.Plaintext, http.StatusUnauthorized},
+		{"activation token", "Bearer " + activation.Plaintext, http.StatusUnauthorized},
+		{"unknown", "Bearer ABCDEFGHIJKLMNOPQRSTUVWXYZ", http.StatusUnauthorized},
+		{"malformed", "Bearer short", http.StatusUnauthorized},
+		{"no scheme", valid, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, ts.URL+"/v1/worlds", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Authorization", tt.header)
+
+			res, err := ts.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+
+			if res.StatusCode != tt.wantCode {
+				t.Errorf("GET /v1/worlds = %d, want %d", res.StatusCode, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestLogout(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app)
+
+	first, firstRefresh := app.newTestUser(t, "alice@example.com")
+	user, err := app.services.Users.GetByEmail("alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	env, err := app.newTokenPair(user, "second")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second := env["authentication_token"].(*users.Token).Plaintext
+	other, _ := app.newTestUser(t, "bob@example.com")
+
+	code, data := ts.do(t, http.MethodGet, "/v1/tokens/authentication", first, nil)
+	if code != http.StatusOK {
+		t.Fatalf("GET /v1/tokens/authentication = %d, want %d", code, http.StatusOK)
+	}
+	if sessions := data["sessions"].([]interface{}); len(sessions) != 2 {
+		t.Errorf("listed %d sessions, want 2", len(sessions))
+	}
+
+	tests := []struct {
+		name     string
+		token    string
+		method   string
+		path     string
+		body     interface{}
+		wantCode int
+	}{
+		{"logout", first, http.MethodDelete, "/v1/tokens/authentication", nil, http.StatusOK},
+		{"logged out token", first, http.MethodGet, "/v1/worlds", nil, http.StatusUnauthorized},
+		{"refresh token of the logged out session", "", http.MethodPost, "/v1/tokens/refresh", map[string]string{"refresh_token": firstRefresh}, http.StatusUnauthorized},
+		{"other session", second, http.MethodGet, "/v1/worlds", nil, http.StatusOK},
+		{"anonymous logout", "", http.MethodDelete, "/v1/tokens", nil, http.StatusUnauthorized},
+		{"revoke all", second, http.MethodDelete, "/v1/tokens", nil, http.StatusOK},
+		{"revoked session", second, http.MethodGet, "/v1/worlds", nil, http.StatusUnauthorized},
+		{"other user", other, http.MethodGet, "/v1/worlds", nil, http.StatusOK},
+	}
+
+	// The cases run in order, each one seeing the tokens left by the previous ones.
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _ := ts.do(t, tt.method, tt.path, tt.token, tt.body)
+			if code != tt.wantCode {
+				t.Errorf("%s %s = %d, want %d", tt.method, tt.path, code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestRefreshToken(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app)
+
+	auth, refresh := app.newTestUser(t, "alice@example.com")
+	_, otherRefresh := app.newTestUser(t, "bob@example.com")
+
+	refreshWith := func(t *testing.T, token string) (int, string, string) {
+		code, data := ts.do(t, http.MethodPost, "/v1/tokens/refresh", "", map[string]string{"refresh_token": token})
+		if code != http.StatusCreated {
+			return code, "", ""
+		}
+		return code, field(data, "authentication_token", "token").(string), field(data, "refresh_token", "token").(string)
+	}
+
+	code, rotatedAuth, rotated := refreshWith(t, refresh)
+	if code != http.StatusCreated {
+		t.Fatalf("refreshing = %d, want %d", code, http.StatusCreated)
+	}
+	if rotated == refresh || rotatedAuth == auth {
+		t.Fatal("refreshing returned the same tokens")
+	}
+
+	code, _ = ts.do(t, http.MethodGet, "/v1/worlds", rotatedAuth, nil)
+	if code != http.StatusOK {
+		t.Fatalf("GET /v1/worlds with the refreshed token = %d, want %d", code, http.StatusOK)
+	}
+
+	// Reusing the first refresh token revokes the whole family, including the tokens
+	// issued by the rotation.
+	tests := []struct {
+		name     string
+		token    string
+		refresh  bool
+		wantCode int
+	}{
+		{"reuse", refresh, true, http.StatusUnauthorized},
+		{"rotated refresh token", rotated, true, http.StatusUnauthorized},
+		{"first authentication token", auth, false, http.StatusUnauthorized},
+		{"rotated authentication token", rotatedAuth, false, http.StatusUnauthorized},
+		{"other user", otherRefresh, true, http.StatusCreated},
+		{"malformed", "short", true, http.StatusUnprocessableEntity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var code int
+			if tt.refresh {
+				code, _, _ = refreshWith(t, tt.token)
+			} else {
+				code, _ = ts.do(t, http.MethodGet, "/v1/worlds", tt.token, nil)
+			}
+			if code != tt.wantCode {
+				t.Errorf("got %d, want %d", code, tt.wantCode)
+			}
+		})
+	}
+}
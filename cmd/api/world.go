@@ -5,8 +5,10 @@ import (
 	"fmt"
 	"net/http"
 
-	"github.com/gorilla/mux"
 	common "github.com/jplindgren/rpg-vault/internal"
+	"github.com/jplindgren/rpg-vault/internal/sheets"
+	"github.com/jplindgren/rpg-vault/internal/storage"
+	"github.com/jplindgren/rpg-vault/internal/uploader"
 	"github.com/jplindgren/rpg-vault/internal/validator"
 	"github.com/jplindgren/rpg-vault/internal/worlds"
 )
@@ -16,6 +18,8 @@ func (app application) createNewWorldHandler(w http.ResponseWriter, r *http.Requ
 		Name   string
 		Genres []string
 		Cover  string
+		System string
+		Sheet  *sheets.Template
 	}
 
 	err := app.readJSON(w, r, &input)
@@ -30,6 +34,17 @@ func (app application) createNewWorldHandler(w http.ResponseWriter, r *http.Requ
 		Name:       input.Name,
 		Genres:     input.Genres,
 		CoverImage: input.Cover,
+		System:     input.System,
+		Sheet:      input.Sheet,
+	}
+	if world.Sheet != nil && len(world.Sheet.Fields) == 0 {
+		world.Sheet = nil
+	}
+
+	// Worlds created from a game system start with its sheet, unless they bring their
+	// own.
+	if system, found := sheets.LookupSystem(world.System); found && world.Sheet == nil {
+		world.Sheet = &system.Sheet
 	}
 
 	v := validator.New()
@@ -39,10 +54,30 @@ func (app application) createNewWorldHandler(w http.ResponseWriter, r *http.Requ
 	}
 
 	err = app.services.Worlds.Insert(world)
+	if err != nil {
+		switch {
+		case errors.Is(err, uploader.ErrorInvalidImage):
+			app.failedValidationResponse(w, r, map[string]string{"cover": err.Error()})
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	owner := &worlds.Member{
+		WorldId: world.Id,
+		Email:   user.Email,
+		OwnerId: world.UserId,
+		Role:    worlds.RoleOwner,
+		Status:  worlds.MemberAccepted,
+	}
+
+	err = app.services.Members.Insert(owner)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
+	world.Role = owner.Role
 
 	headers := make(http.Header)
 	headers.Set("Location", fmt.Sprintf("/v1/worlds/%s", world.Id))
@@ -53,65 +88,140 @@ func (app application) createNewWorldHandler(w http.ResponseWriter, r *http.Requ
 }
 
 func (app application) getWorldHandler(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	id := vars["id"]
-
-	user := app.contextGetUser(r)
-
-	world, err := app.services.Worlds.Get(user.Email, id)
-	if err != nil {
-		switch {
-		case errors.Is(err, common.ErrorRecordNotFound):
-			app.notFoundResponse(w, r)
-		default:
-			app.serverErrorResponse(w, r, err)
-		}
-		return
-	}
+	world, _ := app.contextGetWorld(r)
 
-	app.writeJSON(w, http.StatusOK, envelope{"world": world}, nil)
+	err := app.writeJSON(w, http.StatusOK, envelope{"world": world}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 }
 
+// The worlds listing pages through the worlds created by the user first, and then
+// through the worlds shared with them. The cursor records which of the two it's in.
+// The shared worlds are listed in the order the user joined them, as the memberships
+// don't hold the attributes of the worlds, and they are filtered after being read, so
+// their pages may be shorter than the limit.
+const (
+	worldSetOwned  = "owned"
+	worldSetShared = "shared"
+)
+
 func (app application) listMyWorldsHandler(w http.ResponseWriter, r *http.Request) {
 	user := app.contextGetUser(r)
 
-	worlds, err := app.services.Worlds.List(user.Email)
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
-	}
+	qs := r.URL.Query()
 
-	app.writeJSON(w, http.StatusOK, envelope{"worlds": worlds}, nil)
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
+	var filters worlds.Filters
+	filters.Name = app.readString(qs, "name", "")
+	filters.Genres = app.readCSV(qs, "genres", nil)
+	filters.Sort = app.readString(qs, "sort", "createdAt")
+
+	v := validator.New()
+	worlds.ValidateFilters(v, filters)
+
+	// Cursors only make sense for the order they were issued for.
+	scope := "worlds:" + user.Email + ":" + filters.Sort
+	limit, cursor := app.readPage(qs, scope, v)
+	v.Check(validator.PermittedValue(cursor.Set, "", worldSetOwned, worldSetShared), "cursor", "is invalid")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
-}
 
-func (app application) updateWorldHandler(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	user := app.contextGetUser(r)
-	id := vars["id"]
+	result := []worlds.World{}
+	var next storage.Cursor
+
+	if cursor.Set != worldSetShared {
+		owned, key, err := app.services.Worlds.List(user.Email, filters, limit, cursor.Key)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		for _, world := range *owned {
+			world.Role = worlds.RoleOwner
+			result = append(result, world)
+		}
 
-	world, err := app.services.Worlds.Get(user.Email, id)
+		switch {
+		case key != nil:
+			next = storage.Cursor{Set: worldSetOwned, Key: key}
+		case len(result) == limit:
+			next = storage.Cursor{Set: worldSetShared}
+		default:
+			cursor = storage.Cursor{Set: worldSetShared}
+		}
+	}
+
+	if cursor.Set == worldSetShared {
+		memberships, key, err := app.services.Members.ListSharedWithUser(user.Email, limit-len(result), cursor.Key)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		for _, member := range *memberships {
+			world, err := app.services.Worlds.Get(member.OwnerId, member.WorldId)
+			if err != nil {
+				switch {
+				case errors.Is(err, common.ErrorRecordNotFound):
+					continue
+				default:
+					app.serverErrorResponse(w, r, err)
+					return
+				}
+			}
+
+			ok, err := filters.Matches(world)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+			if !ok {
+				continue
+			}
+
+			world.Role = member.Role
+			result = append(result, *world)
+		}
+
+		if key != nil {
+			next = storage.Cursor{Set: worldSetShared, Key: key}
+		}
+	}
+
+	nextCursor, err := app.cursors.Encode(scope, next)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	if world == nil {
-		app.notFoundResponse(w, r)
+	meta := metadata{Limit: limit, NextCursor: nextCursor}
+	err = app.writeJSON(w, http.StatusOK, envelope{"worlds": result, "metadata": meta}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
 		return
 	}
+}
+
+func (app application) updateWorldHandler(w http.ResponseWriter, r *http.Request) {
+	world, _ := app.contextGetWorld(r)
 
 	var input struct {
 		Name       *string  `json:"name" dynamodbav:"name"`
 		Intro      *string  `json:"intro" dynamodbav:"intro"`
 		Genres     []string `json:"genres" dynamodbav:"genres,stringset,omitempty"`
 		CoverImage *string  `json:"coverImage" dynamodbav:"coverImage"`
+		// A sheet without fields removes the sheet of the world. The characters are
+		// only checked against a new sheet when they are next saved.
+		Sheet *sheets.Template `json:"sheet"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
 	}
 
 	if input.Name != nil {
@@ -126,6 +236,13 @@ func (app application) updateWorldHandler(w http.ResponseWriter, r *http.Request
 		world.Genres = input.Genres
 	}
 
+	if input.Sheet != nil {
+		world.Sheet = input.Sheet
+		if len(world.Sheet.Fields) == 0 {
+			world.Sheet = nil
+		}
+	}
+
 	imgUpdated := false
 	if input.CoverImage != nil {
 		imgUpdated = *input.CoverImage != ""
@@ -138,9 +255,16 @@ func (app application) updateWorldHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	err = app.services.Worlds.Update(user.Email, id, world, imgUpdated)
+	err = app.services.Worlds.Update(world.UserId, world.Id, world, imgUpdated)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, uploader.ErrorInvalidImage):
+			app.failedValidationResponse(w, r, map[string]string{"coverImage": err.Error()})
+		case errors.Is(err, common.ErrorRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
@@ -149,8 +273,10 @@ func (app application) updateWorldHandler(w http.ResponseWriter, r *http.Request
 	app.writeJSON(w, http.StatusOK, envelope{"world": world}, headers)
 }
 
-// DeleteWorld deletes a world and all its content (characters, maps, etc)
-// swagger:route DELETE /worlds/{id} deleteWorldHandler
+// DeleteWorld moves a world to the trash. It is purged along with all its content
+// (characters, locations and their maps, rolls, etc) once the trash retention period
+// is over.
+// swagger:route DELETE /worlds/{worldId} deleteWorldHandler
 // Delete a world.
 //
 // responses:
@@ -159,25 +285,17 @@ func (app application) updateWorldHandler(w http.ResponseWriter, r *http.Request
 //	400: ErrorResponse
 //	500: ErrorResponse
 func (app application) deleteWorldHandler(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	worldId := vars["id"]
-	user := app.contextGetUser(r)
+	world, _ := app.contextGetWorld(r)
 
-	cKeys, err := app.services.Characters.ListKeys(worldId)
+	// The world goes to the trash, from where it can be restored until it is purged.
+	err := app.services.Worlds.Delete(world.UserId, world.Id)
 	if err != nil {
-		app.deleteItemResponse(w, r, "Character")
-	}
-
-	err = app.services.Characters.DeleteByKeys(cKeys)
-	if err != nil {
-		app.deleteItemResponse(w, r, "Character")
+		app.serverErrorResponse(w, r, err)
+		return
 	}
 
-	err = app.services.Worlds.Delete(user.Email, worldId)
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "world moved to the trash"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
-		return
 	}
-
-	app.writeJSON(w, http.StatusOK, nil, nil)
 }
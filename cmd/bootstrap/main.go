@@ -0,0 +1,44 @@
+// Command bootstrap creates the DynamoDB tables used by the API, with their keys and
+// secondary indexes. Tables that already exist are left untouched, so it is safe to run
+// it again, e.g. against a fresh DynamoDB Local container before the integration tests:
+//
+//	RPG_VAULT_DYNAMODB_ENDPOINT=http://localhost:8000 go run ./cmd/bootstrap -config=config/development.yaml
+//
+// It reads the same config file as the API, so the tables get the names the API uses.
+package main
+
+import (
+	"flag"
+	"os"
+
+	"github.com/jplindgren/rpg-vault/internal/clients"
+	"github.com/jplindgren/rpg-vault/internal/config"
+	"github.com/jplindgren/rpg-vault/internal/jsonlog"
+	"github.com/jplindgren/rpg-vault/internal/services"
+	"github.com/jplindgren/rpg-vault/internal/storage"
+)
+
+func main() {
+	var configPath string
+	flag.StringVar(&configPath, "config", os.Getenv("RPG_VAULT_CONFIG"), "Path to the YAML config file")
+	flag.Parse()
+
+	logger := jsonlog.New(os.Stdout, jsonlog.LevelInfo)
+
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	store := storage.NewDynamo(clients.GetDynamodbClient(cfg.AWS.Key, cfg.AWS.Secret, cfg.AWS.Region, cfg.AWS.Endpoint))
+	tables := services.Tables(services.TableNames(cfg.Storage.Tables))
+
+	err = store.CreateTables(tables...)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	for _, t := range tables {
+		logger.PrintInfo("table ready", map[string]string{"table": t.Name})
+	}
+}
@@ -2,48 +2,112 @@ package characters
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
-	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
 	common "github.com/jplindgren/rpg-vault/internal"
-	"github.com/jplindgren/rpg-vault/internal/clients"
+	"github.com/jplindgren/rpg-vault/internal/search"
+	"github.com/jplindgren/rpg-vault/internal/sheets"
+	"github.com/jplindgren/rpg-vault/internal/storage"
+	"github.com/jplindgren/rpg-vault/internal/validator"
 )
 
 //const characterTable = "rpg_characters"
 
+// The characters table has a global secondary index keyed by ownerId/id, used to list
+// a user's characters across all worlds, and a sparse one keyed by ownerId/deletedAt
+// holding only the characters in the trash.
+const (
+	characterOwnerIndex   = "ownerId-index"
+	characterDeletedIndex = "deletedAt-index"
+)
+
+var notDeleted = storage.AttributeNotExists("deletedAt")
+
 type CharacterKey struct {
 	WorldId string `dynamodbav:"worldId"`
 	Id      string `dynamodbav:"id"`
 }
 
 type CharacterService struct {
-	db        *clients.DynamoDbClientWrapper
-	tableName string
+	db             storage.Store
+	index          *search.Index
+	tableName      string
+	inventoryTable string
 }
 
-func New(db *clients.DynamoDbClientWrapper, tableName string) *CharacterService {
+// New returns the service of the characters kept in tableName. The inventory entries
+// of a character, kept in inventoryTable, are purged along with it.
+func New(db storage.Store, index *search.Index, tableName, inventoryTable string) *CharacterService {
 	return &CharacterService{
-		db:        db,
-		tableName: tableName,
+		db:             db,
+		index:          index,
+		tableName:      tableName,
+		inventoryTable: inventoryTable,
 	}
 }
 
 func (cs *CharacterService) Insert(character *Character) error {
 	character.Id = common.GenerateToken()
 	character.CreatedAt = common.GetIsoString()
-	character.UpdatedAt = ""
+	character.UpdatedAt = character.CreatedAt
+	character.NameLower = strings.ToLower(character.Name)
+
+	err := character.encodeAttributes()
+	if err != nil {
+		return err
+	}
 
-	_, err := cs.db.PutWrapper(cs.tableName, &character, nil)
-	return err
+	err = cs.db.Put(cs.tableName, &character, nil)
+	if err != nil {
+		return err
+	}
+
+	cs.index.Put(searchDocument(character))
+	return nil
 }
 
 func (cs *CharacterService) Get(worldId, id string) (*Character, error) {
+	character, err := cs.get(worldId, id)
+	if err != nil {
+		return nil, err
+	}
+
+	// Characters in the trash are only reachable through GetDeleted and ListDeleted.
+	if character.DeletedAt != "" {
+		return nil, common.ErrorRecordNotFound
+	}
+
+	return character, nil
+}
+
+// GetDeleted returns a character in the trash.
+func (cs *CharacterService) GetDeleted(worldId, id string) (*Character, error) {
+	character, err := cs.get(worldId, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if character.DeletedAt == "" {
+		return nil, common.ErrorRecordNotFound
+	}
+
+	return character, nil
+}
+
+func (cs *CharacterService) get(worldId, id string) (*Character, error) {
 	key := CharacterKey{
 		WorldId: worldId,
 		Id:      id,
 	}
 
 	var result Character
-	_, err := cs.db.GetWrapper(cs.tableName, key, &result)
+	err := cs.db.Get(cs.tableName, key, &result)
 	if err != nil {
 		return nil, err
 	}
@@ -58,39 +122,162 @@ func (cs *CharacterService) Get(worldId, id string) (*Character, error) {
 	return &result, nil
 }
 
-func (cs *CharacterService) List(worldId string) (*[]Character, error) {
-	keyEx := expression.Key("worldId").Equal(expression.Value(worldId))
+// List returns a page of the characters of a world matching the filters, starting
+// after the start key, along with the key of the next page, or nil on the last one.
+// The characters are read from the index of the sort attribute, already sorted.
+func (cs *CharacterService) List(worldId string, filters Filters, limit int, start storage.Item) (*[]Character, storage.Item, error) {
+	query := &storage.Query{
+		Index:      common.SortColumn(filters.Sort) + "-index",
+		Key:        "worldId",
+		Value:      worldId,
+		Filter:     storage.And(notDeleted, filters.condition()),
+		Descending: common.SortDescending(filters.Sort),
+		Limit:      limit,
+		StartKey:   start,
+	}
 
 	var resultArr []Character
-	_, err := cs.db.QueryWrapper(cs.tableName, keyEx, &resultArr)
+	next, err := cs.db.QueryPage(cs.tableName, query, &resultArr)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	return &resultArr, nil
+	return &resultArr, next, nil
+}
+
+// condition returns the condition a character must meet to match the filters, or nil
+// when every character does.
+func (f Filters) condition() *storage.Condition {
+	var conds []*storage.Condition
+
+	if f.Name != "" {
+		conds = append(conds, storage.ContainsFold("name", "nameLower", f.Name))
+	}
+
+	if f.OwnerId != "" {
+		conds = append(conds, storage.Equal("ownerId", f.OwnerId))
+	}
+
+	// The attribute values come from the query string, so a value which looks like a
+	// number also matches the numeric attributes.
+	for name, value := range f.Attributes {
+		path := "Attributes." + name
+		cond := storage.Equal(path, value)
+
+		if n, err := strconv.ParseFloat(value, 64); err == nil {
+			cond = storage.Or(cond, storage.Equal(path, n))
+		}
+
+		conds = append(conds, cond)
+	}
+
+	if len(conds) == 0 {
+		return nil
+	}
+
+	return storage.And(conds...)
 }
 
-func (cs *CharacterService) ListKeys(worldId string) ([]map[string]string, error) {
-	keyEx := expression.Key("worldId").Equal(expression.Value(worldId))
-	proj := expression.NamesList(expression.Name("id"), expression.Name("worldId"))
+// ValidateCharacter checks a character, and its attributes against the sheet of its
+// world when there is one.
+func ValidateCharacter(v *validator.Validator, character *Character, sheet *sheets.Template) {
+	v.Check(character.Name != "", "name", "must be provided")
+	v.Check(len(character.Name) <= 200, "name", "must not be more than 200 characters long")
 
-	expr, err := expression.NewBuilder().
-		WithKeyCondition(keyEx).
-		WithProjection(proj).
-		Build()
+	if sheet != nil {
+		sheets.ValidateAttributes(v, sheet, character.Attributes)
+	}
+}
+
+// AttributeNameRX matches the attribute names which can be filtered on.
+var AttributeNameRX = regexp.MustCompile(`^[A-Za-z0-9_]{1,64}$`)
+
+func ValidateFilters(v *validator.Validator, f Filters) {
+	v.Check(validator.PermittedValue(f.Sort, SortSafelist...), "sort", "invalid sort value")
+	v.Check(len(f.Name) <= 200, "name", "must not be more than 200 characters long")
+	v.Check(len(f.Attributes) <= 10, "attributes", "must not contain more than 10 attributes")
+
+	for name := range f.Attributes {
+		v.Check(validator.Matches(name, AttributeNameRX), "attributes", "must only contain letters, digits and underscores in attribute names")
+	}
+}
+
+// ListByOwner returns the characters owned by a user, across all worlds.
+func (cs *CharacterService) ListByOwner(ownerId string) (*[]Character, error) {
+	query := &storage.Query{Index: characterOwnerIndex, Key: "ownerId", Value: ownerId, Filter: notDeleted}
+
+	var resultArr []Character
+	err := cs.db.Query(cs.tableName, query, &resultArr)
 	if err != nil {
 		return nil, err
 	}
 
-	var resultArr []map[string]string
-	_, err = cs.db.QueryWithExpressionWrapper(cs.tableName, expr, &resultArr)
+	err = decodeAttributes(resultArr)
 	if err != nil {
 		return nil, err
 	}
 
-	return resultArr, nil
+	return &resultArr, nil
+}
+
+// ListDeleted returns a page of the characters of a user in the trash, the most
+// recently deleted first, along with the key of the next page, or nil on the last one.
+func (cs *CharacterService) ListDeleted(ownerId string, limit int, start storage.Item) (*[]Character, storage.Item, error) {
+	query := &storage.Query{
+		Index:      characterDeletedIndex,
+		Key:        "ownerId",
+		Value:      ownerId,
+		Descending: true,
+		Limit:      limit,
+		StartKey:   start,
+	}
+
+	var resultArr []Character
+	next, err := cs.db.QueryPage(cs.tableName, query, &resultArr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = decodeAttributes(resultArr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &resultArr, next, nil
 }
 
+// encodeAttributes keeps AttributesJSON, which older clients read the attributes from,
+// in sync with Attributes.
+func (c *Character) encodeAttributes() error {
+	if c.Attributes == nil {
+		c.AttributesJSON = ""
+		return nil
+	}
+
+	js, err := json.Marshal(c.Attributes)
+	if err != nil {
+		return err
+	}
+
+	c.AttributesJSON = string(js)
+	return nil
+}
+
+func decodeAttributes(characters []Character) error {
+	for i := range characters {
+		if characters[i].AttributesJSON != "" {
+			err := json.Unmarshal([]byte(characters[i].AttributesJSON), &characters[i].Attributes)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Update saves the changes to a character. It fails with common.ErrorRecordNotFound
+// when the character was moved to the trash or purged meanwhile.
 func (cs *CharacterService) Update(worldId, id string, uc *Character) error {
 	key := CharacterKey{
 		WorldId: worldId,
@@ -99,40 +286,240 @@ func (cs *CharacterService) Update(worldId, id string, uc *Character) error {
 
 	uc.UpdatedAt = common.GetIsoString()
 
-	update := expression.Set(
-		expression.Name("attributesJSON"),
-		expression.Value(uc.AttributesJSON),
-	).Set(
-		expression.Name("name"),
-		expression.Value(uc.Name),
-	).Set(
-		expression.Name("intro"),
-		expression.Value(uc.Intro),
-	).Set(
-		expression.Name("updatedAt"),
-		expression.Value(uc.UpdatedAt),
-	).Set(
-		expression.Name("coverImage"),
-		expression.Value(uc.CoverImage),
-	)
-
-	_, err := cs.db.UpdateWrapper(cs.tableName, key, update)
-	return err
+	err := uc.encodeAttributes()
+	if err != nil {
+		return err
+	}
+
+	update := storage.Set("AttributesJSON", uc.AttributesJSON).
+		Set("Attributes", uc.Attributes).
+		Set("name", uc.Name).
+		Set("nameLower", strings.ToLower(uc.Name)).
+		Set("intro", uc.Intro).
+		Set("updatedAt", uc.UpdatedAt).
+		Set("coverImage", uc.CoverImage).
+		If(storage.And(storage.AttributeExists("id"), notDeleted))
+
+	err = cs.db.Update(cs.tableName, key, update)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrorConditionFailed):
+			return common.ErrorRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	cs.index.Put(searchDocument(uc))
+	return nil
+}
+
+// UpdateOwner hands a character over to another user. Like Update, it fails with
+// common.ErrorRecordNotFound when the character was moved to the trash or purged
+// meanwhile.
+func (cs *CharacterService) UpdateOwner(character *Character, ownerId string) error {
+	key := CharacterKey{
+		WorldId: character.WorldId,
+		Id:      character.Id,
+	}
+
+	character.OwnerId = ownerId
+	character.UpdatedAt = common.GetIsoString()
+
+	update := storage.Set("ownerId", character.OwnerId).
+		Set("updatedAt", character.UpdatedAt).
+		If(storage.And(storage.AttributeExists("id"), notDeleted))
+
+	err := cs.db.Update(cs.tableName, key, update)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrorConditionFailed):
+			return common.ErrorRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	cs.index.Put(searchDocument(character))
+	return nil
 }
 
+// Delete moves a character to the trash. It disappears from Get and the listings, and
+// from the search results, until it is restored or purged.
 func (cs *CharacterService) Delete(worldId, id string) error {
 	key := CharacterKey{
 		WorldId: worldId,
 		Id:      id,
 	}
-	_, err := cs.db.DeleteWrapper(cs.tableName, key)
-	return err
+
+	update := storage.Set("deletedAt", common.GetIsoString()).
+		If(storage.And(storage.AttributeExists("id"), notDeleted))
+
+	err := cs.db.Update(cs.tableName, key, update)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrorConditionFailed):
+			return common.ErrorRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	cs.index.Delete(search.KindCharacter, id)
+	return nil
 }
 
-func (cs *CharacterService) DeleteByKeys(keys []map[string]string) error {
-	if len(keys) == 0 {
-		return nil
+// Restore takes a character out of the trash and returns it.
+func (cs *CharacterService) Restore(worldId, id string) (*Character, error) {
+	key := CharacterKey{
+		WorldId: worldId,
+		Id:      id,
+	}
+
+	update := storage.Set("updatedAt", common.GetIsoString()).
+		Remove("deletedAt").
+		If(storage.AttributeExists("deletedAt"))
+
+	err := cs.db.Update(cs.tableName, key, update)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrorConditionFailed):
+			return nil, common.ErrorRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	character, err := cs.Get(worldId, id)
+	if err != nil {
+		return nil, err
+	}
+
+	cs.index.Put(searchDocument(character))
+	return character, nil
+}
+
+// Purge permanently removes a character along with their inventory.
+func (cs *CharacterService) Purge(worldId, id string) error {
+	var entries []map[string]string
+	query := &storage.Query{
+		Index:      "characterId-index",
+		Key:        "characterId",
+		Value:      id,
+		Projection: []string{"worldId", "id"},
+	}
+
+	err := cs.db.Query(cs.inventoryTable, query, &entries)
+	if err != nil {
+		return err
+	}
+
+	err = cs.db.BatchDelete(cs.inventoryTable, entries)
+	if err != nil {
+		return err
+	}
+
+	key := CharacterKey{
+		WorldId: worldId,
+		Id:      id,
+	}
+
+	err = cs.db.Delete(cs.tableName, key)
+	if err != nil {
+		return err
+	}
+
+	cs.index.Delete(search.KindCharacter, id)
+	return nil
+}
+
+// PurgeDeleted permanently deletes the characters moved to the trash before the given
+// time, returning how many were purged. Like for worlds, a character failing to be
+// purged doesn't stop the others.
+func (cs *CharacterService) PurgeDeleted(before time.Time) (int, error) {
+	// Only the trashed characters are in the sparse deletedAt index. Their deletedAt is
+	// compared as a time, as the older ones weren't always written in UTC.
+	var resultArr []Character
+	err := cs.db.ScanIndex(cs.tableName, characterDeletedIndex, nil, &resultArr)
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	var failures common.Errors
+	for _, character := range resultArr {
+		deletedAt, err := time.Parse(time.RFC3339, character.DeletedAt)
+		if err != nil || !deletedAt.Before(before) {
+			continue
+		}
+
+		err = common.Guard(func() error {
+			return cs.Purge(character.WorldId, character.Id)
+		})
+		if err != nil {
+			failures = append(failures, fmt.Errorf("purging character %s: %w", character.Id, err))
+			continue
+		}
+		purged++
+	}
+
+	return purged, failures.Err()
+}
+
+// Reindex adds every character out of the trash to the search index.
+func (cs *CharacterService) Reindex() (int, error) {
+	var resultArr []Character
+	err := cs.db.Scan(cs.tableName, notDeleted, &resultArr)
+	if err != nil {
+		return 0, err
+	}
+
+	err = decodeAttributes(resultArr)
+	if err != nil {
+		return 0, err
+	}
+
+	for i := range resultArr {
+		cs.index.Put(searchDocument(&resultArr[i]))
+	}
+
+	return len(resultArr), nil
+}
+
+// SetEncumbrance sets the encumbrance of the character from the weight they carry.
+func (c *Character) SetEncumbrance(weight float64) {
+	c.Encumbrance = &Encumbrance{Weight: weight}
+
+	if capacity, ok := c.Attributes[CapacityAttribute].(float64); ok {
+		c.Encumbrance.Capacity = &capacity
+		c.Encumbrance.Encumbered = weight > capacity
+	}
+}
+
+// searchDocument indexes the attributes as "name value" pairs, so a search finds a
+// character by the names of its attributes as well as by their values.
+func searchDocument(character *Character) search.Document {
+	names := make([]string, 0, len(character.Attributes))
+	for name := range character.Attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = fmt.Sprintf("%s: %v", name, character.Attributes[name])
+	}
+
+	return search.Document{
+		Kind:    search.KindCharacter,
+		Id:      character.Id,
+		WorldId: character.WorldId,
+		OwnerId: character.OwnerId,
+		Name:    character.Name,
+		Fields: map[string]string{
+			"name":       character.Name,
+			"intro":      character.Intro,
+			"attributes": strings.Join(pairs, ", "),
+		},
 	}
-	_, err := cs.db.BatchDeleteWrapper(cs.tableName, keys)
-	return err
 }
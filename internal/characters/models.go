@@ -4,6 +4,7 @@ type Character struct {
 	WorldId        string                 `json:"worldId" dynamodbav:"worldId"`
 	Id             string                 `json:"id" dynamodbav:"id"`
 	Name           string                 `json:"name" dynamodbav:"name"`
+	NameLower      string                 `json:"-" dynamodbav:"nameLower,omitempty"`
 	Intro          string                 `json:"intro" dynamodbav:"intro"`
 	Attributes     map[string]interface{} `json:"attributes"`
 	AttributesJSON string                 `json:"-" dynamodbav:"AttributesJSON"`
@@ -11,4 +12,29 @@ type Character struct {
 	OwnerId        string                 `json:"ownerId" dynamodbav:"ownerId"`
 	CreatedAt      string                 `json:"createdAt" dynamodbav:"createdAt"`
 	UpdatedAt      string                 `json:"updatedAt" dynamodbav:"updatedAt"`
+	DeletedAt      string                 `json:"deletedAt,omitempty" dynamodbav:"deletedAt,omitempty"`
+	Encumbrance    *Encumbrance           `json:"encumbrance,omitempty" dynamodbav:"-"`
 }
+
+// Encumbrance is the weight a character carries, computed from their inventory.
+// Capacity comes from the carrying_capacity attribute, when the character has one, and
+// Encumbered tells whether the weight exceeds it.
+type Encumbrance struct {
+	Weight     float64  `json:"weight"`
+	Capacity   *float64 `json:"capacity,omitempty"`
+	Encumbered bool     `json:"encumbered"`
+}
+
+const CapacityAttribute = "carrying_capacity"
+
+// Filters selects and orders the characters of a listing. Name matches the characters
+// whose name contains it, ignoring case, OwnerId the characters of a user, and
+// Attributes the characters having all the given attribute values.
+type Filters struct {
+	Name       string
+	OwnerId    string
+	Attributes map[string]string
+	Sort       string
+}
+
+var SortSafelist = []string{"name", "createdAt", "updatedAt", "-name", "-createdAt", "-updatedAt"}
@@ -31,11 +31,16 @@ func getAwsConfig(awsKey, awsSecret string) aws.Config {
 	return awsConfig
 }
 
-func GetDynamodbClient(awsKey, awsSecret, awsRegion string) *DynamoDbClientWrapper {
+// GetDynamodbClient returns a client for DynamoDB. A non-empty endpoint overrides the
+// AWS one, which lets us use DynamoDB Local (e.g. http://localhost:8000).
+func GetDynamodbClient(awsKey, awsSecret, awsRegion, endpoint string) *DynamoDbClientWrapper {
 	awsConfig = getAwsConfig(awsKey, awsSecret)
 
 	dynamodbClient := dynamodb.NewFromConfig(awsConfig, func(opt *dynamodb.Options) {
 		opt.Region = awsRegion
+		if endpoint != "" {
+			opt.EndpointResolver = dynamodb.EndpointResolverFromURL(endpoint)
+		}
 	})
 
 	return &DynamoDbClientWrapper{
@@ -43,14 +48,19 @@ func GetDynamodbClient(awsKey, awsSecret, awsRegion string) *DynamoDbClientWrapp
 	}
 }
 
-func GetS3Client(awsKey, awsSecret, awsRegion string) *S3ClientWrapper {
+func GetS3Client(awsKey, awsSecret string, s3Config S3Config) *S3ClientWrapper {
 	awsConfig = getAwsConfig(awsKey, awsSecret)
 
 	s3Client := s3.NewFromConfig(awsConfig, func(opt *s3.Options) {
-		opt.Region = awsRegion
+		opt.Region = s3Config.Region
+		opt.UsePathStyle = s3Config.PathStyle
+		if s3Config.Endpoint != "" {
+			opt.EndpointResolver = s3.EndpointResolverFromURL(s3Config.Endpoint)
+		}
 	})
 
 	return &S3ClientWrapper{
 		Client: s3Client,
+		config: s3Config,
 	}
 }
@@ -34,6 +34,28 @@ func (c *DynamoDbClientWrapper) PutWrapper(tableName string, item interface{}, c
 	return putItemRes, nil
 }
 
+// PutWithConditionWrapper only writes the item when the condition holds for the stored
+// item. Otherwise DynamoDB fails with a *types.ConditionalCheckFailedException.
+func (c *DynamoDbClientWrapper) PutWithConditionWrapper(tableName string, item interface{}, condition expression.ConditionBuilder) (*dynamodb.PutItemOutput, error) {
+	expr, builderErr := expression.NewBuilder().WithCondition(condition).Build()
+	if builderErr != nil {
+		return &dynamodb.PutItemOutput{}, builderErr
+	}
+
+	av, marshalErr := attributevalue.MarshalMap(item)
+	if marshalErr != nil {
+		return &dynamodb.PutItemOutput{}, marshalErr
+	}
+
+	return c.PutItem(context.TODO(), &dynamodb.PutItemInput{
+		TableName:                 aws.String(tableName),
+		Item:                      av,
+		ConditionExpression:       expr.Condition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	})
+}
+
 func (c *DynamoDbClientWrapper) GetWrapper(tableName string, key interface{}, resultItem interface{}) (*dynamodb.GetItemOutput, error) {
 	av, marshalErr := attributevalue.MarshalMap(key)
 	if marshalErr != nil {
@@ -71,14 +93,26 @@ func (c *DynamoDbClientWrapper) QueryWrapper(tableName string, keyCondition expr
 }
 
 func (c *DynamoDbClientWrapper) QueryWithExpressionWrapper(tableName string, expr expression.Expression, resultArr interface{}) ([]map[string]types.AttributeValue, error) {
+	return c.query(tableName, nil, expr, resultArr)
+}
+
+// QueryIndexWrapper runs a query against a global secondary index of the table instead
+// of its primary key.
+func (c *DynamoDbClientWrapper) QueryIndexWrapper(tableName, indexName string, expr expression.Expression, resultArr interface{}) ([]map[string]types.AttributeValue, error) {
+	return c.query(tableName, aws.String(indexName), expr, resultArr)
+}
+
+func (c *DynamoDbClientWrapper) query(tableName string, indexName *string, expr expression.Expression, resultArr interface{}) ([]map[string]types.AttributeValue, error) {
 	var response *dynamodb.QueryOutput
 
 	response, err := c.Query(context.TODO(), &dynamodb.QueryInput{
 		TableName:                 aws.String(tableName),
+		IndexName:                 indexName,
 		ExpressionAttributeNames:  expr.Names(),
 		ExpressionAttributeValues: expr.Values(),
 		ProjectionExpression:      expr.Projection(),
 		KeyConditionExpression:    expr.KeyCondition(),
+		FilterExpression:          expr.Filter(),
 	})
 
 	if err != nil {
@@ -93,21 +127,37 @@ func (c *DynamoDbClientWrapper) QueryWithExpressionWrapper(tableName string, exp
 }
 
 func (c *DynamoDbClientWrapper) UpdateWrapper(tableName string, key interface{}, update expression.UpdateBuilder) (*dynamodb.UpdateItemOutput, error) {
-	av, marshalErr := attributevalue.MarshalMap(key)
-	if marshalErr != nil {
-		return &dynamodb.UpdateItemOutput{}, marshalErr
+	expr, builderErr := expression.NewBuilder().WithUpdate(update).Build()
+	if builderErr != nil {
+		return &dynamodb.UpdateItemOutput{}, builderErr
 	}
 
-	expr, builderErr := expression.NewBuilder().WithUpdate(update).Build()
+	return c.updateItem(tableName, key, expr)
+}
+
+// UpdateWithConditionWrapper only applies the update when the condition holds for the
+// stored item. Otherwise DynamoDB fails with a *types.ConditionalCheckFailedException.
+func (c *DynamoDbClientWrapper) UpdateWithConditionWrapper(tableName string, key interface{}, update expression.UpdateBuilder, condition expression.ConditionBuilder) (*dynamodb.UpdateItemOutput, error) {
+	expr, builderErr := expression.NewBuilder().WithUpdate(update).WithCondition(condition).Build()
 	if builderErr != nil {
 		return &dynamodb.UpdateItemOutput{}, builderErr
 	}
 
+	return c.updateItem(tableName, key, expr)
+}
+
+func (c *DynamoDbClientWrapper) updateItem(tableName string, key interface{}, expr expression.Expression) (*dynamodb.UpdateItemOutput, error) {
+	av, marshalErr := attributevalue.MarshalMap(key)
+	if marshalErr != nil {
+		return &dynamodb.UpdateItemOutput{}, marshalErr
+	}
+
 	updateItemRes, updateItemErr := c.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
 		//TableName:           aws.String(config.PrimaryTableName),
 		TableName:                 aws.String(tableName),
 		Key:                       av,
 		UpdateExpression:          expr.Update(),
+		ConditionExpression:       expr.Condition(),
 		ExpressionAttributeNames:  expr.Names(),
 		ExpressionAttributeValues: expr.Values(),
 	})
@@ -135,33 +185,3 @@ func (c *DynamoDbClientWrapper) DeleteWrapper(tableName string, key interface{})
 
 	return deleteItemRes, nil
 }
-
-type CharacterKey struct {
-	WorldId string
-	Id      string
-}
-
-func (c *DynamoDbClientWrapper) BatchDeleteWrapper(tableName string, keys []map[string]string) (*dynamodb.BatchWriteItemOutput, error) {
-	var wr []types.WriteRequest
-	for _, key := range keys {
-		parsedKey, err := attributevalue.MarshalMap(key)
-		if err != nil {
-			return nil, err
-		}
-
-		wr = append(
-			wr,
-			types.WriteRequest{DeleteRequest: &types.DeleteRequest{Key: parsedKey}},
-		)
-	}
-
-	input := &dynamodb.BatchWriteItemInput{
-		RequestItems: map[string][]types.WriteRequest{tableName: wr}}
-
-	deleteItemRes, deleteItemErr := c.BatchWriteItem(context.TODO(), input)
-	if deleteItemErr != nil {
-		return nil, deleteItemErr
-	}
-
-	return deleteItemRes, nil
-}
@@ -3,23 +3,39 @@ package clients
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"net/url"
+	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	common "github.com/jplindgren/rpg-vault/internal"
 )
 
-const PrimaryBucketName = "rpg-vault-go"
+// S3Config holds the settings of the bucket where the files are stored. Endpoint
+// overrides the AWS endpoint, to use S3-compatible services like MinIO or LocalStack,
+// which usually also need PathStyle addressing (http://host/bucket/key instead of
+// http://bucket.host/key). PublicURL is the base of the URLs returned by Upload, like
+// a CDN in front of the bucket.
+type S3Config struct {
+	Bucket    string
+	Region    string
+	Endpoint  string
+	PathStyle bool
+	PublicURL string
+}
 
 type S3ClientWrapper struct {
 	*s3.Client
+	config S3Config
 }
 
 func (c *S3ClientWrapper) Upload(contents []byte, destinationPath string) (string, error) {
 	contentsReader := bytes.NewReader(contents)
 	_, err := c.PutObject(context.TODO(), &s3.PutObjectInput{
-		//Bucket: aws.String(config.PrimaryBucketName),
-		Bucket: aws.String(PrimaryBucketName),
+		Bucket: aws.String(c.config.Bucket),
 		Key:    aws.String(destinationPath),
 		Body:   contentsReader,
 	})
@@ -27,36 +43,109 @@ func (c *S3ClientWrapper) Upload(contents []byte, destinationPath string) (strin
 		return "", err
 	}
 
-	//https://my-bucket.s3-ap-southeast-2.amazonaws.com/foo/bar.txt
-	//https://rpg-vault-go.s3.sa-east-1.amazonaws.com/f572a37c-33a7-4b5b-85d9-86cb596d2edb/world/cover.png
-	//TODO: get from config
-	region := "sa-east-1"
+	return c.URL(destinationPath), nil
+}
+
+// URL returns the address a file can be downloaded from, e.g.
+// https://rpg-vault-go.s3.sa-east-1.amazonaws.com/f572a37c-33a7-4b5b-85d9-86cb596d2edb/world/cover.png
+// When an endpoint is configured, the URL points to it instead, addressing the bucket
+// the same way the client does.
+func (c *S3ClientWrapper) URL(path string) string {
+	if c.config.PublicURL != "" {
+		return strings.TrimSuffix(c.config.PublicURL, "/") + "/" + path
+	}
+
+	if c.config.Endpoint != "" {
+		endpoint, err := url.Parse(c.config.Endpoint)
+		if err == nil && endpoint.Host != "" {
+			if c.config.PathStyle {
+				endpoint.Path = strings.TrimSuffix(endpoint.Path, "/") + "/" + c.config.Bucket + "/" + path
+			} else {
+				endpoint.Host = c.config.Bucket + "." + endpoint.Host
+				endpoint.Path = strings.TrimSuffix(endpoint.Path, "/") + "/" + path
+			}
+			return endpoint.String()
+		}
+	}
 
-	//TODO: get from config?
-	return fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", PrimaryBucketName, region, destinationPath), nil
+	if c.config.PathStyle {
+		return fmt.Sprintf("https://s3.%s.amazonaws.com/%s/%s", c.config.Region, c.config.Bucket, path)
+	}
+
+	return fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", c.config.Bucket, c.config.Region, path)
 }
 
-func (c *S3ClientWrapper) Read(path string) []byte {
+// Read returns the contents of a file, or common.ErrorRecordNotFound if there is no
+// such file.
+func (c *S3ClientWrapper) Read(path string) ([]byte, error) {
 	output, err := c.GetObject(context.TODO(), &s3.GetObjectInput{
-		//Bucket: aws.String(config.PrimaryBucketName),
-		Bucket: aws.String(PrimaryBucketName),
+		Bucket: aws.String(c.config.Bucket),
 		Key:    aws.String(path),
 	})
 	if err != nil {
-		panic(err)
+		var nsk *types.NoSuchKey
+		switch {
+		case errors.As(err, &nsk):
+			return nil, common.ErrorRecordNotFound
+		default:
+			return nil, err
+		}
 	}
+	defer output.Body.Close()
+
 	buf := new(bytes.Buffer)
-	buf.ReadFrom(output.Body)
-	return buf.Bytes()
+	_, err = buf.ReadFrom(output.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
 }
 
-func (c *S3ClientWrapper) Delete(path string) {
+func (c *S3ClientWrapper) Delete(path string) error {
 	_, err := c.DeleteObject(context.TODO(), &s3.DeleteObjectInput{
-		//Bucket: aws.String(config.PrimaryBucketName),
-		Bucket: aws.String(PrimaryBucketName),
+		Bucket: aws.String(c.config.Bucket),
 		Key:    aws.String(path),
 	})
-	if err != nil {
-		panic(err)
+	return err
+}
+
+// DeletePrefix removes every object whose key starts with prefix. Objects are listed and
+// deleted a page at a time, as DeleteObjects takes at most 1000 keys.
+func (c *S3ClientWrapper) DeletePrefix(prefix string) error {
+	paginator := s3.NewListObjectsV2Paginator(c.Client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(c.config.Bucket),
+		Prefix: aws.String(prefix),
+	})
+
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return err
+		}
+
+		if len(page.Contents) == 0 {
+			continue
+		}
+
+		objects := make([]types.ObjectIdentifier, len(page.Contents))
+		for i, object := range page.Contents {
+			objects[i] = types.ObjectIdentifier{Key: object.Key}
+		}
+
+		output, err := c.DeleteObjects(context.TODO(), &s3.DeleteObjectsInput{
+			Bucket: aws.String(c.config.Bucket),
+			Delete: &types.Delete{Objects: objects, Quiet: true},
+		})
+		if err != nil {
+			return err
+		}
+
+		if len(output.Errors) > 0 {
+			e := output.Errors[0]
+			return fmt.Errorf("deleting %d objects under %q: %s: %s", len(output.Errors), prefix, aws.ToString(e.Key), aws.ToString(e.Message))
+		}
 	}
+
+	return nil
 }
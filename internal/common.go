@@ -2,7 +2,9 @@ package common
 
 import (
 	"errors"
+	"fmt"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -21,8 +23,10 @@ func GenerateToken() string {
 	return uuid.New().String()
 }
 
+// GetIsoString returns the current time in UTC, so the timestamps of every instance
+// sort and compare as strings whatever their time zone.
 func GetIsoString() string {
-	return time.Now().Format(time.RFC3339)
+	return time.Now().UTC().Format(time.RFC3339)
 }
 
 // Define a custom ErrRecordNotFound error. We'll return this from our Get() method when
@@ -31,3 +35,37 @@ var (
 	ErrorRecordNotFound = errors.New("record not found")
 	ErrorEditConflict   = errors.New("edit conflict")
 )
+
+// Errors collects the failures of a batch whose other operations went on, like the
+// purge of the trash, where one broken world must not keep the rest from being purged.
+type Errors []error
+
+func (e Errors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+
+	return strings.Join(messages, "; ")
+}
+
+// Err returns the collected errors as a single error, or nil when there are none.
+func (e Errors) Err() error {
+	if len(e) == 0 {
+		return nil
+	}
+
+	return e
+}
+
+// Guard runs fn, turning a panic into an error, so a failure of one operation of a batch
+// doesn't bring the rest down.
+func Guard(fn func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	return fn()
+}
@@ -0,0 +1,425 @@
+// Package config loads the settings of the API from a YAML file, one per environment,
+// with environment variables taking precedence over the file. Secrets like the AWS
+// credentials should come from the environment rather than from the file.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jplindgren/rpg-vault/internal/users"
+	"github.com/jplindgren/rpg-vault/internal/validator"
+	"gopkg.in/yaml.v3"
+)
+
+type Config struct {
+	Env         string      `yaml:"env" env:"RPG_VAULT_ENV"`
+	Port        int         `yaml:"port" env:"RPG_VAULT_PORT"`
+	LogLevel    string      `yaml:"log_level" env:"RPG_VAULT_LOG_LEVEL"`
+	Instances   int         `yaml:"instances" env:"RPG_VAULT_INSTANCES"`
+	Limiter     Limiter     `yaml:"limiter"`
+	CORS        CORS        `yaml:"cors"`
+	Permissions Permissions `yaml:"permissions"`
+	Storage     Storage     `yaml:"storage"`
+	AWS         AWS         `yaml:"aws"`
+	Blobs       Blobs       `yaml:"blobs"`
+	Mailer      Mailer      `yaml:"mailer"`
+	SMTP        SMTP        `yaml:"smtp"`
+	Auth        Auth        `yaml:"auth"`
+	Pagination  Pagination  `yaml:"pagination"`
+	Trash       Trash       `yaml:"trash"`
+	Search      Search      `yaml:"search"`
+}
+
+type Limiter struct {
+	RPS     float64 `yaml:"rps" env:"RPG_VAULT_LIMITER_RPS"`
+	Burst   int     `yaml:"burst" env:"RPG_VAULT_LIMITER_BURST"`
+	Enabled bool    `yaml:"enabled" env:"RPG_VAULT_LIMITER_ENABLED"`
+}
+
+type CORS struct {
+	TrustedOrigins []string `yaml:"trusted_origins" env:"RPG_VAULT_CORS_TRUSTED_ORIGINS"`
+}
+
+// Permissions holds the permission codes granted to every newly registered user, and
+// the emails of the administrators, who can grant and revoke the codes of any user.
+type Permissions struct {
+	Defaults []string `yaml:"defaults" env:"RPG_VAULT_DEFAULT_PERMISSIONS"`
+	Admins   []string `yaml:"admins" env:"RPG_VAULT_ADMINS"`
+}
+
+// Storage selects where the data is kept. The "memory" storage keeps everything in
+// memory, so the API can run offline, and loses it when the server stops. The "sqlite"
+// and "postgres" storages connect to the database in DSN and migrate it on startup.
+type Storage struct {
+	Kind   string `yaml:"kind" env:"RPG_VAULT_STORAGE"`
+	DSN    string `yaml:"dsn" env:"RPG_VAULT_STORAGE_DSN"`
+	Tables Tables `yaml:"tables"`
+}
+
+// Tables holds the table names, so several environments can share an AWS account. The
+// SQL migrations create the default names, so only DynamoDB supports custom ones.
+type Tables struct {
+	Users       string `yaml:"users" env:"RPG_VAULT_TABLE_USERS"`
+	Tokens      string `yaml:"tokens" env:"RPG_VAULT_TABLE_TOKENS"`
+	Permissions string `yaml:"permissions" env:"RPG_VAULT_TABLE_PERMISSIONS"`
+	Worlds      string `yaml:"worlds" env:"RPG_VAULT_TABLE_WORLDS"`
+	Members     string `yaml:"members" env:"RPG_VAULT_TABLE_MEMBERS"`
+	Characters  string `yaml:"characters" env:"RPG_VAULT_TABLE_CHARACTERS"`
+	Rolls       string `yaml:"rolls" env:"RPG_VAULT_TABLE_ROLLS"`
+	Locations   string `yaml:"locations" env:"RPG_VAULT_TABLE_LOCATIONS"`
+	Items       string `yaml:"items" env:"RPG_VAULT_TABLE_ITEMS"`
+	Inventory   string `yaml:"inventory" env:"RPG_VAULT_TABLE_INVENTORY"`
+}
+
+// AWS holds the credentials shared by DynamoDB and S3. Endpoint overrides the DynamoDB
+// endpoint, e.g. http://localhost:8000 for DynamoDB Local.
+type AWS struct {
+	Key      string `yaml:"key" env:"AWS_ACCESS_KEY_ID"`
+	Secret   string `yaml:"secret" env:"AWS_SECRET_ACCESS_KEY"`
+	Region   string `yaml:"region" env:"AWS_DEFAULT_REGION"`
+	Endpoint string `yaml:"dynamodb_endpoint" env:"RPG_VAULT_DYNAMODB_ENDPOINT"`
+}
+
+// Blobs selects where uploaded files go: an S3 bucket or, with the "disk" kind, a local
+// directory served by the API itself under /v1/files/. PublicURL is the base of the
+// URLs handed out for the files.
+type Blobs struct {
+	Kind      string `yaml:"kind" env:"RPG_VAULT_BLOB_STORAGE"`
+	Dir       string `yaml:"dir" env:"RPG_VAULT_BLOB_DIR"`
+	PublicURL string `yaml:"public_url" env:"RPG_VAULT_BLOB_PUBLIC_URL"`
+	S3        S3     `yaml:"s3"`
+}
+
+// S3 holds the bucket settings. Region defaults to the AWS region, and Endpoint points
+// to S3-compatible services like MinIO, which usually need PathStyle addressing.
+type S3 struct {
+	Bucket    string `yaml:"bucket" env:"RPG_VAULT_S3_BUCKET"`
+	Region    string `yaml:"region" env:"RPG_VAULT_S3_REGION"`
+	Endpoint  string `yaml:"endpoint" env:"RPG_VAULT_S3_ENDPOINT"`
+	PathStyle bool   `yaml:"path_style" env:"RPG_VAULT_S3_PATH_STYLE"`
+}
+
+// Mailer selects how emails are sent. The "log" mailer writes them to the application
+// output (or to one file per email inside Dir) instead of sending them.
+type Mailer struct {
+	Kind string `yaml:"kind" env:"RPG_VAULT_MAILER"`
+	Dir  string `yaml:"dir" env:"RPG_VAULT_MAILER_DIR"`
+}
+
+type SMTP struct {
+	Host     string `yaml:"host" env:"RPG_VAULT_SMTP_HOST"`
+	Port     int    `yaml:"port" env:"RPG_VAULT_SMTP_PORT"`
+	Username string `yaml:"username" env:"SMTP_USERNAME"`
+	Password string `yaml:"password" env:"SMTP_PASSWORD"`
+	Sender   string `yaml:"sender" env:"RPG_VAULT_SMTP_SENDER"`
+}
+
+// Auth holds the token settings. Opaque authentication tokens live for TokenTTL, and
+// the refresh tokens handed out alongside them for RefreshTTL. In "jwt" mode the API
+// issues signed JWTs instead, verified without touching the database. JWTKeys holds
+// space separated "kid:secret" pairs; tokens are signed with the first one, and any of
+// them can verify a token, which lets us rotate keys. Revoked JWTs are only tracked in
+// memory, so "jwt" mode requires a single instance.
+type Auth struct {
+	Mode       string        `yaml:"mode" env:"RPG_VAULT_AUTH_MODE"`
+	TokenTTL   time.Duration `yaml:"token_ttl" env:"RPG_VAULT_AUTH_TOKEN_TTL"`
+	RefreshTTL time.Duration `yaml:"refresh_token_ttl" env:"RPG_VAULT_REFRESH_TOKEN_TTL"`
+	JWTKeys    string        `yaml:"jwt_keys" env:"JWT_KEYS"`
+	JWTTTL     time.Duration `yaml:"jwt_ttl" env:"RPG_VAULT_JWT_TTL"`
+	JWTIssuer  string        `yaml:"jwt_issuer" env:"RPG_VAULT_JWT_ISSUER"`
+}
+
+// Pagination holds the secret the listing cursors are signed with. When it's empty a
+// random one is generated on startup, so cursors stop working after a restart and
+// aren't shared between instances, which is why production and several instances
+// require it.
+type Pagination struct {
+	CursorSecret string `yaml:"cursor_secret" env:"RPG_VAULT_CURSOR_SECRET"`
+}
+
+// Trash holds how long deleted worlds and characters can be restored for. A background
+// job checks every PurgeInterval for the ones deleted more than Retention ago, and
+// removes them for good.
+type Trash struct {
+	Retention     time.Duration `yaml:"retention" env:"RPG_VAULT_TRASH_RETENTION"`
+	PurgeInterval time.Duration `yaml:"purge_interval" env:"RPG_VAULT_TRASH_PURGE_INTERVAL"`
+}
+
+// Search holds how often the search index, which every instance keeps in memory, is
+// rebuilt from the storage. Zero only builds it on startup, which is enough for a
+// single instance; with several instances each one only sees the changes made through
+// the others once it rebuilds its index.
+type Search struct {
+	ReindexInterval time.Duration `yaml:"reindex_interval" env:"RPG_VAULT_SEARCH_REINDEX_INTERVAL"`
+}
+
+// Default returns the settings used for everything the file and the environment leave
+// out.
+func Default() Config {
+	return Config{
+		Env:       "development",
+		Port:      4000,
+		LogLevel:  "info",
+		Instances: 1,
+		Limiter:   Limiter{RPS: 2, Burst: 4, Enabled: true},
+		Permissions: Permissions{Defaults: []string{
+			users.PermissionWorldsRead,
+			users.PermissionWorldsWrite,
+			users.PermissionCharactersRead,
+			users.PermissionCharactersWrite,
+		}},
+		Storage: Storage{Kind: "dynamodb", Tables: DefaultTables()},
+		Blobs: Blobs{
+			Kind: "s3",
+			Dir:  "./files",
+			S3:   S3{Bucket: "rpg-vault-go"},
+		},
+		Mailer: Mailer{Kind: "log"},
+		SMTP: SMTP{
+			Host:   "localhost",
+			Port:   25,
+			Sender: "RPG Vault <no-reply@rpgvault.com>",
+		},
+		Auth: Auth{
+			Mode:       "opaque",
+			TokenTTL:   24 * time.Hour,
+			RefreshTTL: 30 * 24 * time.Hour,
+			JWTTTL:     15 * time.Minute,
+			JWTIssuer:  "rpg-vault",
+		},
+		Trash: Trash{
+			Retention:     30 * 24 * time.Hour,
+			PurgeInterval: time.Hour,
+		},
+	}
+}
+
+func DefaultTables() Tables {
+	return Tables{
+		Users:       "rpg_users",
+		Tokens:      "rpg_usertokens",
+		Permissions: "rpg_permissions",
+		Worlds:      "rpg_worlds",
+		Members:     "rpg_world_members",
+		Characters:  "rpg_characters",
+		Rolls:       "rpg_rolls",
+		Locations:   "rpg_locations",
+		Items:       "rpg_items",
+		Inventory:   "rpg_inventory",
+	}
+}
+
+// Load reads the file at path on top of the defaults, applies the environment
+// overrides and validates the result. An empty path skips the file. Unknown keys in
+// the file are an error, so typos don't go unnoticed.
+func Load(path string) (Config, error) {
+	cfg := Default()
+
+	if path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return cfg, fmt.Errorf("config: %w", err)
+		}
+		defer f.Close()
+
+		dec := yaml.NewDecoder(f)
+		dec.KnownFields(true)
+
+		err = dec.Decode(&cfg)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return cfg, fmt.Errorf("config: parsing %s: %w", path, err)
+		}
+	}
+
+	err := applyEnv(reflect.ValueOf(&cfg).Elem())
+	if err != nil {
+		return cfg, err
+	}
+
+	return cfg, cfg.Validate()
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// applyEnv overrides every field tagged with env whose variable is set. Lists are
+// space separated.
+func applyEnv(v reflect.Value) error {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		sf := v.Type().Field(i)
+
+		if field.Kind() == reflect.Struct && field.Type() != durationType {
+			err := applyEnv(field)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		name := sf.Tag.Get("env")
+		if name == "" {
+			continue
+		}
+
+		val, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+
+		err := setField(field, val)
+		if err != nil {
+			return fmt.Errorf("config: %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+func setField(field reflect.Value, val string) error {
+	if field.Type() == durationType {
+		d, err := time.ParseDuration(val)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(val)
+	case reflect.Int:
+		n, err := strconv.Atoi(val)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(n))
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(val, 64)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(val)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Slice:
+		field.Set(reflect.ValueOf(strings.Fields(val)))
+	default:
+		return fmt.Errorf("unsupported setting type %s", field.Type())
+	}
+
+	return nil
+}
+
+// Validate checks the settings, returning an error listing every invalid one.
+func (c Config) Validate() error {
+	v := validator.New()
+
+	v.Check(c.Port > 0 && c.Port <= 65535, "port", "must be between 1 and 65535")
+	v.Check(validator.PermittedValue(c.LogLevel, "info", "error", "fatal", "off"), "log_level", "must be info, error, fatal or off")
+
+	if c.Limiter.Enabled {
+		v.Check(c.Limiter.RPS > 0, "limiter.rps", "must be greater than zero")
+		v.Check(c.Limiter.Burst > 0, "limiter.burst", "must be greater than zero")
+	}
+
+	for _, code := range c.Permissions.Defaults {
+		v.Check(validator.PermittedValue(code, users.PermissionCodes...), "permissions.defaults", fmt.Sprintf("unknown permission %q", code))
+	}
+
+	v.Check(validator.PermittedValue(c.Storage.Kind, "memory", "dynamodb", "sqlite", "postgres"), "storage.kind", "must be memory, dynamodb, sqlite or postgres")
+	if c.Storage.Kind == "postgres" {
+		v.Check(c.Storage.DSN != "", "storage.dsn", "must be provided for postgres")
+	}
+	if c.Storage.Kind == "dynamodb" {
+		v.Check(c.AWS.Region != "", "aws.region", "must be provided for dynamodb")
+	}
+
+	tables := reflect.ValueOf(c.Storage.Tables)
+	for i := 0; i < tables.NumField(); i++ {
+		key := "storage.tables." + tables.Type().Field(i).Tag.Get("yaml")
+		v.Check(tables.Field(i).String() != "", key, "must be provided")
+	}
+	if c.Storage.Kind == "sqlite" || c.Storage.Kind == "postgres" {
+		v.Check(c.Storage.Tables == DefaultTables(), "storage.tables", "custom table names are only supported by dynamodb")
+	}
+
+	switch c.Blobs.Kind {
+	case "s3":
+		v.Check(c.Blobs.S3.Bucket != "", "blobs.s3.bucket", "must be provided")
+		v.Check(c.Blobs.S3.Region != "" || c.AWS.Region != "", "blobs.s3.region", "must be provided, or aws.region")
+	case "disk":
+		v.Check(c.Blobs.Dir != "", "blobs.dir", "must be provided")
+	default:
+		v.AddError("blobs.kind", "must be s3 or disk")
+	}
+
+	switch c.Mailer.Kind {
+	case "smtp":
+		v.Check(c.SMTP.Host != "", "smtp.host", "must be provided")
+		v.Check(c.SMTP.Port > 0 && c.SMTP.Port <= 65535, "smtp.port", "must be between 1 and 65535")
+		v.Check(c.SMTP.Sender != "", "smtp.sender", "must be provided")
+	case "log":
+	default:
+		v.AddError("mailer.kind", "must be smtp or log")
+	}
+
+	v.Check(validator.PermittedValue(c.Auth.Mode, "opaque", "jwt"), "auth.mode", "must be opaque or jwt")
+	v.Check(c.Auth.TokenTTL > 0, "auth.token_ttl", "must be greater than zero")
+	v.Check(c.Auth.RefreshTTL > 0, "auth.refresh_token_ttl", "must be greater than zero")
+	if c.Auth.Mode == "jwt" {
+		v.Check(c.Auth.JWTKeys != "", "auth.jwt_keys", "must be provided in jwt mode")
+		v.Check(c.Auth.JWTTTL > 0, "auth.jwt_ttl", "must be greater than zero")
+		v.Check(c.Auth.JWTIssuer != "", "auth.jwt_issuer", "must be provided in jwt mode")
+		// The denylist lives in memory, so the other instances would keep accepting
+		// the revoked tokens.
+		v.Check(c.Instances <= 1, "auth.mode", "jwt mode is only supported with a single instance")
+	}
+
+	v.Check(validator.PermittedValue(c.Env, "development", "staging", "production"), "env", "must be development, staging or production")
+
+	if c.Pagination.CursorSecret != "" {
+		v.Check(len(c.Pagination.CursorSecret) >= 32, "pagination.cursor_secret", "must be at least 32 characters long")
+	} else {
+		v.Check(c.Env != "production", "pagination.cursor_secret", "must be provided in production")
+		v.Check(c.Instances <= 1, "pagination.cursor_secret", "must be provided when running several instances")
+	}
+
+	v.Check(c.Trash.Retention > 0, "trash.retention", "must be greater than zero")
+	v.Check(c.Trash.PurgeInterval > 0, "trash.purge_interval", "must be greater than zero")
+
+	v.Check(c.Instances > 0, "instances", "must be greater than zero")
+	v.Check(c.Search.ReindexInterval >= 0, "search.reindex_interval", "must not be negative")
+	if c.Instances > 1 {
+		v.Check(c.Search.ReindexInterval > 0, "search.reindex_interval", "must be provided when running several instances")
+	}
+
+	if v.Valid() {
+		return nil
+	}
+
+	keys := make([]string, 0, len(v.Errors))
+	for key := range v.Errors {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	problems := make([]string, len(keys))
+	for i, key := range keys {
+		problems[i] = fmt.Sprintf("%s: %s", key, v.Errors[key])
+	}
+
+	return fmt.Errorf("config: invalid settings: %s", strings.Join(problems, "; "))
+}
@@ -0,0 +1,272 @@
+// Package dice parses and rolls dice expressions, like "4d6kh3" or "1d20+@str_mod",
+// using a cryptographically secure random number generator, so no one at the table can
+// predict or bias the rolls.
+package dice
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The modes of a roll. Advantage rolls the d20 of the expression twice and keeps the
+// highest result, disadvantage the lowest.
+const (
+	ModeAdvantage    = "advantage"
+	ModeDisadvantage = "disadvantage"
+)
+
+// maxExplosions bounds the dice an exploding term adds to a roll.
+const maxExplosions = 100
+
+var ErrorNoSingleDie = errors.New("advantage and disadvantage need a term rolling a single d20")
+
+// Expression is a parsed dice expression.
+type Expression struct {
+	terms []*term
+}
+
+type termKind int
+
+const (
+	termConstant termKind = iota
+	termAttribute
+	termDice
+	termFate
+)
+
+type keepMode int
+
+const (
+	keepAll keepMode = iota
+	keepHighest
+	keepLowest
+	dropHighest
+	dropLowest
+)
+
+type term struct {
+	kind      termKind
+	sign      int
+	value     int    // of constants
+	name      string // of attributes
+	count     int    // of dice
+	sides     int
+	explode   bool
+	keep      keepMode
+	keepCount int
+}
+
+// Result is the outcome of a roll, with every die rolled, so it can be checked later.
+type Result struct {
+	Total float64      `json:"total" dynamodbav:"total"`
+	Terms []TermResult `json:"terms" dynamodbav:"terms"`
+}
+
+// TermResult is the outcome of a term of the expression. Value is already signed.
+type TermResult struct {
+	Term  string  `json:"term" dynamodbav:"term"`
+	Dice  []Die   `json:"dice,omitempty" dynamodbav:"dice,omitempty"`
+	Value float64 `json:"value" dynamodbav:"value"`
+}
+
+// Die is a rolled die. Dropped dice don't count towards the total, and exploded ones
+// were added to the roll by the die before them.
+type Die struct {
+	Face     int  `json:"face" dynamodbav:"face"`
+	Dropped  bool `json:"dropped,omitempty" dynamodbav:"dropped,omitempty"`
+	Exploded bool `json:"exploded,omitempty" dynamodbav:"exploded,omitempty"`
+}
+
+// String returns the expression in its canonical form, e.g. "1d20 + @str_mod".
+func (e *Expression) String() string {
+	var b strings.Builder
+	for i, t := range e.terms {
+		switch {
+		case i > 0 && t.sign < 0:
+			b.WriteString(" - ")
+		case i > 0:
+			b.WriteString(" + ")
+		case t.sign < 0:
+			b.WriteString("-")
+		}
+		b.WriteString(t.String())
+	}
+	return b.String()
+}
+
+func (t *term) String() string {
+	switch t.kind {
+	case termConstant:
+		return strconv.Itoa(t.value)
+	case termAttribute:
+		return "@" + t.name
+	}
+
+	s := strconv.Itoa(t.count) + "d"
+	if t.kind == termFate {
+		s += "F"
+	} else {
+		s += strconv.Itoa(t.sides)
+	}
+
+	if t.explode {
+		s += "!"
+	}
+
+	switch t.keep {
+	case keepHighest:
+		s += "kh" + strconv.Itoa(t.keepCount)
+	case keepLowest:
+		s += "kl" + strconv.Itoa(t.keepCount)
+	case dropHighest:
+		s += "dh" + strconv.Itoa(t.keepCount)
+	case dropLowest:
+		s += "dl" + strconv.Itoa(t.keepCount)
+	}
+
+	return s
+}
+
+// Attributes returns the names of the attributes the expression refers to.
+func (e *Expression) Attributes() []string {
+	var names []string
+	for _, t := range e.terms {
+		if t.kind == termAttribute {
+			names = append(names, t.name)
+		}
+	}
+	return names
+}
+
+// SetMode applies advantage or disadvantage to the first term rolling a single d20,
+// turning 1d20 into 2d20kh1 or 2d20kl1.
+func (e *Expression) SetMode(mode string) error {
+	for _, t := range e.terms {
+		if t.kind == termDice && t.count == 1 && t.sides == 20 && t.keep == keepAll {
+			t.count = 2
+			t.keepCount = 1
+			if mode == ModeAdvantage {
+				t.keep = keepHighest
+			} else {
+				t.keep = keepLowest
+			}
+			return nil
+		}
+	}
+	return ErrorNoSingleDie
+}
+
+// Roll rolls the expression, taking the values of the attributes it refers to from
+// values. Missing attributes are an error.
+func (e *Expression) Roll(values map[string]float64) (*Result, error) {
+	result := &Result{}
+
+	for _, t := range e.terms {
+		tr := TermResult{Term: t.String()}
+
+		switch t.kind {
+		case termConstant:
+			tr.Value = float64(t.value)
+		case termAttribute:
+			value, found := values[t.name]
+			if !found {
+				return nil, fmt.Errorf("missing value for @%s", t.name)
+			}
+			tr.Value = value
+		default:
+			dice, err := t.roll()
+			if err != nil {
+				return nil, err
+			}
+
+			for _, d := range dice {
+				if !d.Dropped {
+					tr.Value += float64(d.Face)
+				}
+			}
+			tr.Dice = dice
+		}
+
+		tr.Value *= float64(t.sign)
+		result.Total += tr.Value
+		result.Terms = append(result.Terms, tr)
+	}
+
+	return result, nil
+}
+
+func (t *term) roll() ([]Die, error) {
+	var dice []Die
+
+	for i := 0; i < t.count; i++ {
+		face, err := t.rollDie()
+		if err != nil {
+			return nil, err
+		}
+		dice = append(dice, Die{Face: face})
+
+		for explosions := 0; t.explode && face == t.sides && explosions < maxExplosions; explosions++ {
+			face, err = t.rollDie()
+			if err != nil {
+				return nil, err
+			}
+			dice = append(dice, Die{Face: face, Exploded: true})
+		}
+	}
+
+	if t.keep == keepAll {
+		return dice, nil
+	}
+
+	// Sort the indexes of the dice by face, lowest first, and mark the ones left out.
+	// Exploded dice count as any other, so there are never fewer dice than keepCount.
+	order := make([]int, len(dice))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return dice[order[a]].Face < dice[order[b]].Face
+	})
+
+	var dropped []int
+	switch t.keep {
+	case keepHighest:
+		dropped = order[:len(order)-t.keepCount]
+	case keepLowest:
+		dropped = order[t.keepCount:]
+	case dropHighest:
+		dropped = order[len(order)-t.keepCount:]
+	case dropLowest:
+		dropped = order[:t.keepCount]
+	}
+
+	for _, i := range dropped {
+		dice[i].Dropped = true
+	}
+
+	return dice, nil
+}
+
+func (t *term) rollDie() (int, error) {
+	if t.kind == termFate {
+		n, err := random(3)
+		return n - 1, err
+	}
+
+	n, err := random(t.sides)
+	return n + 1, err
+}
+
+// random returns a uniformly distributed number in [0, n).
+func random(n int) (int, error) {
+	v, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return 0, err
+	}
+	return int(v.Int64()), nil
+}
@@ -0,0 +1,223 @@
+package dice
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{"single die", "d20", "1d20"},
+		{"several dice", "2d6", "2d6"},
+		{"constant", "5", "5"},
+		{"sum", "1d20 + 5", "1d20 + 5"},
+		{"subtraction", "1d20-2", "1d20 - 2"},
+		{"leading sign", "-1d4+3", "-1d4 + 3"},
+		{"attribute", "1d20+@str_mod", "1d20 + @str_mod"},
+		{"percentile", "d%", "1d100"},
+		{"fate", "4dF", "4dF"},
+		{"case insensitive", "4D6KH3", "4d6kh3"},
+		{"keep defaults to highest", "2d20k", "2d20kh1"},
+		{"keep lowest", "2d20kl1", "2d20kl1"},
+		{"drop lowest", "4d6dl", "4d6dl1"},
+		{"drop highest", "4d6dh2", "4d6dh2"},
+		{"exploding", "3d6!", "3d6!"},
+		{"exploding and keep", "3d6!kh2", "3d6!kh2"},
+		{"spaces", "  1d8 +  2 ", "1d8 + 2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := Parse(tt.source)
+			if err != nil {
+				t.Fatalf("Parse(%q) returned error: %v", tt.source, err)
+			}
+
+			if got := e.String(); got != tt.want {
+				t.Errorf("Parse(%q).String() = %q, want %q", tt.source, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{"empty", "", "unexpected end of expression at 0"},
+		{"trailing operator", "1d20+", "unexpected end of expression at 5"},
+		{"unknown character", "1d20*2", `unexpected '*' at 4`},
+		{"missing sides", "2d", "expected the number of sides at 2"},
+		{"zero dice", "0d6", "must roll at least one die at 0"},
+		{"too many sides", "1d1001", "dice must have between 1 and 1000 sides at 0"},
+		{"keep too many", "2d6kh3", "can't keep or drop more dice than are rolled at 3"},
+		{"two keep modifiers", "4d6kh3dl1", "only one keep or drop modifier is allowed at 6"},
+		{"exploding fate", "4dF!", "these dice can't explode at 3"},
+		{"exploding d1", "1d1!", "these dice can't explode at 3"},
+		{"empty attribute", "1d20+@", "expected an attribute name at 6"},
+		{"huge number", "99999999d6", "number too large at 0"},
+		{"too many dice", "60d6+41d6", "must not roll more than 100 dice"},
+		{"too many terms", strings.Repeat("1+", 20) + "1", "must not have more than 20 terms"},
+		{"too long", strings.Repeat("1", 201), "must not be more than 200 characters long"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.source)
+			if err == nil {
+				t.Fatalf("Parse(%q) succeeded, want error %q", tt.source, tt.want)
+			}
+
+			if err.Error() != tt.want {
+				t.Errorf("Parse(%q) error = %q, want %q", tt.source, err.Error(), tt.want)
+			}
+		})
+	}
+}
+
+func TestRoll(t *testing.T) {
+	tests := []struct {
+		name     string
+		source   string
+		values   map[string]float64
+		min, max float64
+		dice     int // dice rolled, not counting explosions
+		kept     int // dice counting towards the total
+	}{
+		{"constant", "7", nil, 7, 7, 0, 0},
+		{"single die", "1d6", nil, 1, 6, 1, 1},
+		{"modifier", "1d20+5", nil, 6, 25, 1, 1},
+		{"negative", "-1d4", nil, -4, -1, 1, 1},
+		{"attribute", "1d20+@str_mod", map[string]float64{"str_mod": 3}, 4, 23, 1, 1},
+		{"keep highest", "4d6kh3", nil, 3, 18, 4, 3},
+		{"drop lowest", "4d6dl1", nil, 3, 18, 4, 3},
+		{"keep lowest", "2d20kl1", nil, 1, 20, 2, 1},
+		{"fate", "4dF", nil, -4, 4, 4, 4},
+		{"percentile", "1d%", nil, 1, 100, 1, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := Parse(tt.source)
+			if err != nil {
+				t.Fatalf("Parse(%q) returned error: %v", tt.source, err)
+			}
+
+			// Rolls are random, so roll enough times to cover the faces.
+			for i := 0; i < 200; i++ {
+				result, err := e.Roll(tt.values)
+				if err != nil {
+					t.Fatalf("Roll() returned error: %v", err)
+				}
+
+				if result.Total < tt.min || result.Total > tt.max {
+					t.Fatalf("Roll() total = %v, want between %v and %v", result.Total, tt.min, tt.max)
+				}
+
+				var rolled, kept int
+				var sum float64
+				for _, tr := range result.Terms {
+					sum += tr.Value
+					for _, d := range tr.Dice {
+						if !d.Exploded {
+							rolled++
+						}
+						if !d.Dropped {
+							kept++
+						}
+					}
+				}
+
+				if sum != result.Total {
+					t.Fatalf("Roll() terms add up to %v, want the total %v", sum, result.Total)
+				}
+				if rolled != tt.dice || kept != tt.kept {
+					t.Fatalf("Roll() rolled %d dice and kept %d, want %d and %d", rolled, kept, tt.dice, tt.kept)
+				}
+			}
+		})
+	}
+}
+
+func TestRollExploding(t *testing.T) {
+	e, err := Parse("1d2!")
+	if err != nil {
+		t.Fatalf("Parse() returned error: %v", err)
+	}
+
+	for i := 0; i < 200; i++ {
+		result, err := e.Roll(nil)
+		if err != nil {
+			t.Fatalf("Roll() returned error: %v", err)
+		}
+
+		dice := result.Terms[0].Dice
+		for j, d := range dice {
+			// Every die but the last one rolled its highest face, adding the next one.
+			last := j == len(dice)-1
+			if !last && d.Face != 2 {
+				t.Fatalf("Roll() die %d = %d, want 2 before an explosion", j, d.Face)
+			}
+			if last && d.Face == 2 && len(dice) <= maxExplosions {
+				t.Fatalf("Roll() last die = 2, want it to explode")
+			}
+			if d.Exploded != (j > 0) {
+				t.Fatalf("Roll() die %d exploded = %v, want %v", j, d.Exploded, j > 0)
+			}
+		}
+	}
+}
+
+func TestRollMissingAttribute(t *testing.T) {
+	e, err := Parse("1d20+@dex_mod")
+	if err != nil {
+		t.Fatalf("Parse() returned error: %v", err)
+	}
+
+	_, err = e.Roll(map[string]float64{"str_mod": 1})
+	if err == nil || err.Error() != "missing value for @dex_mod" {
+		t.Errorf("Roll() error = %v, want missing value for @dex_mod", err)
+	}
+}
+
+func TestSetMode(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		mode   string
+		want   string
+		err    error
+	}{
+		{"advantage", "1d20+5", ModeAdvantage, "2d20kh1 + 5", nil},
+		{"disadvantage", "1d20+5", ModeDisadvantage, "2d20kl1 + 5", nil},
+		{"first single d20", "1d4+1d20+1d20", ModeAdvantage, "1d4 + 2d20kh1 + 1d20", nil},
+		{"no d20", "1d6", ModeAdvantage, "", ErrorNoSingleDie},
+		{"several d20", "2d20", ModeAdvantage, "", ErrorNoSingleDie},
+		{"exploding d20", "1d20!", ModeAdvantage, "2d20!kh1", nil},
+		{"d20 with keep", "1d20kh1", ModeAdvantage, "", ErrorNoSingleDie},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := Parse(tt.source)
+			if err != nil {
+				t.Fatalf("Parse(%q) returned error: %v", tt.source, err)
+			}
+
+			err = e.SetMode(tt.mode)
+			if err != tt.err {
+				t.Fatalf("SetMode(%q) error = %v, want %v", tt.mode, err, tt.err)
+			}
+
+			if tt.err == nil && e.String() != tt.want {
+				t.Errorf("SetMode(%q) = %q, want %q", tt.mode, e.String(), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,266 @@
+package dice
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The limits of an expression, so a single roll can't keep the server busy.
+const (
+	maxLength = 200
+	maxTerms  = 20
+	maxDice   = 100
+	maxSides  = 1000
+)
+
+// Parse parses a dice expression, reporting the position of the first syntax error.
+// An expression is a sum of terms, each one being:
+//
+//	NdS     N dice of S sides, N defaulting to 1, e.g. 2d6 or d20
+//	Nd%     N percentile dice, the same as Nd100
+//	NdF     N Fate dice, worth -1, 0 or +1 each
+//	N       a constant
+//	@name   the value of a character attribute, e.g. @str_mod
+//
+// Dice may be followed by modifiers: khN and klN keep the N highest or lowest dice, dhN
+// and dlN drop them (N defaulting to 1, and k alone meaning kh), and ! explodes the dice
+// rolling their highest face, rolling one more die each time.
+func Parse(source string) (*Expression, error) {
+	if len(source) > maxLength {
+		return nil, fmt.Errorf("must not be more than %d characters long", maxLength)
+	}
+
+	p := &parser{source: source}
+	e := &Expression{}
+
+	sign := 1
+	if p.accept('-') {
+		sign = -1
+	} else {
+		p.accept('+')
+	}
+
+	for {
+		t, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		t.sign = sign
+		e.terms = append(e.terms, t)
+
+		switch {
+		case p.accept('+'):
+			sign = 1
+		case p.accept('-'):
+			sign = -1
+		case p.done():
+			return e, e.check()
+		default:
+			return nil, fmt.Errorf("unexpected %q at %d", p.source[p.pos], p.pos)
+		}
+	}
+}
+
+// check enforces the limits of the expression as a whole.
+func (e *Expression) check() error {
+	if len(e.terms) > maxTerms {
+		return fmt.Errorf("must not have more than %d terms", maxTerms)
+	}
+
+	count := 0
+	for _, t := range e.terms {
+		count += t.count
+	}
+	if count > maxDice {
+		return fmt.Errorf("must not roll more than %d dice", maxDice)
+	}
+
+	return nil
+}
+
+type parser struct {
+	source string
+	pos    int
+}
+
+func (p *parser) skipSpaces() {
+	for p.pos < len(p.source) && p.source[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+func (p *parser) done() bool {
+	p.skipSpaces()
+	return p.pos == len(p.source)
+}
+
+func (p *parser) peek() byte {
+	if p.pos < len(p.source) {
+		return p.source[p.pos]
+	}
+	return 0
+}
+
+func (p *parser) accept(c byte) bool {
+	p.skipSpaces()
+	if p.peek() == c {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// acceptFold is like accept for letters, ignoring their case, and doesn't skip spaces,
+// as the parts of a dice term are written together.
+func (p *parser) acceptFold(c byte) bool {
+	if strings.EqualFold(string(p.peek()), string(c)) && p.peek() != 0 {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) number() (int, bool, error) {
+	start := p.pos
+	for p.pos < len(p.source) && isDigit(p.source[p.pos]) {
+		p.pos++
+	}
+	if start == p.pos {
+		return 0, false, nil
+	}
+
+	n, err := strconv.Atoi(p.source[start:p.pos])
+	if err != nil || n > 1000000 {
+		return 0, false, fmt.Errorf("number too large at %d", start)
+	}
+	return n, true, nil
+}
+
+func (p *parser) term() (*term, error) {
+	p.skipSpaces()
+	start := p.pos
+
+	if p.accept('@') {
+		nameStart := p.pos
+		for p.pos < len(p.source) && isNameChar(p.source[p.pos], p.pos == nameStart) {
+			p.pos++
+		}
+		if p.pos == nameStart {
+			return nil, fmt.Errorf("expected an attribute name at %d", p.pos)
+		}
+		return &term{kind: termAttribute, name: p.source[nameStart:p.pos]}, nil
+	}
+
+	n, found, err := p.number()
+	if err != nil {
+		return nil, err
+	}
+
+	if !p.acceptFold('d') {
+		if !found {
+			if p.pos == len(p.source) {
+				return nil, fmt.Errorf("unexpected end of expression at %d", p.pos)
+			}
+			return nil, fmt.Errorf("unexpected %q at %d", p.source[p.pos], p.pos)
+		}
+		return &term{kind: termConstant, value: n}, nil
+	}
+
+	if !found {
+		n = 1
+	}
+	if n < 1 {
+		return nil, fmt.Errorf("must roll at least one die at %d", start)
+	}
+
+	return p.dice(n, start)
+}
+
+func (p *parser) dice(count, start int) (*term, error) {
+	t := &term{kind: termDice, count: count}
+
+	switch {
+	case p.acceptFold('f'):
+		t.kind = termFate
+	case p.peek() == '%':
+		p.pos++
+		t.sides = 100
+	default:
+		sides, found, err := p.number()
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			return nil, fmt.Errorf("expected the number of sides at %d", p.pos)
+		}
+		if sides < 1 || sides > maxSides {
+			return nil, fmt.Errorf("dice must have between 1 and %d sides at %d", maxSides, start)
+		}
+		t.sides = sides
+	}
+
+	for {
+		pos := p.pos
+
+		switch {
+		case p.peek() == '!':
+			p.pos++
+			if t.kind == termFate || t.sides < 2 {
+				return nil, fmt.Errorf("these dice can't explode at %d", pos)
+			}
+			t.explode = true
+		case p.acceptFold('k'), p.acceptFold('d'):
+			if t.keep != keepAll {
+				return nil, fmt.Errorf("only one keep or drop modifier is allowed at %d", pos)
+			}
+
+			keep := p.source[pos] == 'k' || p.source[pos] == 'K'
+			highest := keep
+			switch {
+			case p.acceptFold('h'):
+				highest = true
+			case p.acceptFold('l'):
+				highest = false
+			}
+
+			n, found, err := p.number()
+			if err != nil {
+				return nil, err
+			}
+			if !found {
+				n = 1
+			}
+			if n > t.count {
+				return nil, fmt.Errorf("can't keep or drop more dice than are rolled at %d", pos)
+			}
+			t.keepCount = n
+
+			switch {
+			case keep && highest:
+				t.keep = keepHighest
+			case keep:
+				t.keep = keepLowest
+			case highest:
+				t.keep = dropHighest
+			default:
+				t.keep = dropLowest
+			}
+		default:
+			return t, nil
+		}
+	}
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isNameChar(c byte, first bool) bool {
+	switch {
+	case c == '_', c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		return true
+	default:
+		return !first && isDigit(c)
+	}
+}
@@ -0,0 +1,16 @@
+package common
+
+import "strings"
+
+// The listings take a sort query string parameter naming the attribute to sort by,
+// prefixed with "-" for descending order, e.g. "-createdAt".
+
+// SortColumn returns the attribute named by a sort parameter.
+func SortColumn(sort string) string {
+	return strings.TrimPrefix(sort, "-")
+}
+
+// SortDescending reports whether a sort parameter asks for descending order.
+func SortDescending(sort string) bool {
+	return strings.HasPrefix(sort, "-")
+}
@@ -0,0 +1,388 @@
+package items
+
+import (
+	"errors"
+	"fmt"
+
+	common "github.com/jplindgren/rpg-vault/internal"
+	"github.com/jplindgren/rpg-vault/internal/storage"
+	"github.com/jplindgren/rpg-vault/internal/validator"
+)
+
+//const inventoryTableName = "rpg_inventory"
+
+// Inventory entries belong to the world of their character, so they are deleted along
+// with it, and are read per character from the characterId-index.
+const inventoryCharacterIndex = "characterId-index"
+
+// MaxEntries bounds the size of an inventory, which is always read whole, and MaxDepth
+// how deep containers can be nested.
+const (
+	MaxEntries = 1000
+	MaxDepth   = 8
+)
+
+// ErrorTransferTooLarge is returned when a container holds more entries than a single
+// transaction can move.
+var ErrorTransferTooLarge = fmt.Errorf("a transfer can't move more than %d entries at once", storage.MaxTransactWrites)
+
+type EntryKey struct {
+	WorldId string `dynamodbav:"worldId"`
+	Id      string `dynamodbav:"id"`
+}
+
+type InventoryService struct {
+	db         storage.Store
+	tableName  string
+	itemsTable string
+}
+
+func NewInventorySrv(db storage.Store, tableName, itemsTable string) *InventoryService {
+	return &InventoryService{
+		db:         db,
+		tableName:  tableName,
+		itemsTable: itemsTable,
+	}
+}
+
+// Add puts a new entry in the inventory of a character.
+func (is *InventoryService) Add(entry *Entry) error {
+	entry.Id = common.GenerateToken()
+	entry.CreatedAt = common.GetIsoString()
+	entry.UpdatedAt = entry.CreatedAt
+
+	return is.db.Put(is.tableName, entry, storage.AttributeNotExists("id"))
+}
+
+// Get returns an entry of the inventory of a character, along with its item. Entries of
+// other inventories are reported as not found.
+func (is *InventoryService) Get(worldId, characterId, id string) (*Entry, error) {
+	key := EntryKey{
+		WorldId: worldId,
+		Id:      id,
+	}
+
+	var result Entry
+	err := is.db.Get(is.tableName, key, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	if result.CharacterId != characterId {
+		return nil, common.ErrorRecordNotFound
+	}
+
+	// Like List, report an entry whose container left the inventory as carried directly.
+	if result.ContainerId != "" {
+		var container Entry
+		err = is.db.Get(is.tableName, EntryKey{WorldId: worldId, Id: result.ContainerId}, &container)
+		switch {
+		case errors.Is(err, common.ErrorRecordNotFound):
+			result.ContainerId = ""
+		case err != nil:
+			return nil, err
+		case container.CharacterId != characterId:
+			result.ContainerId = ""
+		}
+	}
+
+	err = is.attachItems(worldId, []*Entry{&result})
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// List returns the whole inventory of a character, each entry along with its item.
+// Entries whose container is no longer in the inventory, which a transfer racing with
+// a change to the container can leave behind, are listed as carried directly.
+func (is *InventoryService) List(worldId, characterId string) ([]Entry, error) {
+	query := &storage.Query{
+		Index:  inventoryCharacterIndex,
+		Key:    "characterId",
+		Value:  characterId,
+		Filter: storage.Equal("worldId", worldId),
+	}
+
+	var resultArr []Entry
+	err := is.db.Query(is.tableName, query, &resultArr)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make(map[string]bool, len(resultArr))
+	entries := make([]*Entry, len(resultArr))
+	for i := range resultArr {
+		ids[resultArr[i].Id] = true
+		entries[i] = &resultArr[i]
+	}
+
+	for _, entry := range entries {
+		if !ids[entry.ContainerId] {
+			entry.ContainerId = ""
+		}
+	}
+
+	err = is.attachItems(worldId, entries)
+	if err != nil {
+		return nil, err
+	}
+
+	if resultArr == nil {
+		resultArr = []Entry{}
+	}
+
+	return resultArr, nil
+}
+
+// attachItems fills in the item of the entries, reading each item once.
+func (is *InventoryService) attachItems(worldId string, entries []*Entry) error {
+	found := make(map[string]*Item)
+
+	for _, entry := range entries {
+		item, read := found[entry.ItemId]
+		if !read {
+			item = &Item{}
+			err := is.db.Get(is.itemsTable, ItemKey{WorldId: worldId, Id: entry.ItemId}, item)
+			switch {
+			case errors.Is(err, common.ErrorRecordNotFound):
+				item = nil
+			case err != nil:
+				return err
+			}
+			found[entry.ItemId] = item
+		}
+
+		entry.Item = item
+	}
+
+	return nil
+}
+
+// Weight returns the total weight of the inventory of a character, containers and
+// what they hold included.
+func (is *InventoryService) Weight(worldId, characterId string) (float64, error) {
+	entries, err := is.List(worldId, characterId)
+	if err != nil {
+		return 0, err
+	}
+
+	weight := 0.0
+	for _, entry := range entries {
+		if entry.Item != nil {
+			weight += entry.Item.Weight * float64(entry.Quantity)
+		}
+	}
+
+	return weight, nil
+}
+
+// Update saves the quantity, equip state and container of an entry. It fails with
+// common.ErrorEditConflict when the entry was moved to another inventory meanwhile.
+func (is *InventoryService) Update(entry *Entry) error {
+	key := EntryKey{
+		WorldId: entry.WorldId,
+		Id:      entry.Id,
+	}
+
+	entry.UpdatedAt = common.GetIsoString()
+
+	update := storage.Set("quantity", entry.Quantity).
+		Set("equipped", entry.Equipped).
+		Set("updatedAt", entry.UpdatedAt).
+		If(storage.Equal("characterId", entry.CharacterId))
+
+	if entry.ContainerId != "" {
+		update = update.Set("containerId", entry.ContainerId)
+	} else {
+		update = update.Remove("containerId")
+	}
+
+	err := is.db.Update(is.tableName, key, update)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrorConditionFailed):
+			return common.ErrorEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Delete removes an entry from the inventory of a character. What it held is moved out
+// to the container holding it, if any.
+func (is *InventoryService) Delete(entry *Entry) error {
+	all, err := is.List(entry.WorldId, entry.CharacterId)
+	if err != nil {
+		return err
+	}
+
+	err = moveOut(is.db, is.tableName, all, map[string]bool{entry.Id: true})
+	if err != nil {
+		return err
+	}
+
+	return is.db.Delete(is.tableName, EntryKey{WorldId: entry.WorldId, Id: entry.Id})
+}
+
+// Contents returns the entries held by an entry, directly or inside other containers,
+// given the whole inventory.
+func Contents(entry *Entry, inventory []Entry) []Entry {
+	held := map[string]bool{entry.Id: true}
+	var contents []Entry
+
+	// Each pass picks up at least one more level of nesting, until there are no more.
+	for {
+		found := false
+		for _, e := range inventory {
+			if !held[e.Id] && held[e.ContainerId] {
+				held[e.Id] = true
+				contents = append(contents, e)
+				found = true
+			}
+		}
+		if !found {
+			break
+		}
+	}
+
+	return contents
+}
+
+// Transfer moves quantity of an entry to the inventory of another character of the
+// same world, returning the entry it ends up in there. Either the whole transfer is
+// applied or nothing is: it fails with common.ErrorEditConflict when the entry changed
+// since it was read. Moving a whole entry moves what it holds along with it, so
+// contents must hold the entries returned by Contents for it. A part of a stack is
+// split into a new entry, and can't hold anything.
+func (is *InventoryService) Transfer(entry *Entry, contents []Entry, toCharacterId string, quantity int) (*Entry, error) {
+	if len(contents)+1 > storage.MaxTransactWrites {
+		return nil, ErrorTransferTooLarge
+	}
+
+	now := common.GetIsoString()
+	fromSource := storage.And(
+		storage.Equal("characterId", entry.CharacterId),
+		storage.Equal("quantity", entry.Quantity),
+	)
+
+	var writes []storage.Write
+	moved := *entry
+
+	if quantity < entry.Quantity {
+		writes = append(writes, storage.Write{
+			Table:  is.tableName,
+			Key:    EntryKey{WorldId: entry.WorldId, Id: entry.Id},
+			Update: storage.Set("quantity", entry.Quantity-quantity).Set("updatedAt", now).If(fromSource),
+		})
+
+		moved = Entry{
+			WorldId:     entry.WorldId,
+			Id:          common.GenerateToken(),
+			CharacterId: toCharacterId,
+			ItemId:      entry.ItemId,
+			Quantity:    quantity,
+			Item:        entry.Item,
+			CreatedAt:   now,
+			UpdatedAt:   now,
+		}
+
+		writes = append(writes, storage.Write{
+			Table: is.tableName,
+			Item:  moved,
+			Cond:  storage.AttributeNotExists("id"),
+		})
+	} else {
+		moved.CharacterId = toCharacterId
+		moved.Equipped = false
+		moved.ContainerId = ""
+		moved.UpdatedAt = now
+
+		writes = append(writes, storage.Write{
+			Table: is.tableName,
+			Key:   EntryKey{WorldId: entry.WorldId, Id: entry.Id},
+			Update: storage.Set("characterId", toCharacterId).
+				Set("equipped", false).
+				Set("updatedAt", now).
+				Remove("containerId").
+				If(fromSource),
+		})
+
+		for _, e := range contents {
+			writes = append(writes, storage.Write{
+				Table: is.tableName,
+				Key:   EntryKey{WorldId: e.WorldId, Id: e.Id},
+				Update: storage.Set("characterId", toCharacterId).
+					Set("equipped", false).
+					Set("updatedAt", now).
+					If(storage.And(
+						storage.Equal("characterId", entry.CharacterId),
+						storage.Equal("containerId", e.ContainerId),
+					)),
+			})
+		}
+	}
+
+	err := is.db.TransactWrite(writes)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrorConditionFailed):
+			return nil, common.ErrorEditConflict
+		default:
+			return nil, err
+		}
+	}
+
+	return &moved, nil
+}
+
+// moveOut moves the entries held by the removed entries out to the closest container
+// holding them that isn't removed, or to the inventory itself. inventory holds at
+// least the entries of the characters the removed entries belong to.
+func moveOut(db storage.Store, table string, inventory []Entry, removed map[string]bool) error {
+	containers := make(map[string]string, len(inventory))
+	for _, entry := range inventory {
+		containers[entry.Id] = entry.ContainerId
+	}
+
+	for _, entry := range inventory {
+		if removed[entry.Id] || !removed[entry.ContainerId] {
+			continue
+		}
+
+		containerId := entry.ContainerId
+		for depth := 0; removed[containerId] && depth <= MaxDepth; depth++ {
+			containerId = containers[containerId]
+		}
+		if removed[containerId] {
+			containerId = ""
+		}
+
+		// Entries deleted or transferred meanwhile are left alone.
+		update := storage.Set("updatedAt", common.GetIsoString()).
+			If(storage.Equal("characterId", entry.CharacterId))
+		if containerId != "" {
+			update = update.Set("containerId", containerId)
+		} else {
+			update = update.Remove("containerId")
+		}
+
+		err := db.Update(table, EntryKey{WorldId: entry.WorldId, Id: entry.Id}, update)
+		if err != nil && !errors.Is(err, storage.ErrorConditionFailed) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func ValidateEntry(v *validator.Validator, entry *Entry) {
+	v.Check(entry.ItemId != "", "itemId", "must be provided")
+	v.Check(entry.Quantity > 0, "quantity", "must be greater than zero")
+	v.Check(entry.Quantity <= 1000000, "quantity", "must not be more than one million")
+	v.Check(!entry.Equipped || entry.ContainerId == "", "equipped", "items inside a container can't be equipped")
+	v.Check(entry.ContainerId == "" || entry.ContainerId != entry.Id, "containerId", "must not be the entry itself")
+}
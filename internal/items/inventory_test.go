@@ -0,0 +1,165 @@
+package items
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+	"testing"
+
+	common "github.com/jplindgren/rpg-vault/internal"
+	"github.com/jplindgren/rpg-vault/internal/storage"
+)
+
+func newTestInventorySrv(t *testing.T) (*InventoryService, map[string]*Entry) {
+	store := storage.NewMemory(
+		storage.Table{Name: "items", PartitionKey: "worldId", SortKey: "id"},
+		storage.Table{Name: "inventory", PartitionKey: "worldId", SortKey: "id", Indexes: []storage.Index{
+			{Name: inventoryCharacterIndex, PartitionKey: "characterId", SortKey: "id"},
+		}},
+	)
+	is := NewInventorySrv(store, "inventory", "items")
+
+	// Alice carries a bag holding a pouch with potions, a sword and some arrows.
+	entries := map[string]*Entry{}
+	for _, e := range []struct {
+		itemId, container string
+		quantity          int
+		equipped          bool
+	}{
+		{"bag", "", 1, false},
+		{"pouch", "bag", 1, false},
+		{"potion", "pouch", 3, false},
+		{"sword", "", 1, true},
+		{"arrow", "", 20, false},
+	} {
+		entry := &Entry{WorldId: "w1", CharacterId: "alice", ItemId: e.itemId, Quantity: e.quantity, Equipped: e.equipped}
+		if e.container != "" {
+			entry.ContainerId = entries[e.container].Id
+		}
+
+		err := is.Add(entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries[e.itemId] = entry
+	}
+
+	return is, entries
+}
+
+// carried describes an inventory by the quantity of each item and the item of the
+// container holding it, if any.
+func carried(t *testing.T, is *InventoryService, characterId string) map[string]string {
+	inventory, err := is.List("w1", characterId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	itemIds := map[string]string{}
+	for _, e := range inventory {
+		itemIds[e.Id] = e.ItemId
+	}
+
+	result := map[string]string{}
+	for _, e := range inventory {
+		desc := strconv.Itoa(e.Quantity)
+		if e.ContainerId != "" {
+			desc += " in " + itemIds[e.ContainerId]
+		}
+		if e.Equipped {
+			desc += " equipped"
+		}
+		result[e.ItemId] = desc
+	}
+	return result
+}
+
+func TestTransfer(t *testing.T) {
+	tests := []struct {
+		name      string
+		itemId    string
+		quantity  int
+		stale     func(entry *Entry)
+		wantErr   error
+		wantAlice map[string]string
+		wantBob   map[string]string
+	}{
+		{
+			name: "container with its contents", itemId: "bag", quantity: 1,
+			wantAlice: map[string]string{"sword": "1 equipped", "arrow": "20"},
+			wantBob:   map[string]string{"bag": "1", "pouch": "1 in bag", "potion": "3 in pouch"},
+		},
+		{
+			name: "nested container", itemId: "pouch", quantity: 1,
+			wantAlice: map[string]string{"bag": "1", "sword": "1 equipped", "arrow": "20"},
+			wantBob:   map[string]string{"pouch": "1", "potion": "3 in pouch"},
+		},
+		{
+			name: "equipped entry", itemId: "sword", quantity: 1,
+			wantAlice: map[string]string{"bag": "1", "pouch": "1 in bag", "potion": "3 in pouch", "arrow": "20"},
+			wantBob:   map[string]string{"sword": "1"},
+		},
+		{
+			name: "part of a stack", itemId: "arrow", quantity: 5,
+			wantAlice: map[string]string{"bag": "1", "pouch": "1 in bag", "potion": "3 in pouch", "sword": "1 equipped", "arrow": "15"},
+			wantBob:   map[string]string{"arrow": "5"},
+		},
+		{
+			name: "part of a stack inside a container", itemId: "potion", quantity: 1,
+			wantAlice: map[string]string{"bag": "1", "pouch": "1 in bag", "potion": "2 in pouch", "sword": "1 equipped", "arrow": "20"},
+			wantBob:   map[string]string{"potion": "1"},
+		},
+		{
+			name: "stale quantity", itemId: "arrow", quantity: 5,
+			stale:     func(entry *Entry) { entry.Quantity = 19 },
+			wantErr:   common.ErrorEditConflict,
+			wantAlice: map[string]string{"bag": "1", "pouch": "1 in bag", "potion": "3 in pouch", "sword": "1 equipped", "arrow": "20"},
+			wantBob:   map[string]string{},
+		},
+		{
+			name: "stale owner", itemId: "sword", quantity: 1,
+			stale:     func(entry *Entry) { entry.CharacterId = "carol" },
+			wantErr:   common.ErrorEditConflict,
+			wantAlice: map[string]string{"bag": "1", "pouch": "1 in bag", "potion": "3 in pouch", "sword": "1 equipped", "arrow": "20"},
+			wantBob:   map[string]string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			is, entries := newTestInventorySrv(t)
+
+			inventory, err := is.List("w1", "alice")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			entry := *entries[tt.itemId]
+			if tt.stale != nil {
+				tt.stale(&entry)
+			}
+
+			_, err = is.Transfer(&entry, Contents(&entry, inventory), "bob", tt.quantity)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Transfer() error = %v, want %v", err, tt.wantErr)
+			}
+
+			if got := carried(t, is, "alice"); !reflect.DeepEqual(got, tt.wantAlice) {
+				t.Errorf("alice carries %v, want %v", got, tt.wantAlice)
+			}
+			if got := carried(t, is, "bob"); !reflect.DeepEqual(got, tt.wantBob) {
+				t.Errorf("bob carries %v, want %v", got, tt.wantBob)
+			}
+		})
+	}
+}
+
+func TestTransferTooLarge(t *testing.T) {
+	is, entries := newTestInventorySrv(t)
+
+	contents := make([]Entry, storage.MaxTransactWrites)
+	_, err := is.Transfer(entries["bag"], contents, "bob", 1)
+	if !errors.Is(err, ErrorTransferTooLarge) {
+		t.Errorf("Transfer() error = %v, want %v", err, ErrorTransferTooLarge)
+	}
+}
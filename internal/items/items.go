@@ -0,0 +1,237 @@
+package items
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+
+	common "github.com/jplindgren/rpg-vault/internal"
+	"github.com/jplindgren/rpg-vault/internal/storage"
+	"github.com/jplindgren/rpg-vault/internal/validator"
+)
+
+//const itemTableName = "rpg_items"
+
+type ItemKey struct {
+	WorldId string `dynamodbav:"worldId"`
+	Id      string `dynamodbav:"id"`
+}
+
+type ItemService struct {
+	db             storage.Store
+	tableName      string
+	inventoryTable string
+}
+
+func New(db storage.Store, tableName, inventoryTable string) *ItemService {
+	return &ItemService{
+		db:             db,
+		tableName:      tableName,
+		inventoryTable: inventoryTable,
+	}
+}
+
+func (is *ItemService) Insert(item *Item) error {
+	item.Id = common.GenerateToken()
+	item.CreatedAt = common.GetIsoString()
+	item.UpdatedAt = item.CreatedAt
+	item.NameLower = strings.ToLower(item.Name)
+	if item.Properties == nil {
+		item.Properties = map[string]interface{}{}
+	}
+
+	return is.db.Put(is.tableName, item, nil)
+}
+
+func (is *ItemService) Get(worldId, id string) (*Item, error) {
+	key := ItemKey{
+		WorldId: worldId,
+		Id:      id,
+	}
+
+	var result Item
+	err := is.db.Get(is.tableName, key, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	if result.Properties == nil {
+		result.Properties = map[string]interface{}{}
+	}
+
+	return &result, nil
+}
+
+// List returns a page of the items of a world matching the filters, starting after the
+// start key, along with the key of the next page, or nil on the last one.
+func (is *ItemService) List(worldId string, filters Filters, limit int, start storage.Item) (*[]Item, storage.Item, error) {
+	query := &storage.Query{
+		Index:      common.SortColumn(filters.Sort) + "-index",
+		Key:        "worldId",
+		Value:      worldId,
+		Filter:     filters.condition(),
+		Descending: common.SortDescending(filters.Sort),
+		Limit:      limit,
+		StartKey:   start,
+	}
+
+	var resultArr []Item
+	next, err := is.db.QueryPage(is.tableName, query, &resultArr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for i := range resultArr {
+		if resultArr[i].Properties == nil {
+			resultArr[i].Properties = map[string]interface{}{}
+		}
+	}
+
+	return &resultArr, next, nil
+}
+
+// condition returns the condition an item must meet to match the filters, or nil when
+// every item does.
+func (f Filters) condition() *storage.Condition {
+	var conds []*storage.Condition
+
+	if f.Name != "" {
+		conds = append(conds, storage.ContainsFold("name", "nameLower", f.Name))
+	}
+
+	if f.Rarity != "" {
+		conds = append(conds, storage.Equal("rarity", f.Rarity))
+	}
+
+	if len(conds) == 0 {
+		return nil
+	}
+
+	return storage.And(conds...)
+}
+
+// Update saves the changes to an item. It fails with common.ErrorRecordNotFound when
+// the item was deleted meanwhile, rather than recreating it.
+func (is *ItemService) Update(item *Item) error {
+	key := ItemKey{
+		WorldId: item.WorldId,
+		Id:      item.Id,
+	}
+
+	item.UpdatedAt = common.GetIsoString()
+
+	update := storage.Set("name", item.Name).
+		Set("nameLower", strings.ToLower(item.Name)).
+		Set("description", item.Description).
+		Set("weight", item.Weight).
+		Set("value", item.Value).
+		Set("rarity", item.Rarity).
+		Set("container", item.Container).
+		Set("properties", item.Properties).
+		Set("updatedAt", item.UpdatedAt).
+		If(storage.AttributeExists("id"))
+
+	err := is.db.Update(is.tableName, key, update)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrorConditionFailed):
+			return common.ErrorRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// HoldsEntries reports whether an inventory entry of the item holds other entries, in
+// which case the item must stay a container.
+func (is *ItemService) HoldsEntries(worldId, id string) (bool, error) {
+	var all []Entry
+	err := is.db.Query(is.inventoryTable, &storage.Query{Key: "worldId", Value: worldId}, &all)
+	if err != nil {
+		return false, err
+	}
+
+	entries := make(map[string]bool)
+	for _, entry := range all {
+		if entry.ItemId == id {
+			entries[entry.Id] = true
+		}
+	}
+
+	for _, entry := range all {
+		if entries[entry.ContainerId] {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// Delete removes an item along with the inventory entries holding it. What those
+// entries held is moved out to the container holding them, if any, so nothing else is
+// lost.
+func (is *ItemService) Delete(worldId, id string) error {
+	var all []Entry
+	err := is.db.Query(is.inventoryTable, &storage.Query{Key: "worldId", Value: worldId}, &all)
+	if err != nil {
+		return err
+	}
+
+	removed := make(map[string]bool)
+	for _, entry := range all {
+		if entry.ItemId == id {
+			removed[entry.Id] = true
+		}
+	}
+
+	err = moveOut(is.db, is.inventoryTable, all, removed)
+	if err != nil {
+		return err
+	}
+
+	var keys []map[string]string
+	for entryId := range removed {
+		keys = append(keys, map[string]string{"worldId": worldId, "id": entryId})
+	}
+
+	err = is.db.BatchDelete(is.inventoryTable, keys)
+	if err != nil {
+		return err
+	}
+
+	return is.db.Delete(is.tableName, ItemKey{WorldId: worldId, Id: id})
+}
+
+// PropertyNameRX matches the names of the custom properties of an item.
+var PropertyNameRX = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]{0,63}$`)
+
+func ValidateItem(v *validator.Validator, item *Item) {
+	v.Check(item.Name != "", "name", "must be provided")
+	v.Check(len(item.Name) <= 200, "name", "must not be more than 200 characters long")
+	v.Check(len(item.Description) <= 10000, "description", "must not be more than 10000 characters long")
+	v.Check(item.Weight >= 0, "weight", "must not be negative")
+	v.Check(item.Value >= 0, "value", "must not be negative")
+	v.Check(validator.PermittedValue(item.Rarity, Rarities...), "rarity", "must be one of "+strings.Join(Rarities, ", "))
+
+	v.Check(len(item.Properties) <= 50, "properties", "must not contain more than 50 properties")
+	for name, value := range item.Properties {
+		key := "properties." + name
+		v.Check(validator.Matches(name, PropertyNameRX), key, "must be a name of letters, digits and underscores")
+
+		switch value := value.(type) {
+		case string:
+			v.Check(len(value) <= 1000, key, "must not be more than 1000 characters long")
+		case float64, bool:
+		default:
+			v.AddError(key, "must be a string, a number or a boolean")
+		}
+	}
+}
+
+func ValidateFilters(v *validator.Validator, f Filters) {
+	v.Check(validator.PermittedValue(f.Sort, SortSafelist...), "sort", "invalid sort value")
+	v.Check(len(f.Name) <= 200, "name", "must not be more than 200 characters long")
+	v.Check(f.Rarity == "" || validator.PermittedValue(f.Rarity, Rarities...), "rarity", "must be one of "+strings.Join(Rarities, ", "))
+}
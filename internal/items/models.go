@@ -0,0 +1,59 @@
+package items
+
+// Item is the definition of a kind of item of a world, like a longsword or a potion of
+// healing, which characters carry in their inventories. Weight and Value are in the
+// units the world uses, e.g. pounds and gold pieces. Only containers, like a backpack,
+// can hold other items. Properties holds whatever else the game needs, like the damage
+// of a weapon.
+type Item struct {
+	WorldId     string                 `json:"worldId" dynamodbav:"worldId"`
+	Id          string                 `json:"id" dynamodbav:"id"`
+	Name        string                 `json:"name" dynamodbav:"name"`
+	NameLower   string                 `json:"-" dynamodbav:"nameLower,omitempty"`
+	Description string                 `json:"description" dynamodbav:"description"`
+	Weight      float64                `json:"weight" dynamodbav:"weight"`
+	Value       float64                `json:"value" dynamodbav:"value"`
+	Rarity      string                 `json:"rarity" dynamodbav:"rarity"`
+	Container   bool                   `json:"container" dynamodbav:"container"`
+	Properties  map[string]interface{} `json:"properties" dynamodbav:"properties"`
+	CreatedAt   string                 `json:"createdAt" dynamodbav:"createdAt"`
+	UpdatedAt   string                 `json:"updatedAt" dynamodbav:"updatedAt"`
+}
+
+const (
+	RarityCommon    = "common"
+	RarityUncommon  = "uncommon"
+	RarityRare      = "rare"
+	RarityVeryRare  = "very_rare"
+	RarityLegendary = "legendary"
+	RarityArtifact  = "artifact"
+)
+
+var Rarities = []string{RarityCommon, RarityUncommon, RarityRare, RarityVeryRare, RarityLegendary, RarityArtifact}
+
+// Entry is a stack of an item in the inventory of a character. ContainerId is the
+// entry of the same inventory holding this one, empty for the entries carried
+// directly. Entries inside containers can't be equipped. Item is filled in when the
+// inventory is listed.
+type Entry struct {
+	WorldId     string `json:"worldId" dynamodbav:"worldId"`
+	Id          string `json:"id" dynamodbav:"id"`
+	CharacterId string `json:"characterId" dynamodbav:"characterId"`
+	ItemId      string `json:"itemId" dynamodbav:"itemId"`
+	Quantity    int    `json:"quantity" dynamodbav:"quantity"`
+	Equipped    bool   `json:"equipped" dynamodbav:"equipped"`
+	ContainerId string `json:"containerId,omitempty" dynamodbav:"containerId,omitempty"`
+	Item        *Item  `json:"item,omitempty" dynamodbav:"-"`
+	CreatedAt   string `json:"createdAt" dynamodbav:"createdAt"`
+	UpdatedAt   string `json:"updatedAt" dynamodbav:"updatedAt"`
+}
+
+// Filters selects and orders the items of a listing. Name matches the items whose name
+// contains it, ignoring case, and Rarity the items of a rarity.
+type Filters struct {
+	Name   string
+	Rarity string
+	Sort   string
+}
+
+var SortSafelist = []string{"name", "createdAt", "updatedAt", "-name", "-createdAt", "-updatedAt"}
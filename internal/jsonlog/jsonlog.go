@@ -2,9 +2,11 @@ package jsonlog
 
 import (
 	"encoding/json"
+	"fmt"
 	"io"
 	"os"
 	"runtime/debug"
+	"strings"
 	"sync"
 	"time"
 )
@@ -33,6 +35,21 @@ func (l Level) String() string {
 	}
 }
 
+// ParseLevel returns the level with the given name, like "info" or "error".
+func ParseLevel(name string) (Level, error) {
+	for _, l := range []Level{LevelInfo, LevelError, LevelFatal} {
+		if strings.EqualFold(name, l.String()) {
+			return l, nil
+		}
+	}
+
+	if strings.EqualFold(name, "off") {
+		return LevelOff, nil
+	}
+
+	return LevelOff, fmt.Errorf("unknown log level %q", name)
+}
+
 // Define a custom Logger type. This holds the output destination that the log entries
 // will be written to, the minimum severity level that log entries will be written for,
 // plus a mutex for coordinating the writes.
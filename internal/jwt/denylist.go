@@ -0,0 +1,86 @@
+package jwt
+
+import (
+	"sync"
+	"time"
+)
+
+// Denylist keeps track of revoked tokens until they expire. Tokens are short-lived, so
+// the list stays small. It lives in memory, so it only covers the tokens revoked
+// through this instance of the API.
+type Denylist struct {
+	mu     sync.Mutex
+	tokens map[string]time.Time
+	users  map[string]revocation
+}
+
+// revocation revokes every token of a user issued up to a point in time.
+type revocation struct {
+	before time.Time
+	until  time.Time
+}
+
+func NewDenylist() *Denylist {
+	return &Denylist{
+		tokens: make(map[string]time.Time),
+		users:  make(map[string]revocation),
+	}
+}
+
+// Revoke denies a single token until it expires.
+func (d *Denylist) Revoke(claims *Claims) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.prune()
+	d.tokens[claims.ID] = time.Unix(claims.ExpiresAt, 0)
+}
+
+// RevokeAll denies every token of a user issued up to now. Tokens record the
+// nanosecond they were issued at, so the ones issued right after, like the ones a user
+// gets by logging in again right away, stay valid. Tokens recording only the second
+// are denied when issued in the same second. Tokens live at most ttl, so the entry can
+// be dropped after that.
+func (d *Denylist) RevokeAll(email string, ttl time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.prune()
+
+	now := time.Now()
+	d.users[email] = revocation{before: now, until: now.Add(ttl)}
+}
+
+// Denied reports whether the token with the given claims has been revoked.
+func (d *Denylist) Denied(claims *Claims) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, found := d.tokens[claims.ID]; found {
+		return true
+	}
+
+	if r, found := d.users[claims.Subject]; found && !claims.issuedAt().After(r.before) {
+		return true
+	}
+
+	return false
+}
+
+// prune drops the entries of tokens that have expired anyway. It must be called with
+// the mutex held.
+func (d *Denylist) prune() {
+	now := time.Now()
+
+	for id, expiry := range d.tokens {
+		if now.After(expiry) {
+			delete(d.tokens, id)
+		}
+	}
+
+	for email, r := range d.users {
+		if now.After(r.until) {
+			delete(d.users, email)
+		}
+	}
+}
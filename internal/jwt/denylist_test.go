@@ -0,0 +1,86 @@
+package jwt
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDenylistRevoke(t *testing.T) {
+	d := NewDenylist()
+
+	revoked := validClaims()
+	other := validClaims()
+	other.ID = "other"
+
+	d.Revoke(&revoked)
+
+	if !d.Denied(&revoked) {
+		t.Errorf("Denied() = false for a revoked token")
+	}
+	if d.Denied(&other) {
+		t.Errorf("Denied() = true for another token of the user")
+	}
+}
+
+func TestDenylistRevokeAll(t *testing.T) {
+	d := NewDenylist()
+	d.RevokeAll("alice@example.com", time.Hour)
+	before := d.users["alice@example.com"].before
+
+	claims := func(subject string, issued time.Time, nano bool) *Claims {
+		c := &Claims{ID: "id", Subject: subject, IssuedAt: issued.Unix()}
+		if nano {
+			c.IssuedAtNano = issued.UnixNano()
+		}
+		return c
+	}
+
+	second := before.Truncate(time.Second)
+
+	tests := []struct {
+		name   string
+		claims *Claims
+		denied bool
+	}{
+		{"issued long before", claims("alice@example.com", before.Add(-time.Minute), true), true},
+		{"issued a nanosecond before", claims("alice@example.com", before.Add(-time.Nanosecond), true), true},
+		{"issued at the revocation", claims("alice@example.com", before, true), true},
+		{"issued a nanosecond after", claims("alice@example.com", before.Add(time.Nanosecond), true), false},
+		{"issued long after", claims("alice@example.com", before.Add(time.Minute), true), false},
+		{"seconds only, same second", claims("alice@example.com", second, false), true},
+		{"seconds only, next second", claims("alice@example.com", second.Add(time.Second), false), false},
+		{"another user", claims("bob@example.com", before.Add(-time.Minute), true), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := d.Denied(tt.claims); got != tt.denied {
+				t.Errorf("Denied() = %v, want %v", got, tt.denied)
+			}
+		})
+	}
+}
+
+func TestDenylistPrune(t *testing.T) {
+	d := NewDenylist()
+
+	expired := validClaims()
+	expired.ExpiresAt = time.Now().Add(-time.Minute).Unix()
+	d.Revoke(&expired)
+	d.RevokeAll("alice@example.com", -time.Minute)
+
+	// Pruning happens on the next revocation.
+	live := validClaims()
+	live.ID = "live"
+	d.Revoke(&live)
+
+	if _, found := d.tokens[expired.ID]; found {
+		t.Errorf("Revoke() kept an expired token")
+	}
+	if _, found := d.users["alice@example.com"]; found {
+		t.Errorf("Revoke() kept a lapsed revocation")
+	}
+	if !d.Denied(&live) {
+		t.Errorf("Denied() = false for a revoked token")
+	}
+}
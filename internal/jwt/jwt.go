@@ -0,0 +1,194 @@
+package jwt
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	ErrorInvalidToken = errors.New("invalid token")
+	ErrorExpiredToken = errors.New("expired token")
+)
+
+// Claims holds everything the API needs to authenticate a request without touching
+// the database. IssuedAtNano records the issue time in nanoseconds, so revoking every
+// token of a user can tell apart the tokens issued in the same second.
+type Claims struct {
+	ID           string   `json:"jti"`
+	Issuer       string   `json:"iss"`
+	Subject      string   `json:"sub"`
+	IssuedAt     int64    `json:"iat"`
+	IssuedAtNano int64    `json:"iat_ns,omitempty"`
+	NotBefore    int64    `json:"nbf,omitempty"`
+	ExpiresAt    int64    `json:"exp"`
+	Name         string   `json:"name"`
+	Activated    bool     `json:"activated"`
+	Permissions  []string `json:"permissions"`
+	Family       string   `json:"fam,omitempty"`
+}
+
+// Key is an HMAC secret identified by the "kid" header of the tokens it signs.
+type Key struct {
+	Id     string
+	Secret []byte
+}
+
+type header struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ"`
+	KeyId     string `json:"kid"`
+}
+
+// Signer signs tokens with HS256. Tokens are always signed with the first key, while
+// any of the keys can verify them. To rotate keys, put the new key first and keep the
+// old one around until the tokens it signed have expired.
+type Signer struct {
+	keys   []Key
+	issuer string
+}
+
+func NewSigner(issuer string, keys ...Key) (*Signer, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("jwt: at least one signing key is required")
+	}
+
+	for _, key := range keys {
+		if key.Id == "" || len(key.Secret) < 32 {
+			return nil, fmt.Errorf("jwt: key %q must have an id and a secret of at least 32 bytes", key.Id)
+		}
+	}
+
+	return &Signer{
+		keys:   keys,
+		issuer: issuer,
+	}, nil
+}
+
+// ParseKeys reads keys in the "kid:secret kid:secret" format.
+func ParseKeys(spec string) ([]Key, error) {
+	var keys []Key
+
+	for _, field := range strings.Fields(spec) {
+		id, secret, found := strings.Cut(field, ":")
+		if !found {
+			return nil, fmt.Errorf("jwt: key %q must be in the kid:secret format", field)
+		}
+
+		keys = append(keys, Key{Id: id, Secret: []byte(secret)})
+	}
+
+	return keys, nil
+}
+
+// Sign fills the issuer of the claims and returns the signed token.
+func (s *Signer) Sign(claims Claims) (string, error) {
+	key := s.keys[0]
+	claims.Issuer = s.issuer
+
+	h, err := json.Marshal(header{Algorithm: "HS256", Type: "JWT", KeyId: key.Id})
+	if err != nil {
+		return "", err
+	}
+
+	c, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	unsigned := encode(h) + "." + encode(c)
+	return unsigned + "." + encode(sign(key.Secret, unsigned)), nil
+}
+
+// Verify checks the signature, the issuer and the validity period of a token and
+// returns its claims.
+func (s *Signer) Verify(token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrorInvalidToken
+	}
+
+	var h header
+	err := decode(parts[0], &h)
+	if err != nil || h.Algorithm != "HS256" {
+		return nil, ErrorInvalidToken
+	}
+
+	key, ok := s.key(h.KeyId)
+	if !ok {
+		return nil, ErrorInvalidToken
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrorInvalidToken
+	}
+
+	// Use hmac.Equal() to compare the signatures in constant time.
+	if !hmac.Equal(signature, sign(key.Secret, parts[0]+"."+parts[1])) {
+		return nil, ErrorInvalidToken
+	}
+
+	var claims Claims
+	err = decode(parts[1], &claims)
+	if err != nil || claims.Issuer != s.issuer {
+		return nil, ErrorInvalidToken
+	}
+
+	now := time.Now().Unix()
+	if now < claims.NotBefore {
+		return nil, ErrorInvalidToken
+	}
+	if now >= claims.ExpiresAt {
+		return nil, ErrorExpiredToken
+	}
+
+	return &claims, nil
+}
+
+// issuedAt returns the time the token was issued at, as precisely as the claims
+// record it.
+func (c *Claims) issuedAt() time.Time {
+	if c.IssuedAtNano != 0 {
+		return time.Unix(0, c.IssuedAtNano)
+	}
+	return time.Unix(c.IssuedAt, 0)
+}
+
+// IsJWT tells JWTs apart from opaque tokens, which never contain dots.
+func IsJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
+func (s *Signer) key(id string) (Key, bool) {
+	for _, key := range s.keys {
+		if key.Id == id {
+			return key, true
+		}
+	}
+	return Key{}, false
+}
+
+func sign(secret []byte, unsigned string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(unsigned))
+	return mac.Sum(nil)
+}
+
+func encode(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decode(segment string, dst interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, dst)
+}
@@ -0,0 +1,188 @@
+package jwt
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	testKey   = Key{Id: "k1", Secret: []byte("0123456789abcdef0123456789abcdef")}
+	rotateKey = Key{Id: "k2", Secret: []byte("fedcba9876543210fedcba9876543210")}
+)
+
+func newTestSigner(t *testing.T, issuer string, keys ...Key) *Signer {
+	s, err := NewSigner(issuer, keys...)
+	if err != nil {
+		t.Fatalf("NewSigner() returned error: %v", err)
+	}
+	return s
+}
+
+func validClaims() Claims {
+	now := time.Now()
+	return Claims{
+		ID:           "id",
+		Subject:      "alice@example.com",
+		IssuedAt:     now.Unix(),
+		IssuedAtNano: now.UnixNano(),
+		NotBefore:    now.Unix(),
+		ExpiresAt:    now.Add(time.Hour).Unix(),
+	}
+}
+
+// forge signs a token with the given header, claims and key, bypassing the Signer.
+func forge(t *testing.T, h header, claims Claims, key Key) string {
+	hb, err := json.Marshal(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cb, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unsigned := encode(hb) + "." + encode(cb)
+	return unsigned + "." + encode(sign(key.Secret, unsigned))
+}
+
+func TestVerify(t *testing.T) {
+	signer := newTestSigner(t, "rpg-vault", testKey)
+
+	issued := func(modify func(c *Claims)) string {
+		c := validClaims()
+		c.Issuer = "rpg-vault"
+		modify(&c)
+		return forge(t, header{Algorithm: "HS256", Type: "JWT", KeyId: testKey.Id}, c, testKey)
+	}
+
+	valid, err := signer.Sign(validClaims())
+	if err != nil {
+		t.Fatalf("Sign() returned error: %v", err)
+	}
+	parts := strings.Split(valid, ".")
+
+	tampered := validClaims()
+	tampered.Issuer = "rpg-vault"
+	tampered.Subject = "mallory@example.com"
+	tamperedPayload, _ := json.Marshal(tampered)
+
+	otherSignature := newTestSigner(t, "rpg-vault", Key{Id: testKey.Id, Secret: rotateKey.Secret})
+	badlySigned, _ := otherSignature.Sign(validClaims())
+
+	tests := []struct {
+		name  string
+		token string
+		err   error
+	}{
+		{"valid", valid, nil},
+		{"tampered signature", parts[0] + "." + parts[1] + "." + encode([]byte("not the signature")), ErrorInvalidToken},
+		{"signature of another secret", badlySigned, ErrorInvalidToken},
+		{"tampered payload", parts[0] + "." + encode(tamperedPayload) + "." + parts[2], ErrorInvalidToken},
+		{"missing signature", parts[0] + "." + parts[1] + ".", ErrorInvalidToken},
+		{"signature not base64", parts[0] + "." + parts[1] + ".!!", ErrorInvalidToken},
+		{"too few parts", parts[0] + "." + parts[1], ErrorInvalidToken},
+		{"header not json", encode([]byte("{")) + "." + parts[1] + "." + parts[2], ErrorInvalidToken},
+		{"alg none", forge(t, header{Algorithm: "none", Type: "JWT", KeyId: testKey.Id}, validClaims(), testKey), ErrorInvalidToken},
+		{"alg none unsigned", encode([]byte(`{"alg":"none","kid":"k1"}`)) + "." + parts[1] + ".", ErrorInvalidToken},
+		{"alg HS512", forge(t, header{Algorithm: "HS512", Type: "JWT", KeyId: testKey.Id}, validClaims(), testKey), ErrorInvalidToken},
+		{"alg RS256", forge(t, header{Algorithm: "RS256", Type: "JWT", KeyId: testKey.Id}, validClaims(), testKey), ErrorInvalidToken},
+		{"unknown kid", forge(t, header{Algorithm: "HS256", Type: "JWT", KeyId: "k9"}, validClaims(), testKey), ErrorInvalidToken},
+		{"missing kid", forge(t, header{Algorithm: "HS256", Type: "JWT"}, validClaims(), testKey), ErrorInvalidToken},
+		{"wrong issuer", issued(func(c *Claims) { c.Issuer = "someone-else" }), ErrorInvalidToken},
+		{"missing issuer", issued(func(c *Claims) { c.Issuer = "" }), ErrorInvalidToken},
+		{"expired", issued(func(c *Claims) { c.ExpiresAt = time.Now().Add(-time.Minute).Unix() }), ErrorExpiredToken},
+		{"expiring now", issued(func(c *Claims) { c.ExpiresAt = time.Now().Unix() }), ErrorExpiredToken},
+		{"not yet valid", issued(func(c *Claims) { c.NotBefore = time.Now().Add(time.Minute).Unix() }), ErrorInvalidToken},
+		{"no not before", issued(func(c *Claims) { c.NotBefore = 0 }), nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := signer.Verify(tt.token)
+			if err != tt.err {
+				t.Fatalf("Verify() error = %v, want %v", err, tt.err)
+			}
+
+			if tt.err == nil && (claims.Subject != "alice@example.com" || claims.Issuer != "rpg-vault") {
+				t.Errorf("Verify() claims = %+v, want the signed ones", claims)
+			}
+		})
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	old := newTestSigner(t, "rpg-vault", testKey)
+	rotated := newTestSigner(t, "rpg-vault", rotateKey, testKey)
+	retired := newTestSigner(t, "rpg-vault", rotateKey)
+
+	oldToken, err := old.Sign(validClaims())
+	if err != nil {
+		t.Fatalf("Sign() returned error: %v", err)
+	}
+	newToken, err := rotated.Sign(validClaims())
+	if err != nil {
+		t.Fatalf("Sign() returned error: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		signer *Signer
+		token  string
+		err    error
+	}{
+		{"old key kept", rotated, oldToken, nil},
+		{"new key", rotated, newToken, nil},
+		{"new key on old signer", old, newToken, ErrorInvalidToken},
+		{"old key retired", retired, oldToken, ErrorInvalidToken},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.signer.Verify(tt.token)
+			if err != tt.err {
+				t.Errorf("Verify() error = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestNewSigner(t *testing.T) {
+	tests := []struct {
+		name  string
+		keys  []Key
+		valid bool
+	}{
+		{"one key", []Key{testKey}, true},
+		{"several keys", []Key{testKey, rotateKey}, true},
+		{"no keys", nil, false},
+		{"missing id", []Key{{Secret: testKey.Secret}}, false},
+		{"short secret", []Key{{Id: "k1", Secret: []byte("short")}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewSigner("rpg-vault", tt.keys...)
+			if (err == nil) != tt.valid {
+				t.Errorf("NewSigner() error = %v, want valid %v", err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	keys, err := ParseKeys(" k1:secret-one  k2:secret:two ")
+	if err != nil {
+		t.Fatalf("ParseKeys() returned error: %v", err)
+	}
+
+	if len(keys) != 2 || keys[0].Id != "k1" || string(keys[0].Secret) != "secret-one" || keys[1].Id != "k2" || string(keys[1].Secret) != "secret:two" {
+		t.Errorf("ParseKeys() = %+v, want k1 and k2", keys)
+	}
+
+	_, err = ParseKeys("k1:secret nosecret")
+	if err == nil {
+		t.Errorf("ParseKeys() succeeded for a key without a secret")
+	}
+}
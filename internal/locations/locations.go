@@ -0,0 +1,341 @@
+package locations
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	common "github.com/jplindgren/rpg-vault/internal"
+	"github.com/jplindgren/rpg-vault/internal/storage"
+	"github.com/jplindgren/rpg-vault/internal/uploader"
+	"github.com/jplindgren/rpg-vault/internal/validator"
+)
+
+//const locationTableName = "rpg_locations"
+
+// The files of a location are kept under the files of its world, so they are deleted
+// along with it.
+const (
+	filesPrefix         = "%s/locations/%s/"
+	mapImageDestination = "%s/locations/%s/map.png"
+)
+
+// MaxDepth bounds how deep locations can be nested, which also bounds the reads made to
+// walk up the tree.
+const MaxDepth = 16
+
+type LocationKey struct {
+	WorldId string `dynamodbav:"worldId"`
+	Id      string `dynamodbav:"id"`
+}
+
+type LocationService struct {
+	db        storage.Store
+	blobs     uploader.BlobStore
+	tableName string
+}
+
+func New(db storage.Store, blobs uploader.BlobStore, tableName string) *LocationService {
+	return &LocationService{
+		db:        db,
+		blobs:     blobs,
+		tableName: tableName,
+	}
+}
+
+// Insert adds a location, uploading its map when MapImage holds a base64 image.
+func (ls *LocationService) Insert(location *Location) error {
+	location.Id = common.GenerateToken()
+
+	mapUrl, err := uploader.UploadCoverImage(ls.blobs,
+		location.MapImage,
+		fmt.Sprintf(mapImageDestination, location.WorldId, location.Id),
+	)
+	if err != nil {
+		return err
+	}
+
+	location.MapImage = mapUrl
+	location.CreatedAt = common.GetIsoString()
+	location.UpdatedAt = location.CreatedAt
+	location.NameLower = strings.ToLower(location.Name)
+	if location.Pins == nil {
+		location.Pins = []Pin{}
+	}
+
+	return ls.db.Put(ls.tableName, location, nil)
+}
+
+func (ls *LocationService) Get(worldId, id string) (*Location, error) {
+	key := LocationKey{
+		WorldId: worldId,
+		Id:      id,
+	}
+
+	var result Location
+	err := ls.db.Get(ls.tableName, key, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	if result.Pins == nil {
+		result.Pins = []Pin{}
+	}
+
+	return &result, nil
+}
+
+// Path returns the location and the locations above it, from the top level down to
+// it. Trees deeper than MaxDepth, which can only be left by a broken parent link, are
+// an error.
+func (ls *LocationService) Path(worldId, id string) ([]Location, error) {
+	var path []Location
+
+	for id != "" {
+		if len(path) == MaxDepth {
+			return nil, fmt.Errorf("location %s is nested more than %d levels deep", id, MaxDepth)
+		}
+
+		location, err := ls.Get(worldId, id)
+		if err != nil {
+			return nil, err
+		}
+
+		path = append([]Location{*location}, path...)
+		id = location.ParentId
+	}
+
+	return path, nil
+}
+
+// List returns a page of the locations of a world matching the filters, starting after
+// the start key, along with the key of the next page, or nil on the last one. The
+// locations are read from the index of the sort attribute, already sorted.
+func (ls *LocationService) List(worldId string, filters Filters, limit int, start storage.Item) (*[]Location, storage.Item, error) {
+	query := &storage.Query{
+		Index:      common.SortColumn(filters.Sort) + "-index",
+		Key:        "worldId",
+		Value:      worldId,
+		Filter:     filters.condition(),
+		Descending: common.SortDescending(filters.Sort),
+		Limit:      limit,
+		StartKey:   start,
+	}
+
+	var resultArr []Location
+	next, err := ls.db.QueryPage(ls.tableName, query, &resultArr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for i := range resultArr {
+		if resultArr[i].Pins == nil {
+			resultArr[i].Pins = []Pin{}
+		}
+	}
+
+	return &resultArr, next, nil
+}
+
+// condition returns the condition a location must meet to match the filters, or nil
+// when every location does.
+func (f Filters) condition() *storage.Condition {
+	var conds []*storage.Condition
+
+	if f.Name != "" {
+		conds = append(conds, storage.ContainsFold("name", "nameLower", f.Name))
+	}
+
+	switch {
+	case f.ParentId == ParentRoot:
+		conds = append(conds, storage.AttributeNotExists("parentId"))
+	case f.ParentId != "":
+		conds = append(conds, storage.Equal("parentId", f.ParentId))
+	}
+
+	if len(conds) == 0 {
+		return nil
+	}
+
+	return storage.And(conds...)
+}
+
+// Update saves a location. When mapUpdated is true MapImage holds a new base64 image to
+// upload, and when it is empty the map is removed. It fails with
+// common.ErrorRecordNotFound when the location was deleted meanwhile, rather than
+// recreating it.
+func (ls *LocationService) Update(location *Location, mapUpdated bool) error {
+	key := LocationKey{
+		WorldId: location.WorldId,
+		Id:      location.Id,
+	}
+
+	destination := fmt.Sprintf(mapImageDestination, location.WorldId, location.Id)
+
+	switch {
+	case mapUpdated:
+		mapUrl, err := uploader.UploadCoverImage(ls.blobs, location.MapImage, destination)
+		if err != nil {
+			return err
+		}
+		location.MapImage = mapUrl
+	case location.MapImage == "":
+		err := ls.blobs.Delete(destination)
+		if err != nil {
+			return err
+		}
+	}
+
+	location.UpdatedAt = common.GetIsoString()
+
+	update := storage.Set("name", location.Name).
+		Set("nameLower", strings.ToLower(location.Name)).
+		Set("description", location.Description).
+		Set("mapImage", location.MapImage).
+		Set("pins", location.Pins).
+		Set("updatedAt", location.UpdatedAt).
+		If(storage.AttributeExists("id"))
+
+	if location.ParentId != "" {
+		update = update.Set("parentId", location.ParentId)
+	} else {
+		update = update.Remove("parentId")
+	}
+
+	err := ls.db.Update(ls.tableName, key, update)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrorConditionFailed):
+			return common.ErrorRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Height returns how many levels the location and its sub-locations span, 1 for a
+// location without sub-locations. The whole world is read at once, so that is a single
+// query however deep the tree is.
+func (ls *LocationService) Height(worldId, id string) (int, error) {
+	var all []Location
+	err := ls.db.Query(ls.tableName, &storage.Query{Key: "worldId", Value: worldId}, &all)
+	if err != nil {
+		return 0, err
+	}
+
+	children := make(map[string][]string)
+	for _, location := range all {
+		children[location.ParentId] = append(children[location.ParentId], location.Id)
+	}
+
+	height := 0
+	seen := map[string]bool{id: true}
+	level := []string{id}
+	for len(level) > 0 {
+		height++
+
+		var next []string
+		for _, current := range level {
+			for _, child := range children[current] {
+				if !seen[child] {
+					seen[child] = true
+					next = append(next, child)
+				}
+			}
+		}
+		level = next
+	}
+
+	return height, nil
+}
+
+// Delete removes a location along with its sub-locations, their maps, and the pins of
+// the remaining locations linking any of them.
+func (ls *LocationService) Delete(worldId, id string) error {
+	var all []Location
+	err := ls.db.Query(ls.tableName, &storage.Query{Key: "worldId", Value: worldId}, &all)
+	if err != nil {
+		return err
+	}
+
+	children := make(map[string][]string)
+	for _, location := range all {
+		children[location.ParentId] = append(children[location.ParentId], location.Id)
+	}
+
+	deleted := map[string]bool{id: true}
+	pending := []string{id}
+	for len(pending) > 0 {
+		current := pending[0]
+		pending = pending[1:]
+
+		for _, child := range children[current] {
+			if !deleted[child] {
+				deleted[child] = true
+				pending = append(pending, child)
+			}
+		}
+	}
+
+	var keys []map[string]string
+	for locationId := range deleted {
+		keys = append(keys, map[string]string{"worldId": worldId, "id": locationId})
+
+		err = ls.blobs.DeletePrefix(fmt.Sprintf(filesPrefix, worldId, locationId))
+		if err != nil {
+			return err
+		}
+	}
+
+	err = ls.db.BatchDelete(ls.tableName, keys)
+	if err != nil {
+		return err
+	}
+
+	for i := range all {
+		location := &all[i]
+		if deleted[location.Id] {
+			continue
+		}
+
+		pins := []Pin{}
+		for _, pin := range location.Pins {
+			if !deleted[pin.LocationId] {
+				pins = append(pins, pin)
+			}
+		}
+
+		if len(pins) != len(location.Pins) {
+			err = ls.db.Update(ls.tableName, LocationKey{WorldId: worldId, Id: location.Id}, storage.Set("pins", pins))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func ValidateLocation(v *validator.Validator, location *Location) {
+	v.Check(location.Name != "", "name", "must be provided")
+	v.Check(len(location.Name) <= 200, "name", "must not be more than 200 characters long")
+	v.Check(len(location.Description) <= 10000, "description", "must not be more than 10000 characters long")
+
+	v.Check(len(location.Pins) == 0 || location.MapImage != "", "pins", "can only be placed on a location with a map")
+	v.Check(len(location.Pins) <= 200, "pins", "must not contain more than 200 pins")
+
+	for i, pin := range location.Pins {
+		key := fmt.Sprintf("pins[%d]", i)
+		v.Check(pin.X >= 0 && pin.X <= 1 && pin.Y >= 0 && pin.Y <= 1, key, "x and y must be between 0 and 1")
+		v.Check(len(pin.Label) <= 200, key, "label must not be more than 200 characters long")
+		v.Check((pin.CharacterId == "") != (pin.LocationId == ""), key, "must link either a character or a location")
+		v.Check(pin.LocationId == "" || pin.LocationId != location.Id, key, "must not link the location itself")
+	}
+}
+
+func ValidateFilters(v *validator.Validator, f Filters) {
+	v.Check(validator.PermittedValue(f.Sort, SortSafelist...), "sort", "invalid sort value")
+	v.Check(len(f.Name) <= 200, "name", "must not be more than 200 characters long")
+}
@@ -0,0 +1,73 @@
+package locations
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	common "github.com/jplindgren/rpg-vault/internal"
+	"github.com/jplindgren/rpg-vault/internal/storage"
+)
+
+func TestPathAndHeight(t *testing.T) {
+	table := storage.Table{Name: "locations", PartitionKey: "worldId", SortKey: "id"}
+	ls := New(storage.NewMemory(table), nil, table.Name)
+
+	insert := func(name, parentId string) string {
+		location := &Location{WorldId: "w1", Name: name, ParentId: parentId}
+		err := ls.Insert(location)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return location.Id
+	}
+
+	// Khorvaire > Breland > Sharn > Broken Anvil, and Khorvaire > Aundair.
+	khorvaire := insert("Khorvaire", "")
+	breland := insert("Breland", khorvaire)
+	sharn := insert("Sharn", breland)
+	anvil := insert("Broken Anvil", sharn)
+	aundair := insert("Aundair", khorvaire)
+
+	tests := []struct {
+		name       string
+		id         string
+		wantPath   []string
+		wantHeight int
+	}{
+		{"top level", khorvaire, []string{"Khorvaire"}, 4},
+		{"middle", sharn, []string{"Khorvaire", "Breland", "Sharn"}, 2},
+		{"leaf", anvil, []string{"Khorvaire", "Breland", "Sharn", "Broken Anvil"}, 1},
+		{"sibling", aundair, []string{"Khorvaire", "Aundair"}, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, err := ls.Path("w1", tt.id)
+			if err != nil {
+				t.Fatalf("Path() returned error: %v", err)
+			}
+
+			var names []string
+			for _, location := range path {
+				names = append(names, location.Name)
+			}
+			if !reflect.DeepEqual(names, tt.wantPath) {
+				t.Errorf("Path() = %v, want %v", names, tt.wantPath)
+			}
+
+			height, err := ls.Height("w1", tt.id)
+			if err != nil {
+				t.Fatalf("Height() returned error: %v", err)
+			}
+			if height != tt.wantHeight {
+				t.Errorf("Height() = %d, want %d", height, tt.wantHeight)
+			}
+		})
+	}
+
+	_, err := ls.Path("w1", "missing")
+	if !errors.Is(err, common.ErrorRecordNotFound) {
+		t.Errorf("Path(missing) error = %v, want %v", err, common.ErrorRecordNotFound)
+	}
+}
@@ -0,0 +1,41 @@
+package locations
+
+// Location is a place of a world, like a continent, a city or a tavern. Locations form
+// a tree: ParentId is the location this one is part of, empty for the top level ones.
+// MapImage is the URL of the map of the location, which Pins are placed on.
+type Location struct {
+	WorldId     string `json:"worldId" dynamodbav:"worldId"`
+	Id          string `json:"id" dynamodbav:"id"`
+	ParentId    string `json:"parentId,omitempty" dynamodbav:"parentId,omitempty"`
+	Name        string `json:"name" dynamodbav:"name"`
+	NameLower   string `json:"-" dynamodbav:"nameLower,omitempty"`
+	Description string `json:"description" dynamodbav:"description"`
+	MapImage    string `json:"mapImage" dynamodbav:"mapImage"`
+	Pins        []Pin  `json:"pins" dynamodbav:"pins"`
+	CreatedAt   string `json:"createdAt" dynamodbav:"createdAt"`
+	UpdatedAt   string `json:"updatedAt" dynamodbav:"updatedAt"`
+}
+
+// Pin marks a point of the map of a location, linking either a character or one of the
+// sub-locations of the location. X and Y are relative to the size of the map, from 0
+// to 1, starting at its top left corner, so they don't depend on its resolution.
+type Pin struct {
+	X           float64 `json:"x" dynamodbav:"x"`
+	Y           float64 `json:"y" dynamodbav:"y"`
+	Label       string  `json:"label,omitempty" dynamodbav:"label,omitempty"`
+	CharacterId string  `json:"characterId,omitempty" dynamodbav:"characterId,omitempty"`
+	LocationId  string  `json:"locationId,omitempty" dynamodbav:"locationId,omitempty"`
+}
+
+// Filters selects and orders the locations of a listing. Name matches the locations
+// whose name contains it, ignoring case, and ParentId the sub-locations of a location,
+// or the top level locations when it is ParentRoot.
+type Filters struct {
+	Name     string
+	ParentId string
+	Sort     string
+}
+
+const ParentRoot = "root"
+
+var SortSafelist = []string{"name", "createdAt", "updatedAt", "-name", "-createdAt", "-updatedAt"}
@@ -0,0 +1,72 @@
+package mailer
+
+import (
+	"bytes"
+	"embed"
+	"html/template"
+	"strings"
+	tt "text/template"
+)
+
+// Below we declare a new variable with the type embed.FS (embedded file system) to hold
+// our email templates. This has a comment directive in the format `//go:embed <path>`
+// IMMEDIATELY ABOVE it, which indicates to Go that we want to store the contents of the
+// ./templates directory in the templateFS embedded file system variable.
+//
+//go:embed "templates"
+var templateFS embed.FS
+
+// Mailer sends templated emails. Each template file in ./templates defines a
+// "subject", a "plainBody" and an "htmlBody" template, executed with the given data.
+type Mailer interface {
+	Send(recipient, templateFile string, data interface{}) error
+}
+
+// Message holds a rendered email, ready to be delivered.
+type Message struct {
+	To        string
+	Subject   string
+	PlainBody string
+	HTMLBody  string
+}
+
+// render executes the templates of a template file with the given dynamic data.
+func render(recipient, templateFile string, data interface{}) (*Message, error) {
+	// Use the ParseFS() method to parse the required template file from the embedded
+	// file system. The plain text templates use text/template, so the plain body isn't
+	// HTML-escaped.
+	plainTmpl, err := tt.New("email").ParseFS(templateFS, "templates/"+templateFile)
+	if err != nil {
+		return nil, err
+	}
+
+	subject := new(bytes.Buffer)
+	err = plainTmpl.ExecuteTemplate(subject, "subject", data)
+	if err != nil {
+		return nil, err
+	}
+
+	plainBody := new(bytes.Buffer)
+	err = plainTmpl.ExecuteTemplate(plainBody, "plainBody", data)
+	if err != nil {
+		return nil, err
+	}
+
+	htmlTmpl, err := template.New("email").ParseFS(templateFS, "templates/"+templateFile)
+	if err != nil {
+		return nil, err
+	}
+
+	htmlBody := new(bytes.Buffer)
+	err = htmlTmpl.ExecuteTemplate(htmlBody, "htmlBody", data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Message{
+		To:        recipient,
+		Subject:   strings.TrimSpace(subject.String()),
+		PlainBody: strings.TrimSpace(plainBody.String()),
+		HTMLBody:  strings.TrimSpace(htmlBody.String()),
+	}, nil
+}
@@ -0,0 +1,60 @@
+package mailer
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SinkMailer doesn't deliver anything. It writes the rendered emails either to an
+// io.Writer (usually the application log) or to one file per email inside a
+// directory, which is handy for local development and tests.
+type SinkMailer struct {
+	out io.Writer
+	dir string
+	mu  sync.Mutex
+}
+
+// NewLogSink returns a SinkMailer writing every email to out.
+func NewLogSink(out io.Writer) *SinkMailer {
+	return &SinkMailer{out: out}
+}
+
+// NewFileSink returns a SinkMailer writing every email to its own file inside dir.
+func NewFileSink(dir string) (*SinkMailer, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SinkMailer{dir: dir}, nil
+}
+
+func (m *SinkMailer) Send(recipient, templateFile string, data interface{}) error {
+	msg, err := render(recipient, templateFile, data)
+	if err != nil {
+		return err
+	}
+
+	contents := fmt.Sprintf("To: %s\nSubject: %s\n\n%s\n", msg.To, msg.Subject, msg.PlainBody)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.dir == "" {
+		_, err = io.WriteString(m.out, contents)
+		return err
+	}
+
+	name := fmt.Sprintf("%d-%s-%s.txt",
+		time.Now().UnixNano(),
+		strings.TrimSuffix(templateFile, filepath.Ext(templateFile)),
+		strings.NewReplacer("@", "_at_", "/", "_").Replace(recipient),
+	)
+
+	return os.WriteFile(filepath.Join(m.dir, name), []byte(contents), 0o644)
+}
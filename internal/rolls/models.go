@@ -0,0 +1,18 @@
+package rolls
+
+import "github.com/jplindgren/rpg-vault/internal/dice"
+
+// Roll is an entry of the roll log of a world. Expression is the expression as written
+// by the user, before the mode is applied, and CharacterId the character whose
+// attributes it refers to, if any.
+type Roll struct {
+	WorldId     string       `json:"worldId" dynamodbav:"worldId"`
+	Id          string       `json:"id" dynamodbav:"id"`
+	UserId      string       `json:"userId" dynamodbav:"userId"`
+	CharacterId string       `json:"characterId,omitempty" dynamodbav:"characterId,omitempty"`
+	Expression  string       `json:"expression" dynamodbav:"expression"`
+	Mode        string       `json:"mode,omitempty" dynamodbav:"mode,omitempty"`
+	Reason      string       `json:"reason,omitempty" dynamodbav:"reason,omitempty"`
+	Result      *dice.Result `json:"result" dynamodbav:"result"`
+	RolledAt    string       `json:"rolledAt" dynamodbav:"rolledAt"`
+}
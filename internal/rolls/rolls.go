@@ -0,0 +1,72 @@
+package rolls
+
+import (
+	"time"
+
+	common "github.com/jplindgren/rpg-vault/internal"
+	"github.com/jplindgren/rpg-vault/internal/dice"
+	"github.com/jplindgren/rpg-vault/internal/storage"
+	"github.com/jplindgren/rpg-vault/internal/validator"
+)
+
+//const rollTableName = "rpg_rolls"
+
+// The rolls table is keyed by worldId/id and has a global secondary index keyed by
+// worldId/rolledAt, used to list the log of a world newest first.
+const rolledAtIndex = "rolledAt-index"
+
+// Several rolls are often made within the same second, so they are timestamped to the
+// nanosecond, with a fixed width so they sort in the order they were made.
+const rolledAtFormat = "2006-01-02T15:04:05.000000000Z07:00"
+
+type RollKey struct {
+	WorldId string `dynamodbav:"worldId"`
+	Id      string `dynamodbav:"id"`
+}
+
+type RollService struct {
+	db        storage.Store
+	tableName string
+}
+
+func New(db storage.Store, tableName string) *RollService {
+	return &RollService{
+		db:        db,
+		tableName: tableName,
+	}
+}
+
+// Insert adds a roll to the log of its world. Rolls are never changed afterwards.
+func (rs *RollService) Insert(roll *Roll) error {
+	roll.Id = common.GenerateToken()
+	roll.RolledAt = time.Now().UTC().Format(rolledAtFormat)
+
+	return rs.db.Put(rs.tableName, roll, nil)
+}
+
+// List returns a page of the roll log of a world, newest first, starting after the
+// start key, along with the key of the next page, or nil on the last one.
+func (rs *RollService) List(worldId string, limit int, start storage.Item) (*[]Roll, storage.Item, error) {
+	query := &storage.Query{
+		Index:      rolledAtIndex,
+		Key:        "worldId",
+		Value:      worldId,
+		Descending: true,
+		Limit:      limit,
+		StartKey:   start,
+	}
+
+	var resultArr []Roll
+	next, err := rs.db.QueryPage(rs.tableName, query, &resultArr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &resultArr, next, nil
+}
+
+func ValidateRoll(v *validator.Validator, roll *Roll) {
+	v.Check(roll.Expression != "", "expression", "must be provided")
+	v.Check(validator.PermittedValue(roll.Mode, "", dice.ModeAdvantage, dice.ModeDisadvantage), "mode", "must be advantage or disadvantage")
+	v.Check(len(roll.Reason) <= 200, "reason", "must not be more than 200 characters long")
+}
@@ -0,0 +1,430 @@
+// Package search keeps an inverted index of the worlds and characters in memory, so
+// users can find them by the words in their names, intros and attributes. The index
+// is rebuilt from the storage on startup and kept up to date by the services, which
+// means it is local to each instance of the API: an instance doesn't see the changes
+// made through the others until it rebuilds its index, which the API does
+// periodically when it runs on several instances.
+package search
+
+import (
+	"html"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+const (
+	KindWorld     = "world"
+	KindCharacter = "character"
+)
+
+// Document is an indexed world or character. For worlds, WorldId is their own id and
+// OwnerId the user who created them. Fields maps a field name, like "intro", to its
+// text.
+type Document struct {
+	Kind    string
+	Id      string
+	WorldId string
+	OwnerId string
+	Name    string
+	Fields  map[string]string
+}
+
+// Matches in the name rank higher than matches in the other fields.
+var boosts = map[string]float64{
+	"name": 3,
+}
+
+// Words too common to tell documents apart, which are left out of the queries.
+var stopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true, "be": true,
+	"by": true, "for": true, "from": true, "has": true, "he": true, "in": true, "is": true,
+	"it": true, "its": true, "of": true, "on": true, "or": true, "she": true, "that": true,
+	"the": true, "to": true, "was": true, "were": true, "who": true, "with": true,
+}
+
+type Index struct {
+	mu   sync.RWMutex
+	docs map[string]*entry
+	// parent and pending are set on the indexes returned by Begin, which record the
+	// changes instead of applying them.
+	parent  *Index
+	pending []func(*Index)
+	// postings maps every term to the documents containing it, along with the number
+	// of times it appears in each one, weighted by the boost of the field.
+	postings map[string]map[string]float64
+	// owners maps every world to the user who created it.
+	owners map[string]string
+}
+
+type entry struct {
+	doc   Document
+	terms map[string]float64
+}
+
+func NewIndex() *Index {
+	return &Index{
+		docs:     make(map[string]*entry),
+		postings: make(map[string]map[string]float64),
+		owners:   make(map[string]string),
+	}
+}
+
+// Begin returns an index recording the changes made to it, which are only applied to
+// this one by Commit. Services bound to a transaction use it, so the index doesn't
+// change when the transaction is rolled back.
+func (ix *Index) Begin() *Index {
+	return &Index{parent: ix}
+}
+
+// Commit applies the changes recorded by an index returned by Begin.
+func (ix *Index) Commit() {
+	ix.mu.Lock()
+	pending := ix.pending
+	ix.pending = nil
+	ix.mu.Unlock()
+
+	for _, change := range pending {
+		change(ix.parent)
+	}
+}
+
+// deferred records a change on an index returned by Begin, returning false for the other
+// indexes, which apply their changes right away.
+func (ix *Index) deferred(change func(*Index)) bool {
+	if ix.parent == nil {
+		return false
+	}
+
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	ix.pending = append(ix.pending, change)
+	return true
+}
+
+// Replace swaps the documents of the index for the documents of other, which is how
+// the index is rebuilt without leaving it empty in the meantime. other must not be
+// used afterwards.
+func (ix *Index) Replace(other *Index) {
+	other.mu.RLock()
+	defer other.mu.RUnlock()
+
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	ix.docs = other.docs
+	ix.postings = other.postings
+	ix.owners = other.owners
+}
+
+// Put adds a document to the index, replacing the previous version of it.
+func (ix *Index) Put(doc Document) {
+	if ix.deferred(func(parent *Index) { parent.Put(doc) }) {
+		return
+	}
+
+	terms := make(map[string]float64)
+	for field, text := range doc.Fields {
+		boost := boosts[field]
+		if boost == 0 {
+			boost = 1
+		}
+
+		for _, t := range tokenize(text) {
+			terms[t.term] += boost
+		}
+	}
+
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	key := docKey(doc.Kind, doc.Id)
+	ix.remove(key)
+
+	ix.docs[key] = &entry{doc: doc, terms: terms}
+	for term, weight := range terms {
+		if ix.postings[term] == nil {
+			ix.postings[term] = make(map[string]float64)
+		}
+		ix.postings[term][key] = weight
+	}
+
+	if doc.Kind == KindWorld {
+		ix.owners[doc.Id] = doc.OwnerId
+	}
+}
+
+// Delete removes a document from the index. Deleting a document which isn't indexed
+// does nothing.
+func (ix *Index) Delete(kind, id string) {
+	if ix.deferred(func(parent *Index) { parent.Delete(kind, id) }) {
+		return
+	}
+
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	ix.remove(docKey(kind, id))
+
+	if kind == KindWorld {
+		delete(ix.owners, id)
+	}
+}
+
+func (ix *Index) remove(key string) {
+	e, found := ix.docs[key]
+	if !found {
+		return
+	}
+
+	for term := range e.terms {
+		delete(ix.postings[term], key)
+		if len(ix.postings[term]) == 0 {
+			delete(ix.postings, term)
+		}
+	}
+
+	delete(ix.docs, key)
+}
+
+// Scope restricts the results to what a user can access: the worlds they created, the
+// worlds in Shared, and the characters of those worlds. Characters of worlds which
+// aren't indexed, like the worlds in the trash, are left out. Kinds, when not empty,
+// limits the kinds of documents returned.
+type Scope struct {
+	UserId string
+	Shared map[string]bool
+	Kinds  []string
+}
+
+func (s Scope) allows(ix *Index, doc Document) bool {
+	if len(s.Kinds) > 0 && !containsString(s.Kinds, doc.Kind) {
+		return false
+	}
+
+	owner, found := ix.owners[doc.WorldId]
+	return found && (s.Shared[doc.WorldId] || owner == s.UserId)
+}
+
+type Result struct {
+	Kind    string  `json:"kind"`
+	Id      string  `json:"id"`
+	WorldId string  `json:"worldId"`
+	Name    string  `json:"name"`
+	Score   float64 `json:"score"`
+	// Highlights maps the fields which matched the query to a snippet of their text,
+	// with the matching words wrapped in <mark> tags. The rest of the text is HTML
+	// escaped.
+	Highlights map[string]string `json:"highlights"`
+}
+
+// Search returns the documents in scope matching any of the words in the query, the
+// best matches first. Documents are ranked with BM25 on the weighted term frequencies,
+// and by the share of the query words they contain. Query words also match the longer
+// words they are a prefix of, with a lower score, so "tav" finds "tavern".
+func (ix *Index) Search(query string, scope Scope, limit int) []Result {
+	var words []string
+	for _, t := range tokenize(query) {
+		if !stopWords[t.term] && !containsString(words, t.term) {
+			words = append(words, t.term)
+		}
+	}
+
+	if len(words) == 0 {
+		return []Result{}
+	}
+
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	type match struct {
+		score float64
+		words int
+	}
+	matches := make(map[string]*match)
+	total := float64(len(ix.docs))
+
+	for _, word := range words {
+		seen := make(map[string]bool)
+
+		for term, postings := range ix.postings {
+			weight := 1.0
+			switch {
+			case term == word:
+			case len(word) >= 2 && strings.HasPrefix(term, word):
+				weight = 0.5
+			default:
+				continue
+			}
+
+			idf := math.Log(1 + (total-float64(len(postings))+0.5)/(float64(len(postings))+0.5))
+
+			for key, tf := range postings {
+				if !scope.allows(ix, ix.docs[key].doc) {
+					continue
+				}
+
+				m := matches[key]
+				if m == nil {
+					m = &match{}
+					matches[key] = m
+				}
+
+				const k1 = 1.2
+				m.score += weight * idf * tf * (k1 + 1) / (tf + k1)
+
+				if !seen[key] {
+					seen[key] = true
+					m.words++
+				}
+			}
+		}
+	}
+
+	results := make([]Result, 0, len(matches))
+	for key, m := range matches {
+		doc := ix.docs[key].doc
+
+		results = append(results, Result{
+			Kind:       doc.Kind,
+			Id:         doc.Id,
+			WorldId:    doc.WorldId,
+			Name:       doc.Name,
+			Score:      math.Round(m.score*float64(m.words)/float64(len(words))*1000) / 1000,
+			Highlights: highlights(doc, words),
+		})
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Name < results[j].Name
+	})
+
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+
+	return results
+}
+
+// The snippets hold a few words before the first match and some more after it.
+const (
+	snippetBefore = 6
+	snippetAfter  = 18
+)
+
+func highlights(doc Document, words []string) map[string]string {
+	snippets := make(map[string]string)
+
+	for field, text := range doc.Fields {
+		tokens := tokenize(text)
+
+		first := -1
+		for i, t := range tokens {
+			if matchesAny(t.term, words) {
+				first = i
+				break
+			}
+		}
+
+		if first < 0 {
+			continue
+		}
+
+		from := first - snippetBefore
+		if from < 0 {
+			from = 0
+		}
+		to := first + snippetAfter
+		if to > len(tokens) {
+			to = len(tokens)
+		}
+
+		var b strings.Builder
+		if from > 0 {
+			b.WriteString("…")
+		}
+
+		// The snippets start and end on a word, unless they hold the whole text.
+		pos := tokens[from].start
+		if from == 0 {
+			pos = 0
+		}
+		for _, t := range tokens[from:to] {
+			b.WriteString(html.EscapeString(text[pos:t.start]))
+			if matchesAny(t.term, words) {
+				b.WriteString("<mark>" + html.EscapeString(text[t.start:t.end]) + "</mark>")
+			} else {
+				b.WriteString(html.EscapeString(text[t.start:t.end]))
+			}
+			pos = t.end
+		}
+
+		if to < len(tokens) {
+			b.WriteString("…")
+		} else {
+			b.WriteString(html.EscapeString(text[pos:]))
+		}
+
+		snippets[field] = b.String()
+	}
+
+	return snippets
+}
+
+func matchesAny(term string, words []string) bool {
+	for _, word := range words {
+		if term == word || (len(word) >= 2 && strings.HasPrefix(term, word)) {
+			return true
+		}
+	}
+	return false
+}
+
+type token struct {
+	term       string
+	start, end int
+}
+
+// tokenize splits a text into lowercase words made of letters and digits, keeping
+// their position in the text for the snippets.
+func tokenize(text string) []token {
+	var tokens []token
+
+	start := -1
+	for i, r := range text {
+		wordRune := unicode.IsLetter(r) || unicode.IsDigit(r)
+
+		switch {
+		case wordRune && start < 0:
+			start = i
+		case !wordRune && start >= 0:
+			tokens = append(tokens, token{term: strings.ToLower(text[start:i]), start: start, end: i})
+			start = -1
+		}
+	}
+
+	if start >= 0 {
+		tokens = append(tokens, token{term: strings.ToLower(text[start:]), start: start, end: len(text)})
+	}
+
+	return tokens
+}
+
+func docKey(kind, id string) string {
+	return kind + ":" + id
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,123 @@
+package search
+
+import (
+	"reflect"
+	"testing"
+)
+
+func newTestIndex() *Index {
+	ix := NewIndex()
+	ix.Put(Document{Kind: KindWorld, Id: "eberron", WorldId: "eberron", OwnerId: "alice", Name: "Eberron", Fields: map[string]string{"name": "Eberron"}})
+	ix.Put(Document{Kind: KindWorld, Id: "faerun", WorldId: "faerun", OwnerId: "bob", Name: "Faerun", Fields: map[string]string{"name": "Faerun"}})
+	ix.Put(Document{Kind: KindCharacter, Id: "lei", WorldId: "eberron", OwnerId: "carol", Name: "Lei", Fields: map[string]string{"name": "Lei"}})
+	ix.Put(Document{Kind: KindCharacter, Id: "drizzt", WorldId: "faerun", OwnerId: "alice", Name: "Drizzt", Fields: map[string]string{"name": "Drizzt"}})
+	// The world of this character isn't indexed, as if it was in the trash.
+	ix.Put(Document{Kind: KindCharacter, Id: "vi", WorldId: "trashed", OwnerId: "alice", Name: "Vi", Fields: map[string]string{"name": "Vi"}})
+	return ix
+}
+
+func TestScopeAllows(t *testing.T) {
+	ix := newTestIndex()
+
+	tests := []struct {
+		name  string
+		scope Scope
+		kind  string
+		id    string
+		want  bool
+	}{
+		{"own world", Scope{UserId: "alice"}, KindWorld, "eberron", true},
+		{"other's world", Scope{UserId: "alice"}, KindWorld, "faerun", false},
+		{"shared world", Scope{UserId: "alice", Shared: map[string]bool{"faerun": true}}, KindWorld, "faerun", true},
+		{"character of own world", Scope{UserId: "alice"}, KindCharacter, "lei", true},
+		{"own character in other's world", Scope{UserId: "alice"}, KindCharacter, "drizzt", false},
+		{"character of shared world", Scope{UserId: "carol", Shared: map[string]bool{"eberron": true}}, KindCharacter, "lei", true},
+		{"own character of unindexed world", Scope{UserId: "alice"}, KindCharacter, "vi", false},
+		{"shared unindexed world", Scope{UserId: "alice", Shared: map[string]bool{"trashed": true}}, KindCharacter, "vi", false},
+		{"kind allowed", Scope{UserId: "alice", Kinds: []string{KindCharacter}}, KindCharacter, "lei", true},
+		{"kind left out", Scope{UserId: "alice", Kinds: []string{KindCharacter}}, KindWorld, "eberron", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, found := ix.docs[docKey(tt.kind, tt.id)]
+			if !found {
+				t.Fatalf("%s %s isn't indexed", tt.kind, tt.id)
+			}
+			if got := tt.scope.allows(ix, e.doc); got != tt.want {
+				t.Errorf("allows(%s %s) = %v, want %v", tt.kind, tt.id, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSearch(t *testing.T) {
+	ix := NewIndex()
+	ix.Put(Document{Kind: KindWorld, Id: "w1", WorldId: "w1", OwnerId: "alice", Name: "Sharn", Fields: map[string]string{
+		"name":  "Sharn",
+		"intro": "The city of towers, home of the Broken Anvil tavern.",
+	}})
+	ix.Put(Document{Kind: KindCharacter, Id: "c1", WorldId: "w1", OwnerId: "alice", Name: "Tavern Keeper", Fields: map[string]string{
+		"name": "Tavern Keeper",
+	}})
+	ix.Put(Document{Kind: KindCharacter, Id: "c2", WorldId: "w1", OwnerId: "alice", Name: "Lei", Fields: map[string]string{
+		"name":  "Lei",
+		"intro": "An artificer <b>from</b> House Cannith.",
+	}})
+
+	scope := Scope{UserId: "alice"}
+
+	tests := []struct {
+		name  string
+		query string
+		limit int
+		want  []string
+	}{
+		{"name ranks first", "tavern", 0, []string{"c1", "w1"}},
+		{"prefix", "tav", 0, []string{"c1", "w1"}},
+		{"limit", "tavern", 1, []string{"c1"}},
+		{"case", "CANNITH", 0, []string{"c2"}},
+		{"stop words only", "the of", 0, []string{}},
+		{"no match", "dragon", 0, []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := []string{}
+			for _, r := range ix.Search(tt.query, scope, tt.limit) {
+				got = append(got, r.Id)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Search(%q) = %v, want %v", tt.query, got, tt.want)
+			}
+		})
+	}
+
+	results := ix.Search("cannith", scope, 0)
+	want := "An artificer &lt;b&gt;from&lt;/b&gt; House <mark>Cannith</mark>."
+	if got := results[0].Highlights["intro"]; got != want {
+		t.Errorf("Highlights[intro] = %q, want %q", got, want)
+	}
+}
+
+func TestIndexTransaction(t *testing.T) {
+	ix := newTestIndex()
+	scope := Scope{UserId: "alice"}
+
+	tx := ix.Begin()
+	tx.Delete(KindCharacter, "lei")
+	tx.Put(Document{Kind: KindCharacter, Id: "vi", WorldId: "eberron", OwnerId: "alice", Name: "Vi", Fields: map[string]string{"name": "Vi"}})
+
+	if got := len(ix.Search("lei", scope, 0)); got != 1 {
+		t.Fatalf("Search(lei) before Commit returned %d results, want 1", got)
+	}
+
+	tx.Commit()
+
+	if got := len(ix.Search("lei", scope, 0)); got != 0 {
+		t.Errorf("Search(lei) after Commit returned %d results, want 0", got)
+	}
+	if got := len(ix.Search("vi", scope, 0)); got != 1 {
+		t.Errorf("Search(vi) after Commit returned %d results, want 1", got)
+	}
+}
@@ -1,42 +1,260 @@
 package services
 
 import (
+	"time"
+
 	"github.com/jplindgren/rpg-vault/internal/characters"
-	"github.com/jplindgren/rpg-vault/internal/clients"
+	"github.com/jplindgren/rpg-vault/internal/items"
+	"github.com/jplindgren/rpg-vault/internal/locations"
+	"github.com/jplindgren/rpg-vault/internal/rolls"
+	"github.com/jplindgren/rpg-vault/internal/search"
+	"github.com/jplindgren/rpg-vault/internal/storage"
+	"github.com/jplindgren/rpg-vault/internal/uploader"
 	"github.com/jplindgren/rpg-vault/internal/users"
 	"github.com/jplindgren/rpg-vault/internal/worlds"
 )
 
+// TableNames holds the name of every table, so several environments can share an AWS
+// account by using their own tables.
+type TableNames struct {
+	Users       string
+	Tokens      string
+	Permissions string
+	Worlds      string
+	Members     string
+	Characters  string
+	Rolls       string
+	Locations   string
+	Items       string
+	Inventory   string
+}
+
+// Tables describes every table used by the API, along with the keys and secondary
+// indexes the services rely on. The in-memory store needs it to know how items are
+// identified, the SQL store to know the columns of every table, and cmd/bootstrap to
+// create the DynamoDB tables.
+//
+// Worlds, characters, locations and item definitions have an index per sort option of
+// their listings. Items only appear in an index when they have its sort key, so
+// updatedAt is set on creation. Items created before that have an empty updatedAt,
+// which DynamoDB leaves out of the updatedAt-index until they are updated. The
+// deletedAt-index is sparse on purpose: it only holds the items in the trash. Rolls are
+// listed newest first from the rolledAt-index. Inventory entries are partitioned by
+// world, so they are deleted along with it, and read per character from the
+// characterId-index.
+func Tables(names TableNames) []storage.Table {
+	return []storage.Table{
+		{Name: names.Users, PartitionKey: "email"},
+		{Name: names.Tokens, PartitionKey: "hash", BinaryKeys: []string{"hash"}, TTLAttribute: "expiry", Indexes: []storage.Index{
+			{Name: "email-index", PartitionKey: "email"},
+		}},
+		{Name: names.Permissions, PartitionKey: "email"},
+		{Name: names.Worlds, PartitionKey: "userId", SortKey: "id", Indexes: []storage.Index{
+			{Name: "name-index", PartitionKey: "userId", SortKey: "name"},
+			{Name: "createdAt-index", PartitionKey: "userId", SortKey: "createdAt"},
+			{Name: "updatedAt-index", PartitionKey: "userId", SortKey: "updatedAt"},
+			{Name: "deletedAt-index", PartitionKey: "userId", SortKey: "deletedAt"},
+		}},
+		{Name: names.Members, PartitionKey: "worldId", SortKey: "email", Indexes: []storage.Index{
+			{Name: "email-index", PartitionKey: "email", SortKey: "worldId"},
+		}},
+		{Name: names.Characters, PartitionKey: "worldId", SortKey: "id", Indexes: []storage.Index{
+			{Name: "ownerId-index", PartitionKey: "ownerId", SortKey: "id"},
+			{Name: "name-index", PartitionKey: "worldId", SortKey: "name"},
+			{Name: "createdAt-index", PartitionKey: "worldId", SortKey: "createdAt"},
+			{Name: "updatedAt-index", PartitionKey: "worldId", SortKey: "updatedAt"},
+			{Name: "deletedAt-index", PartitionKey: "ownerId", SortKey: "deletedAt"},
+		}},
+		{Name: names.Locations, PartitionKey: "worldId", SortKey: "id", Indexes: []storage.Index{
+			{Name: "name-index", PartitionKey: "worldId", SortKey: "name"},
+			{Name: "createdAt-index", PartitionKey: "worldId", SortKey: "createdAt"},
+			{Name: "updatedAt-index", PartitionKey: "worldId", SortKey: "updatedAt"},
+		}},
+		{Name: names.Items, PartitionKey: "worldId", SortKey: "id", Indexes: []storage.Index{
+			{Name: "name-index", PartitionKey: "worldId", SortKey: "name"},
+			{Name: "createdAt-index", PartitionKey: "worldId", SortKey: "createdAt"},
+			{Name: "updatedAt-index", PartitionKey: "worldId", SortKey: "updatedAt"},
+		}},
+		{Name: names.Inventory, PartitionKey: "worldId", SortKey: "id", Indexes: []storage.Index{
+			{Name: "characterId-index", PartitionKey: "characterId", SortKey: "id"},
+		}},
+		{Name: names.Rolls, PartitionKey: "worldId", SortKey: "id", Indexes: []storage.Index{
+			{Name: "rolledAt-index", PartitionKey: "worldId", SortKey: "rolledAt"},
+		}},
+	}
+}
+
+// The handlers only depend on these interfaces, so the services can be replaced by
+// mocks in unit tests.
+type UserRepository interface {
+	Insert(user *users.User, permissions ...string) error
+	GetByEmail(email string) (*users.User, error)
+	Update(user *users.User) error
+}
+
+type TokenRepository interface {
+	New(email string, ttl time.Duration, scope string) (*users.Token, error)
+	NewInFamily(email string, ttl time.Duration, scope, family string) (*users.Token, error)
+	GetForScope(scope, tokenPlaintext string) (*users.Token, error)
+	Touch(token *users.Token) error
+	MarkUsed(token *users.Token) error
+	ListActiveForUser(scope, email string) ([]*users.Token, error)
+	Delete(token *users.Token) error
+	DeleteAllForUser(scope, email string) error
+	DeleteFamily(email, family string) error
+	DeleteAll(email string) error
+}
+
+type WorldRepository interface {
+	Insert(world *worlds.World) error
+	Get(userId, id string) (*worlds.World, error)
+	Update(userId, id string, world *worlds.World, imageUpdated bool) error
+	List(userId string, filters worlds.Filters, limit int, start storage.Item) (*[]worlds.World, storage.Item, error)
+	Delete(userId, id string) error
+	Restore(userId, id string) (*worlds.World, error)
+	ListDeleted(userId string, limit int, start storage.Item) (*[]worlds.World, storage.Item, error)
+	// Purge removes the world along with its characters, inventories, items, locations,
+	// rolls, memberships and files.
+	Purge(userId, id string) error
+	PurgeDeleted(before time.Time) (int, error)
+	Reindex() (int, error)
+}
+
+type CharacterRepository interface {
+	Insert(character *characters.Character) error
+	Get(worldId, id string) (*characters.Character, error)
+	GetDeleted(worldId, id string) (*characters.Character, error)
+	List(worldId string, filters characters.Filters, limit int, start storage.Item) (*[]characters.Character, storage.Item, error)
+	ListByOwner(ownerId string) (*[]characters.Character, error)
+	Update(worldId, id string, uc *characters.Character) error
+	UpdateOwner(character *characters.Character, ownerId string) error
+	Delete(worldId, id string) error
+	Restore(worldId, id string) (*characters.Character, error)
+	ListDeleted(ownerId string, limit int, start storage.Item) (*[]characters.Character, storage.Item, error)
+	Purge(worldId, id string) error
+	PurgeDeleted(before time.Time) (int, error)
+	Reindex() (int, error)
+}
+
+type LocationRepository interface {
+	Insert(location *locations.Location) error
+	Get(worldId, id string) (*locations.Location, error)
+	Path(worldId, id string) ([]locations.Location, error)
+	Height(worldId, id string) (int, error)
+	List(worldId string, filters locations.Filters, limit int, start storage.Item) (*[]locations.Location, storage.Item, error)
+	Update(location *locations.Location, mapUpdated bool) error
+	// Delete removes the location along with its sub-locations.
+	Delete(worldId, id string) error
+}
+
+type ItemRepository interface {
+	Insert(item *items.Item) error
+	Get(worldId, id string) (*items.Item, error)
+	List(worldId string, filters items.Filters, limit int, start storage.Item) (*[]items.Item, storage.Item, error)
+	Update(item *items.Item) error
+	HoldsEntries(worldId, id string) (bool, error)
+	// Delete removes the item along with the inventory entries holding it.
+	Delete(worldId, id string) error
+}
+
+type InventoryRepository interface {
+	Add(entry *items.Entry) error
+	Get(worldId, characterId, id string) (*items.Entry, error)
+	List(worldId, characterId string) ([]items.Entry, error)
+	Weight(worldId, characterId string) (float64, error)
+	Update(entry *items.Entry) error
+	Delete(entry *items.Entry) error
+	// Transfer atomically moves quantity of the entry to another character.
+	Transfer(entry *items.Entry, contents []items.Entry, toCharacterId string, quantity int) (*items.Entry, error)
+}
+
+type RollRepository interface {
+	Insert(roll *rolls.Roll) error
+	List(worldId string, limit int, start storage.Item) (*[]rolls.Roll, storage.Item, error)
+}
+
 type Services struct {
-	Users      *users.UserService
-	Tokens     *users.TokenService
-	Worlds     *worlds.WorldService
-	Characters *characters.CharacterService
-}
-
-// Interface to mock models and help unit tests
-// type Services struct {
-// 	Users interface {
-// 		Insert(user *User) error
-// 		Get(id int64) (*User, error)
-// 		Update(user *User) error
-// 		Delete(id int64) error
-// 	}
-// }
-
-func NewServices(dynClientWrapper *clients.DynamoDbClientWrapper, s3ClientWrapper *clients.S3ClientWrapper) Services {
+	Users       UserRepository
+	Tokens      TokenRepository
+	Permissions *users.PermissionService
+	Worlds      WorldRepository
+	Members     *worlds.MemberService
+	Characters  CharacterRepository
+	Locations   LocationRepository
+	Items       ItemRepository
+	Inventory   InventoryRepository
+	Rolls       RollRepository
+	Blobs       uploader.BlobStore
+	Search      *search.Index
+
+	store       storage.Store
+	tables      TableNames
+	permissions users.PermissionSettings
+}
+
+func NewServices(store storage.Store, blobs uploader.BlobStore, index *search.Index, tables TableNames, permissions users.PermissionSettings) Services {
 	return Services{
-		Users:      users.New(dynClientWrapper, "rpg_users"),
-		Tokens:     users.NewTokenSrv(dynClientWrapper, "rpg_usertokens"),
-		Worlds:     worlds.New(dynClientWrapper, s3ClientWrapper, "rpg_worlds"),
-		Characters: characters.New(dynClientWrapper, "rpg_characters"),
+		Users:       users.New(store, tables.Users, tables.Permissions),
+		Tokens:      users.NewTokenSrv(store, tables.Tokens),
+		Permissions: users.NewPermissionSrv(store, tables.Permissions, permissions),
+		Worlds: worlds.New(store, blobs, index, worlds.Tables{
+			Worlds:     tables.Worlds,
+			Members:    tables.Members,
+			Characters: tables.Characters,
+			Rolls:      tables.Rolls,
+			Locations:  tables.Locations,
+			Items:      tables.Items,
+			Inventory:  tables.Inventory,
+		}),
+		Members:     worlds.NewMemberSrv(store, tables.Members),
+		Characters:  characters.New(store, index, tables.Characters, tables.Inventory),
+		Locations:   locations.New(store, blobs, tables.Locations),
+		Items:       items.New(store, tables.Items, tables.Inventory),
+		Inventory:   items.NewInventorySrv(store, tables.Inventory, tables.Items),
+		Rolls:       rolls.New(store, tables.Rolls),
+		Blobs:       blobs,
+		Search:      index,
+		store:       store,
+		tables:      tables,
+		permissions: permissions,
 	}
 }
 
-// Create a helper function which returns a Models instance containing the mock models
-// only.
-// func NewServices() Services {
-//     return Services{
-//         Users: MockUserService{},
-//     }
-// }
+// Reindex rebuilds the search index from the stored worlds and characters, returning
+// how many documents were indexed. The documents are indexed apart and then swapped
+// in, so searches keep working meanwhile. Changes made while the index is rebuilt may
+// be missed until the next rebuild.
+func (s Services) Reindex() (int, error) {
+	index := search.NewIndex()
+	fresh := NewServices(s.store, s.Blobs, index, s.tables, s.permissions)
+
+	worldCount, err := fresh.Worlds.Reindex()
+	if err != nil {
+		return 0, err
+	}
+
+	characterCount, err := fresh.Characters.Reindex()
+	if err != nil {
+		return 0, err
+	}
+
+	s.Search.Replace(index)
+	return worldCount + characterCount, nil
+}
+
+// Transact runs fn with services bound to a single transaction, so operations spanning
+// several entities are applied atomically by the stores that support transactions.
+// The changes to the search index are only applied once the transaction commits.
+func (s Services) Transact(fn func(tx Services) error) error {
+	var pending *search.Index
+	err := storage.Transact(s.store, func(tx storage.Store) error {
+		pending = s.Search.Begin()
+		return fn(NewServices(tx, s.Blobs, pending, s.tables, s.permissions))
+	})
+	if err != nil {
+		return err
+	}
+
+	pending.Commit()
+	return nil
+}
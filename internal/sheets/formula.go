@@ -0,0 +1,382 @@
+package sheets
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+var ErrorDivisionByZero = errors.New("division by zero")
+
+// Formula is an arithmetic expression over the numeric fields of a sheet, like
+// "floor((strength - 10) / 2)". It supports numbers, field names, parentheses, the
+// + - * / % operators, unary minus and the functions below.
+type Formula struct {
+	root node
+}
+
+var functions = map[string]struct {
+	minArgs, maxArgs int
+	fn               func(args []float64) float64
+}{
+	"floor": {1, 1, func(args []float64) float64 { return math.Floor(args[0]) }},
+	"ceil":  {1, 1, func(args []float64) float64 { return math.Ceil(args[0]) }},
+	"round": {1, 1, func(args []float64) float64 { return math.Round(args[0]) }},
+	"abs":   {1, 1, func(args []float64) float64 { return math.Abs(args[0]) }},
+	"min": {1, -1, func(args []float64) float64 {
+		m := args[0]
+		for _, a := range args[1:] {
+			m = math.Min(m, a)
+		}
+		return m
+	}},
+	"max": {1, -1, func(args []float64) float64 {
+		m := args[0]
+		for _, a := range args[1:] {
+			m = math.Max(m, a)
+		}
+		return m
+	}},
+}
+
+// ParseFormula parses a formula, reporting the position of the first syntax error.
+func ParseFormula(source string) (*Formula, error) {
+	tokens, err := lex(source)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+
+	root, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+
+	if t := p.peek(); t.kind != tokenEnd {
+		return nil, fmt.Errorf("unexpected %q at %d", t.text, t.pos)
+	}
+
+	return &Formula{root: root}, nil
+}
+
+// Eval computes the formula with the given field values. Fields missing from values
+// are an error.
+func (f *Formula) Eval(values map[string]float64) (float64, error) {
+	return f.root.eval(values)
+}
+
+// Fields returns the names of the fields the formula refers to.
+func (f *Formula) Fields() []string {
+	var names []string
+	f.root.fields(func(name string) {
+		for _, n := range names {
+			if n == name {
+				return
+			}
+		}
+		names = append(names, name)
+	})
+	return names
+}
+
+type node interface {
+	eval(values map[string]float64) (float64, error)
+	fields(add func(name string))
+}
+
+type numberNode float64
+
+func (n numberNode) eval(map[string]float64) (float64, error) { return float64(n), nil }
+func (n numberNode) fields(func(string))                      {}
+
+type fieldNode string
+
+func (n fieldNode) eval(values map[string]float64) (float64, error) {
+	value, found := values[string(n)]
+	if !found {
+		return 0, fmt.Errorf("missing value for %s", string(n))
+	}
+	return value, nil
+}
+
+func (n fieldNode) fields(add func(string)) { add(string(n)) }
+
+type negateNode struct {
+	operand node
+}
+
+func (n negateNode) eval(values map[string]float64) (float64, error) {
+	value, err := n.operand.eval(values)
+	return -value, err
+}
+
+func (n negateNode) fields(add func(string)) { n.operand.fields(add) }
+
+type binaryNode struct {
+	op          byte
+	left, right node
+}
+
+func (n binaryNode) eval(values map[string]float64) (float64, error) {
+	left, err := n.left.eval(values)
+	if err != nil {
+		return 0, err
+	}
+
+	right, err := n.right.eval(values)
+	if err != nil {
+		return 0, err
+	}
+
+	switch n.op {
+	case '+':
+		return left + right, nil
+	case '-':
+		return left - right, nil
+	case '*':
+		return left * right, nil
+	case '/':
+		if right == 0 {
+			return 0, ErrorDivisionByZero
+		}
+		return left / right, nil
+	default:
+		if right == 0 {
+			return 0, ErrorDivisionByZero
+		}
+		return math.Mod(left, right), nil
+	}
+}
+
+func (n binaryNode) fields(add func(string)) {
+	n.left.fields(add)
+	n.right.fields(add)
+}
+
+type callNode struct {
+	name string
+	args []node
+}
+
+func (n callNode) eval(values map[string]float64) (float64, error) {
+	args := make([]float64, len(n.args))
+	for i, arg := range n.args {
+		value, err := arg.eval(values)
+		if err != nil {
+			return 0, err
+		}
+		args[i] = value
+	}
+
+	return functions[n.name].fn(args), nil
+}
+
+func (n callNode) fields(add func(string)) {
+	for _, arg := range n.args {
+		arg.fields(add)
+	}
+}
+
+type tokenKind int
+
+const (
+	tokenEnd tokenKind = iota
+	tokenNumber
+	tokenName
+	tokenOperator
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func lex(source string) ([]token, error) {
+	var tokens []token
+
+	for i := 0; i < len(source); {
+		c := rune(source[i])
+
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case unicode.IsDigit(c) || c == '.':
+			start := i
+			for i < len(source) && (unicode.IsDigit(rune(source[i])) || source[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: source[start:i], pos: start})
+		case unicode.IsLetter(c) || c == '_':
+			start := i
+			for i < len(source) && (unicode.IsLetter(rune(source[i])) || unicode.IsDigit(rune(source[i])) || source[i] == '_') {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenName, text: source[start:i], pos: start})
+		case strings.ContainsRune("+-*/%(),", c):
+			tokens = append(tokens, token{kind: tokenOperator, text: string(c), pos: i})
+			i++
+		default:
+			return nil, fmt.Errorf("unexpected %q at %d", c, i)
+		}
+	}
+
+	return append(tokens, token{kind: tokenEnd, text: "end of formula", pos: len(source)}), nil
+}
+
+// parser is a recursive descent parser for the grammar:
+//
+//	expression = term { ("+" | "-") term }
+//	term       = unary { ("*" | "/" | "%") unary }
+//	unary      = "-" unary | primary
+//	primary    = number | name [ "(" expression { "," expression } ")" ] | "(" expression ")"
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEnd {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) accept(op string) bool {
+	if t := p.peek(); t.kind == tokenOperator && t.text == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(op string) error {
+	if !p.accept(op) {
+		t := p.peek()
+		return fmt.Errorf("expected %q at %d, found %q", op, t.pos, t.text)
+	}
+	return nil
+}
+
+func (p *parser) expression() (node, error) {
+	left, err := p.term()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		t := p.peek()
+		if t.kind != tokenOperator || (t.text != "+" && t.text != "-") {
+			return left, nil
+		}
+		p.next()
+
+		right, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryNode{op: t.text[0], left: left, right: right}
+	}
+}
+
+func (p *parser) term() (node, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		t := p.peek()
+		if t.kind != tokenOperator || (t.text != "*" && t.text != "/" && t.text != "%") {
+			return left, nil
+		}
+		p.next()
+
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryNode{op: t.text[0], left: left, right: right}
+	}
+}
+
+func (p *parser) unary() (node, error) {
+	if p.accept("-") {
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return negateNode{operand: operand}, nil
+	}
+
+	return p.primary()
+}
+
+func (p *parser) primary() (node, error) {
+	t := p.next()
+
+	switch t.kind {
+	case tokenNumber:
+		value, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q at %d", t.text, t.pos)
+		}
+		return numberNode(value), nil
+	case tokenName:
+		if !p.accept("(") {
+			return fieldNode(t.text), nil
+		}
+		return p.call(t)
+	case tokenOperator:
+		if t.text == "(" {
+			inner, err := p.expression()
+			if err != nil {
+				return nil, err
+			}
+			return inner, p.expect(")")
+		}
+	}
+
+	return nil, fmt.Errorf("unexpected %q at %d", t.text, t.pos)
+}
+
+func (p *parser) call(name token) (node, error) {
+	fn, found := functions[name.text]
+	if !found {
+		return nil, fmt.Errorf("unknown function %q at %d", name.text, name.pos)
+	}
+
+	var args []node
+	if !p.accept(")") {
+		for {
+			arg, err := p.expression()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+
+			if p.accept(")") {
+				break
+			}
+			err = p.expect(",")
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if len(args) < fn.minArgs || (fn.maxArgs >= 0 && len(args) > fn.maxArgs) {
+		return nil, fmt.Errorf("wrong number of arguments for %s at %d", name.text, name.pos)
+	}
+
+	return callNode{name: name.text, args: args}, nil
+}
@@ -0,0 +1,134 @@
+package sheets
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseFormula(t *testing.T) {
+	values := map[string]float64{"strength": 15, "level": 4, "dexterity": 8}
+
+	tests := []struct {
+		name   string
+		source string
+		want   float64
+		fields []string
+	}{
+		{"number", "42", 42, nil},
+		{"decimal", "1.5", 1.5, nil},
+		{"field", "strength", 15, []string{"strength"}},
+		{"precedence", "2 + 3 * 4", 14, nil},
+		{"left associative", "10 - 4 - 3", 3, nil},
+		{"parentheses", "(2 + 3) * 4", 20, nil},
+		{"remainder", "level % 3", 1, []string{"level"}},
+		{"unary minus", "-level + 1", -3, []string{"level"}},
+		{"double minus", "--2", 2, nil},
+		{"modifier", "floor((strength - 10) / 2)", 2, []string{"strength"}},
+		{"negative modifier", "floor((dexterity - 10) / 2)", -1, []string{"dexterity"}},
+		{"ceil", "ceil(level / 3)", 2, []string{"level"}},
+		{"round", "round(2.5)", 3, nil},
+		{"abs", "abs(dexterity - 10)", 2, []string{"dexterity"}},
+		{"min", "min(strength, level, dexterity)", 4, []string{"strength", "level", "dexterity"}},
+		{"max of one", "max(level)", 4, []string{"level"}},
+		{"repeated field", "level * level + level", 20, []string{"level"}},
+		{"spaces", "  strength+level ", 19, []string{"strength", "level"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := ParseFormula(tt.source)
+			if err != nil {
+				t.Fatalf("ParseFormula(%q) returned error: %v", tt.source, err)
+			}
+
+			got, err := f.Eval(values)
+			if err != nil {
+				t.Fatalf("Eval() returned error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Eval() = %v, want %v", got, tt.want)
+			}
+
+			if fields := f.Fields(); !reflect.DeepEqual(fields, tt.fields) {
+				t.Errorf("Fields() = %q, want %q", fields, tt.fields)
+			}
+		})
+	}
+}
+
+func TestParseFormulaErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{"empty", "", `unexpected "end of formula" at 0`},
+		{"trailing operator", "level +", `unexpected "end of formula" at 7`},
+		{"unknown character", "level # 2", `unexpected '#' at 6`},
+		{"invalid number", "1.2.3", `invalid number "1.2.3" at 0`},
+		{"unclosed parenthesis", "(level + 1", `expected ")" at 10, found "end of formula"`},
+		{"extra parenthesis", "level)", `unexpected ")" at 5`},
+		{"two operands", "level 2", `unexpected "2" at 6`},
+		{"unknown function", "sqrt(level)", `unknown function "sqrt" at 0`},
+		{"missing argument", "floor()", "wrong number of arguments for floor at 0"},
+		{"extra argument", "abs(1, 2)", "wrong number of arguments for abs at 0"},
+		{"missing comma", "min(1 2)", `expected "," at 6, found "2"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseFormula(tt.source)
+			if err == nil {
+				t.Fatalf("ParseFormula(%q) succeeded, want error %q", tt.source, tt.want)
+			}
+
+			if err.Error() != tt.want {
+				t.Errorf("ParseFormula(%q) error = %q, want %q", tt.source, err.Error(), tt.want)
+			}
+		})
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		values map[string]float64
+		want   string
+	}{
+		{"division by zero", "level / 0", map[string]float64{"level": 1}, ErrorDivisionByZero.Error()},
+		{"remainder by zero", "level % (level - 1)", map[string]float64{"level": 1}, ErrorDivisionByZero.Error()},
+		{"missing value", "strength + level", map[string]float64{"strength": 10}, "missing value for level"},
+		{"missing value in call", "max(1, level)", nil, "missing value for level"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := ParseFormula(tt.source)
+			if err != nil {
+				t.Fatalf("ParseFormula(%q) returned error: %v", tt.source, err)
+			}
+
+			_, err = f.Eval(tt.values)
+			if err == nil {
+				t.Fatalf("Eval() succeeded, want error %q", tt.want)
+			}
+			if err.Error() != tt.want {
+				t.Errorf("Eval() error = %q, want %q", err.Error(), tt.want)
+			}
+		})
+	}
+}
+
+func TestEvalDivisionByZero(t *testing.T) {
+	f, err := ParseFormula("1 / level")
+	if err != nil {
+		t.Fatalf("ParseFormula() returned error: %v", err)
+	}
+
+	_, err = f.Eval(map[string]float64{"level": 0})
+	if !errors.Is(err, ErrorDivisionByZero) {
+		t.Errorf("Eval() error = %v, want %v", err, ErrorDivisionByZero)
+	}
+}
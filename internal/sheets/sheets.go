@@ -0,0 +1,344 @@
+// Package sheets describes the character sheets of a world: the attributes its
+// characters have, their types and limits, and the ones computed from the others, like
+// the modifiers of the ability scores.
+package sheets
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+
+	"github.com/jplindgren/rpg-vault/internal/validator"
+)
+
+const (
+	TypeNumber  = "number"
+	TypeInteger = "integer"
+	TypeString  = "string"
+	TypeBoolean = "boolean"
+	TypeEnum    = "enum"
+)
+
+// The limits of a template, and of the string attributes.
+const (
+	maxFields       = 100
+	maxOptions      = 50
+	maxLabelLength  = 100
+	maxFormulaLen   = 200
+	maxStringLength = 1000
+)
+
+// FieldNameRX matches the names of the fields, which formulas refer to, so they can't
+// start with a digit.
+var FieldNameRX = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]{0,63}$`)
+
+// Template is the character sheet of a world.
+type Template struct {
+	Fields []Field `json:"fields" dynamodbav:"fields"`
+}
+
+// Field is an attribute of the sheet. Min and Max bound the numeric fields, and Options
+// lists the values of the enum fields. Default is the value new characters get when
+// they don't set the field. Fields with a Formula are computed from the other numeric
+// fields, so they can't be set by the users; integer ones are rounded down.
+type Field struct {
+	Name     string      `json:"name" dynamodbav:"name"`
+	Label    string      `json:"label,omitempty" dynamodbav:"label,omitempty"`
+	Type     string      `json:"type" dynamodbav:"type"`
+	Required bool        `json:"required,omitempty" dynamodbav:"required,omitempty"`
+	Min      *float64    `json:"min,omitempty" dynamodbav:"min,omitempty"`
+	Max      *float64    `json:"max,omitempty" dynamodbav:"max,omitempty"`
+	Options  []string    `json:"options,omitempty" dynamodbav:"options,omitempty"`
+	Default  interface{} `json:"default,omitempty" dynamodbav:"default,omitempty"`
+	Formula  string      `json:"formula,omitempty" dynamodbav:"formula,omitempty"`
+}
+
+func (f Field) numeric() bool {
+	return f.Type == TypeNumber || f.Type == TypeInteger
+}
+
+func (t *Template) field(name string) (Field, bool) {
+	for _, f := range t.Fields {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return Field{}, false
+}
+
+// ValidateTemplate checks a template, reporting the problems of each field under the
+// "sheet.<name>" key.
+func ValidateTemplate(v *validator.Validator, t *Template) {
+	v.Check(len(t.Fields) <= maxFields, "sheet", fmt.Sprintf("must not have more than %d fields", maxFields))
+
+	names := make([]string, len(t.Fields))
+	for i, f := range t.Fields {
+		names[i] = f.Name
+	}
+	v.Check(validator.Unique(names), "sheet", "must not contain duplicate field names")
+
+	for i, f := range t.Fields {
+		key := "sheet." + f.Name
+		if !validator.Matches(f.Name, FieldNameRX) {
+			key = fmt.Sprintf("sheet.fields[%d]", i)
+			v.AddError(key, "name must start with a letter or an underscore, followed by letters, digits and underscores")
+			continue
+		}
+
+		v.Check(len(f.Label) <= maxLabelLength, key, fmt.Sprintf("label must not be more than %d characters long", maxLabelLength))
+		v.Check(validator.PermittedValue(f.Type, TypeNumber, TypeInteger, TypeString, TypeBoolean, TypeEnum), key, "type must be number, integer, string, boolean or enum")
+
+		if f.Min != nil || f.Max != nil {
+			v.Check(f.numeric(), key, "only numeric fields can have a min or a max")
+		}
+		if f.Min != nil && f.Max != nil {
+			v.Check(*f.Min <= *f.Max, key, "min must not be greater than max")
+		}
+
+		if f.Type == TypeEnum {
+			v.Check(len(f.Options) > 0, key, "must list the options of the enum")
+			v.Check(len(f.Options) <= maxOptions, key, fmt.Sprintf("must not have more than %d options", maxOptions))
+			v.Check(validator.Unique(f.Options), key, "must not contain duplicate options")
+		} else {
+			v.Check(len(f.Options) == 0, key, "only enum fields can have options")
+		}
+
+		if f.Formula != "" {
+			validateFormula(v, t, key, f)
+		}
+
+		if f.Default != nil {
+			v.Check(f.Formula == "", key, "computed fields can't have a default")
+			validateDefault(v, key, f)
+		}
+	}
+
+	if v.Valid() {
+		_, err := t.computeOrder()
+		if err != nil {
+			v.AddError("sheet", err.Error())
+		}
+	}
+}
+
+func validateFormula(v *validator.Validator, t *Template, key string, f Field) {
+	v.Check(f.numeric(), key, "only numeric fields can have a formula")
+	v.Check(!f.Required, key, "computed fields can't be required")
+
+	if len(f.Formula) > maxFormulaLen {
+		v.AddError(key, fmt.Sprintf("formula must not be more than %d characters long", maxFormulaLen))
+		return
+	}
+
+	formula, err := ParseFormula(f.Formula)
+	if err != nil {
+		v.AddError(key, "invalid formula: "+err.Error())
+		return
+	}
+
+	for _, name := range formula.Fields() {
+		ref, found := t.field(name)
+		v.Check(found, key, fmt.Sprintf("formula refers to the unknown field %q", name))
+		v.Check(!found || ref.numeric(), key, fmt.Sprintf("formula refers to the non numeric field %q", name))
+	}
+}
+
+// validateDefault checks the default of a field as any other value of the field,
+// reporting the problems under the key of the field.
+func validateDefault(v *validator.Validator, key string, f Field) {
+	dv := validator.New()
+	checkValue(dv, key, f, f.Default)
+	for _, message := range dv.Errors {
+		v.AddError(key, "default "+message)
+	}
+}
+
+// computeOrder returns the computed fields sorted so every field comes after the
+// computed fields its formula refers to, or an error when they refer to each other.
+func (t *Template) computeOrder() ([]Field, error) {
+	var order []Field
+	state := make(map[string]int) // 1 while visiting, 2 once done
+
+	var visit func(f Field) error
+	visit = func(f Field) error {
+		switch state[f.Name] {
+		case 1:
+			return fmt.Errorf("the formula of %s depends on itself", f.Name)
+		case 2:
+			return nil
+		}
+
+		state[f.Name] = 1
+
+		formula, err := ParseFormula(f.Formula)
+		if err != nil {
+			return err
+		}
+
+		for _, name := range formula.Fields() {
+			ref, found := t.field(name)
+			if found && ref.Formula != "" {
+				err = visit(ref)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		state[f.Name] = 2
+		order = append(order, f)
+		return nil
+	}
+
+	for _, f := range t.Fields {
+		if f.Formula != "" {
+			err := visit(f)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return order, nil
+}
+
+// ValidateAttributes checks the attributes of a character against the template,
+// reporting the problems of each one under the "attributes.<name>" key. Numbers are
+// expected as decoded by encoding/json, that is as float64.
+func ValidateAttributes(v *validator.Validator, t *Template, attributes map[string]interface{}) {
+	for name := range attributes {
+		f, found := t.field(name)
+		v.Check(found, "attributes."+name, "is not a field of the sheet")
+		v.Check(!found || f.Formula == "", "attributes."+name, "is computed and can't be set")
+	}
+
+	for _, f := range t.Fields {
+		if f.Formula != "" {
+			continue
+		}
+
+		key := "attributes." + f.Name
+
+		value, found := attributes[f.Name]
+		if !found || value == nil {
+			v.Check(!f.Required, key, "must be provided")
+			continue
+		}
+
+		checkValue(v, key, f, value)
+	}
+}
+
+// checkValue checks a value against the type and the limits of its field.
+func checkValue(v *validator.Validator, key string, f Field, value interface{}) {
+	switch f.Type {
+	case TypeNumber, TypeInteger:
+		n, ok := value.(float64)
+		if !ok {
+			v.AddError(key, "must be a number")
+			return
+		}
+
+		v.Check(f.Type != TypeInteger || n == math.Trunc(n), key, "must be an integer")
+		checkRange(v, key, f, n)
+	case TypeString:
+		s, ok := value.(string)
+		v.Check(ok, key, "must be a string")
+		v.Check(len(s) <= maxStringLength, key, fmt.Sprintf("must not be more than %d characters long", maxStringLength))
+	case TypeBoolean:
+		_, ok := value.(bool)
+		v.Check(ok, key, "must be true or false")
+	case TypeEnum:
+		s, ok := value.(string)
+		v.Check(ok && validator.PermittedValue(s, f.Options...), key, "must be one of the options of the field")
+	}
+}
+
+func checkRange(v *validator.Validator, key string, f Field, n float64) {
+	switch {
+	case f.Min != nil && f.Max != nil:
+		v.Check(n >= *f.Min && n <= *f.Max, key, fmt.Sprintf("must be between %s and %s", formatNumber(*f.Min), formatNumber(*f.Max)))
+	case f.Min != nil:
+		v.Check(n >= *f.Min, key, "must be at least "+formatNumber(*f.Min))
+	case f.Max != nil:
+		v.Check(n <= *f.Max, key, "must be at most "+formatNumber(*f.Max))
+	}
+}
+
+func formatNumber(n float64) string {
+	return strconv.FormatFloat(n, 'f', -1, 64)
+}
+
+// ApplyDefaults sets the fields missing from the attributes of a new character to
+// their defaults, returning the attributes, which are allocated when nil.
+func (t *Template) ApplyDefaults(attributes map[string]interface{}) map[string]interface{} {
+	for _, f := range t.Fields {
+		if f.Default == nil || f.Formula != "" {
+			continue
+		}
+
+		if _, found := attributes[f.Name]; found {
+			continue
+		}
+
+		if attributes == nil {
+			attributes = make(map[string]interface{})
+		}
+		attributes[f.Name] = f.Default
+	}
+
+	return attributes
+}
+
+// Compute sets the computed fields of valid attributes, replacing any value they had.
+// Computed fields whose formula refers to a field without a value are left out.
+func (t *Template) Compute(attributes map[string]interface{}) error {
+	order, err := t.computeOrder()
+	if err != nil {
+		return err
+	}
+
+	values := make(map[string]float64)
+	for _, f := range t.Fields {
+		if n, ok := attributes[f.Name].(float64); ok && f.numeric() && f.Formula == "" {
+			values[f.Name] = n
+		}
+	}
+
+	for _, f := range order {
+		delete(attributes, f.Name)
+
+		formula, err := ParseFormula(f.Formula)
+		if err != nil {
+			return err
+		}
+
+		missing := false
+		for _, name := range formula.Fields() {
+			if _, found := values[name]; !found {
+				missing = true
+			}
+		}
+		if missing {
+			continue
+		}
+
+		n, err := formula.Eval(values)
+		if err != nil {
+			return fmt.Errorf("computing %s: %w", f.Name, err)
+		}
+		if math.IsInf(n, 0) || math.IsNaN(n) {
+			return fmt.Errorf("computing %s: result out of range", f.Name)
+		}
+
+		if f.Type == TypeInteger {
+			n = math.Floor(n)
+		}
+
+		values[f.Name] = n
+		attributes[f.Name] = n
+	}
+
+	return nil
+}
@@ -0,0 +1,99 @@
+package sheets
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestComputeOrder(t *testing.T) {
+	number := func(name, formula string) Field {
+		return Field{Name: name, Type: TypeNumber, Formula: formula}
+	}
+
+	tests := []struct {
+		name   string
+		fields []Field
+		want   []string
+	}{
+		{
+			name:   "no formulas",
+			fields: []Field{number("strength", ""), {Name: "name", Type: TypeString}},
+			want:   nil,
+		},
+		{
+			name:   "independent formulas keep their order",
+			fields: []Field{number("level", ""), number("hp", "level * 8"), number("proficiency", "ceil(level / 4) + 1")},
+			want:   []string{"hp", "proficiency"},
+		},
+		{
+			name:   "dependency declared later",
+			fields: []Field{number("attack", "str_mod + proficiency"), number("strength", ""), number("str_mod", "floor((strength - 10) / 2)"), number("proficiency", "2")},
+			want:   []string{"str_mod", "proficiency", "attack"},
+		},
+		{
+			name:   "chain",
+			fields: []Field{number("c", "b + 1"), number("b", "a + 1"), number("a", "1")},
+			want:   []string{"a", "b", "c"},
+		},
+		{
+			name:   "shared dependency",
+			fields: []Field{number("x", "base * 2"), number("y", "base + x"), number("base", "3")},
+			want:   []string{"base", "x", "y"},
+		},
+		{
+			name:   "unknown field",
+			fields: []Field{number("hp", "missing + 1")},
+			want:   []string{"hp"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			template := &Template{Fields: tt.fields}
+
+			order, err := template.computeOrder()
+			if err != nil {
+				t.Fatalf("computeOrder() returned error: %v", err)
+			}
+
+			var got []string
+			for _, f := range order {
+				got = append(got, f.Name)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("computeOrder() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestComputeOrderErrors(t *testing.T) {
+	number := func(name, formula string) Field {
+		return Field{Name: name, Type: TypeNumber, Formula: formula}
+	}
+
+	tests := []struct {
+		name   string
+		fields []Field
+		want   string
+	}{
+		{"self reference", []Field{number("a", "a + 1")}, "the formula of a depends on itself"},
+		{"cycle", []Field{number("a", "b"), number("b", "c"), number("c", "a")}, "the formula of a depends on itself"},
+		{"cycle behind a field", []Field{number("x", "a"), number("a", "b * 2"), number("b", "a")}, "the formula of a depends on itself"},
+		{"invalid formula", []Field{number("a", "1 +")}, `unexpected "end of formula" at 3`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			template := &Template{Fields: tt.fields}
+
+			_, err := template.computeOrder()
+			if err == nil {
+				t.Fatalf("computeOrder() succeeded, want error %q", tt.want)
+			}
+			if err.Error() != tt.want {
+				t.Errorf("computeOrder() error = %q, want %q", err.Error(), tt.want)
+			}
+		})
+	}
+}
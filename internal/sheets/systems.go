@@ -0,0 +1,112 @@
+package sheets
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"path"
+
+	"github.com/jplindgren/rpg-vault/internal/dice"
+	"github.com/jplindgren/rpg-vault/internal/validator"
+)
+
+// The game systems are JSON files embedded in the binary, listed in the order of their
+// names. Adding a system is a matter of adding a file.
+//
+//go:embed systems/*.json
+var systemFS embed.FS
+
+// System is a preset for a game system, which worlds can start from instead of writing
+// their sheet by hand.
+type System struct {
+	Id          string   `json:"id"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Dice        Dice     `json:"dice"`
+	Sheet       Template `json:"sheet"`
+}
+
+// Dice describes how a system rolls: the roll of a check, in dice notation, the dice
+// it uses and how the results are read.
+type Dice struct {
+	Check string   `json:"check"`
+	Uses  []string `json:"uses"`
+	Notes string   `json:"notes"`
+}
+
+var systems = mustLoadSystems()
+
+func mustLoadSystems() []System {
+	entries, err := systemFS.ReadDir("systems")
+	if err != nil {
+		panic(err)
+	}
+
+	var result []System
+	for _, entry := range entries {
+		data, err := systemFS.ReadFile(path.Join("systems", entry.Name()))
+		if err != nil {
+			panic(err)
+		}
+
+		var system System
+		err = json.Unmarshal(data, &system)
+		if err != nil {
+			panic(fmt.Sprintf("system %s: %s", entry.Name(), err))
+		}
+
+		v := validator.New()
+		if ValidateTemplate(v, &system.Sheet); !v.Valid() {
+			panic(fmt.Sprintf("system %s: invalid sheet: %v", entry.Name(), v.Errors))
+		}
+
+		_, err = dice.Parse(system.Dice.Check)
+		if err != nil {
+			panic(fmt.Sprintf("system %s: invalid check: %s", entry.Name(), err))
+		}
+
+		result = append(result, system)
+	}
+
+	return result
+}
+
+// Systems returns every game system.
+func Systems() []System {
+	return systems
+}
+
+// SystemIds returns the ids of every game system.
+func SystemIds() []string {
+	ids := make([]string, len(systems))
+	for i, s := range systems {
+		ids[i] = s.Id
+	}
+	return ids
+}
+
+// LookupSystem returns the game system with the given id. It's a deep copy the caller
+// can change without affecting the preset.
+func LookupSystem(id string) (*System, bool) {
+	for _, s := range systems {
+		if s.Id == id {
+			s.Dice.Uses = append([]string(nil), s.Dice.Uses...)
+			fields := s.Sheet.Fields
+			s.Sheet.Fields = make([]Field, len(fields))
+			for i, f := range fields {
+				if f.Min != nil {
+					min := *f.Min
+					f.Min = &min
+				}
+				if f.Max != nil {
+					max := *f.Max
+					f.Max = &max
+				}
+				f.Options = append([]string(nil), f.Options...)
+				s.Sheet.Fields[i] = f
+			}
+			return &s, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,39 @@
+package sheets
+
+import (
+	"testing"
+
+	"github.com/jplindgren/rpg-vault/internal/validator"
+)
+
+func TestSystemsAreValid(t *testing.T) {
+	for _, s := range Systems() {
+		t.Run(s.Id, func(t *testing.T) {
+			v := validator.New()
+			ValidateTemplate(v, &s.Sheet)
+			if !v.Valid() {
+				t.Errorf("ValidateTemplate() errors = %v", v.Errors)
+			}
+		})
+	}
+}
+
+func TestLookupSystemCopies(t *testing.T) {
+	s, found := LookupSystem("d100")
+	if !found {
+		t.Fatal("LookupSystem(\"d100\") not found")
+	}
+
+	s.Dice.Uses[0] = "changed"
+	*s.Sheet.Fields[1].Min = -1
+	s.Sheet.Fields[0].Name = "changed"
+
+	again, _ := LookupSystem("d100")
+	if again.Dice.Uses[0] == "changed" || *again.Sheet.Fields[1].Min == -1 || again.Sheet.Fields[0].Name == "changed" {
+		t.Errorf("LookupSystem() returned the preset itself, want a copy")
+	}
+
+	if _, found := LookupSystem("unknown"); found {
+		t.Errorf("LookupSystem(\"unknown\") found a system")
+	}
+}
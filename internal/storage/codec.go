@@ -0,0 +1,159 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// encodeItem serializes an item to JSON, keeping the type of every attribute the same
+// way the DynamoDB wire format does, e.g. {"name": {"S": "Eberron"}}.
+func encodeItem(item Item) ([]byte, error) {
+	doc, err := encodeMap(item)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(doc)
+}
+
+func decodeItem(data []byte) (Item, error) {
+	var doc map[string]map[string]json.RawMessage
+	err := json.Unmarshal(data, &doc)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeMap(doc)
+}
+
+func encodeMap(item Item) (map[string]interface{}, error) {
+	doc := make(map[string]interface{}, len(item))
+	for name, value := range item {
+		v, err := encodeValue(value)
+		if err != nil {
+			return nil, err
+		}
+		doc[name] = v
+	}
+	return doc, nil
+}
+
+func encodeValue(av types.AttributeValue) (interface{}, error) {
+	switch v := av.(type) {
+	case *types.AttributeValueMemberS:
+		return map[string]interface{}{"S": v.Value}, nil
+	case *types.AttributeValueMemberN:
+		return map[string]interface{}{"N": v.Value}, nil
+	case *types.AttributeValueMemberB:
+		return map[string]interface{}{"B": v.Value}, nil
+	case *types.AttributeValueMemberBOOL:
+		return map[string]interface{}{"BOOL": v.Value}, nil
+	case *types.AttributeValueMemberNULL:
+		return map[string]interface{}{"NULL": v.Value}, nil
+	case *types.AttributeValueMemberSS:
+		return map[string]interface{}{"SS": v.Value}, nil
+	case *types.AttributeValueMemberNS:
+		return map[string]interface{}{"NS": v.Value}, nil
+	case *types.AttributeValueMemberBS:
+		return map[string]interface{}{"BS": v.Value}, nil
+	case *types.AttributeValueMemberL:
+		list := make([]interface{}, len(v.Value))
+		for i := range v.Value {
+			elem, err := encodeValue(v.Value[i])
+			if err != nil {
+				return nil, err
+			}
+			list[i] = elem
+		}
+		return map[string]interface{}{"L": list}, nil
+	case *types.AttributeValueMemberM:
+		m, err := encodeMap(v.Value)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"M": m}, nil
+	}
+
+	return nil, fmt.Errorf("storage: unsupported attribute value %T", av)
+}
+
+func decodeMap(doc map[string]map[string]json.RawMessage) (Item, error) {
+	item := make(Item, len(doc))
+	for name, raw := range doc {
+		value, err := decodeValue(raw)
+		if err != nil {
+			return nil, err
+		}
+		item[name] = value
+	}
+	return item, nil
+}
+
+func decodeValue(raw map[string]json.RawMessage) (types.AttributeValue, error) {
+	for kind, data := range raw {
+		var err error
+
+		switch kind {
+		case "S":
+			v := &types.AttributeValueMemberS{}
+			err = json.Unmarshal(data, &v.Value)
+			return v, err
+		case "N":
+			v := &types.AttributeValueMemberN{}
+			err = json.Unmarshal(data, &v.Value)
+			return v, err
+		case "B":
+			v := &types.AttributeValueMemberB{}
+			err = json.Unmarshal(data, &v.Value)
+			return v, err
+		case "BOOL":
+			v := &types.AttributeValueMemberBOOL{}
+			err = json.Unmarshal(data, &v.Value)
+			return v, err
+		case "NULL":
+			v := &types.AttributeValueMemberNULL{}
+			err = json.Unmarshal(data, &v.Value)
+			return v, err
+		case "SS":
+			v := &types.AttributeValueMemberSS{}
+			err = json.Unmarshal(data, &v.Value)
+			return v, err
+		case "NS":
+			v := &types.AttributeValueMemberNS{}
+			err = json.Unmarshal(data, &v.Value)
+			return v, err
+		case "BS":
+			v := &types.AttributeValueMemberBS{}
+			err = json.Unmarshal(data, &v.Value)
+			return v, err
+		case "L":
+			var elems []map[string]json.RawMessage
+			err = json.Unmarshal(data, &elems)
+			if err != nil {
+				return nil, err
+			}
+
+			v := &types.AttributeValueMemberL{Value: make([]types.AttributeValue, len(elems))}
+			for i := range elems {
+				v.Value[i], err = decodeValue(elems[i])
+				if err != nil {
+					return nil, err
+				}
+			}
+			return v, nil
+		case "M":
+			var doc map[string]map[string]json.RawMessage
+			err = json.Unmarshal(data, &doc)
+			if err != nil {
+				return nil, err
+			}
+
+			m, err := decodeMap(doc)
+			return &types.AttributeValueMemberM{Value: m}, err
+		}
+	}
+
+	return nil, fmt.Errorf("storage: invalid attribute value %v", raw)
+}
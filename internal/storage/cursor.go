@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+var ErrorInvalidCursor = errors.New("invalid cursor")
+
+// Cursor marks where a listing stopped. Key is the key returned by QueryPage, and Set
+// names the query it belongs to, for listings that read from several queries in turn.
+type Cursor struct {
+	Set string
+	Key Item
+}
+
+// CursorSigner turns cursors into the opaque strings handed to the clients, and back.
+// They are signed with HMAC-SHA256, so a client can't forge or alter a cursor to start
+// a query from an arbitrary key. The signature also covers a scope, like the listing
+// and the partition the cursor was issued for, so it can't be replayed elsewhere.
+type CursorSigner struct {
+	secret []byte
+}
+
+func NewCursorSigner(secret []byte) *CursorSigner {
+	return &CursorSigner{secret: secret}
+}
+
+type cursorPayload struct {
+	Set string          `json:"s,omitempty"`
+	Key json.RawMessage `json:"k"`
+}
+
+// Encode returns the string for the cursor, or an empty string for the zero Cursor,
+// when there is no next page.
+func (c *CursorSigner) Encode(scope string, cursor Cursor) (string, error) {
+	if cursor.Set == "" && cursor.Key == nil {
+		return "", nil
+	}
+
+	key, err := encodeItem(cursor.Key)
+	if err != nil {
+		return "", err
+	}
+
+	payload, err := json.Marshal(cursorPayload{Set: cursor.Set, Key: key})
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(payload) + "." +
+		base64.RawURLEncoding.EncodeToString(c.sign(scope, payload)), nil
+}
+
+// Decode checks the signature of a cursor returned by Encode for the same scope and
+// returns it. An empty string decodes to the zero Cursor, which starts from the first
+// page.
+func (c *CursorSigner) Decode(scope, s string) (Cursor, error) {
+	if s == "" {
+		return Cursor{}, nil
+	}
+
+	encodedPayload, encodedSignature, found := strings.Cut(s, ".")
+	if !found {
+		return Cursor{}, ErrorInvalidCursor
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return Cursor{}, ErrorInvalidCursor
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(encodedSignature)
+	if err != nil || !hmac.Equal(signature, c.sign(scope, payload)) {
+		return Cursor{}, ErrorInvalidCursor
+	}
+
+	var p cursorPayload
+	err = json.Unmarshal(payload, &p)
+	if err != nil {
+		return Cursor{}, ErrorInvalidCursor
+	}
+
+	key, err := decodeItem(p.Key)
+	if err != nil {
+		return Cursor{}, ErrorInvalidCursor
+	}
+
+	if len(key) == 0 {
+		key = nil
+	}
+
+	return Cursor{Set: p.Set, Key: key}, nil
+}
+
+func (c *CursorSigner) sign(scope string, payload []byte) []byte {
+	mac := hmac.New(sha256.New, c.secret)
+	mac.Write([]byte(scope))
+	mac.Write([]byte{0})
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"encoding/base64"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	signer := NewCursorSigner([]byte("0123456789abcdef0123456789abcdef"))
+
+	tests := []struct {
+		name   string
+		cursor Cursor
+	}{
+		{"key", Cursor{Key: Item{
+			"worldId": &types.AttributeValueMemberS{Value: "w1"},
+			"id":      &types.AttributeValueMemberS{Value: "i1"},
+		}}},
+		{"set and key", Cursor{Set: "characters", Key: Item{
+			"rolledAt": &types.AttributeValueMemberN{Value: "12"},
+			"hash":     &types.AttributeValueMemberB{Value: []byte{0, 1, 2}},
+		}}},
+		{"set only", Cursor{Set: "characters"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := signer.Encode("worlds:alice", tt.cursor)
+			if err != nil {
+				t.Fatalf("Encode() returned error: %v", err)
+			}
+
+			got, err := signer.Decode("worlds:alice", s)
+			if err != nil {
+				t.Fatalf("Decode() returned error: %v", err)
+			}
+
+			if !reflect.DeepEqual(got, tt.cursor) {
+				t.Errorf("Decode() = %+v, want %+v", got, tt.cursor)
+			}
+		})
+	}
+}
+
+func TestCursorEmpty(t *testing.T) {
+	signer := NewCursorSigner([]byte("secret"))
+
+	s, err := signer.Encode("worlds:alice", Cursor{})
+	if err != nil || s != "" {
+		t.Fatalf("Encode() = %q, %v, want an empty string", s, err)
+	}
+
+	cursor, err := signer.Decode("worlds:alice", "")
+	if err != nil || !reflect.DeepEqual(cursor, Cursor{}) {
+		t.Errorf("Decode() = %+v, %v, want the zero Cursor", cursor, err)
+	}
+}
+
+func TestCursorRejected(t *testing.T) {
+	signer := NewCursorSigner([]byte("0123456789abcdef0123456789abcdef"))
+	other := NewCursorSigner([]byte("fedcba9876543210fedcba9876543210"))
+
+	cursor := Cursor{Key: Item{
+		"userId": &types.AttributeValueMemberS{Value: "alice@example.com"},
+		"id":     &types.AttributeValueMemberS{Value: "w1"},
+	}}
+
+	valid, err := signer.Encode("worlds:alice@example.com:name", cursor)
+	if err != nil {
+		t.Fatalf("Encode() returned error: %v", err)
+	}
+	payload, signature, _ := strings.Cut(valid, ".")
+
+	otherSecret, err := other.Encode("worlds:alice@example.com:name", cursor)
+	if err != nil {
+		t.Fatalf("Encode() returned error: %v", err)
+	}
+
+	// Point the cursor at the partition of another user, keeping the signature.
+	raw, _ := base64.RawURLEncoding.DecodeString(payload)
+	tamperedPayload := base64.RawURLEncoding.EncodeToString([]byte(strings.Replace(string(raw), "alice", "bobby", 1)))
+	if tamperedPayload == payload {
+		t.Fatalf("the payload %s doesn't hold the user", raw)
+	}
+
+	badSignature := []byte(signature)
+	if badSignature[0] == 'A' {
+		badSignature[0] = 'B'
+	} else {
+		badSignature[0] = 'A'
+	}
+
+	tests := []struct {
+		name   string
+		scope  string
+		cursor string
+	}{
+		{"another scope", "worlds:bob@example.com:name", valid},
+		{"another sort", "worlds:alice@example.com:-name", valid},
+		{"another secret", "worlds:alice@example.com:name", otherSecret},
+		{"tampered payload", "worlds:alice@example.com:name", tamperedPayload + "." + signature},
+		{"bad signature", "worlds:alice@example.com:name", payload + "." + string(badSignature)},
+		{"missing signature", "worlds:alice@example.com:name", payload},
+		{"empty signature", "worlds:alice@example.com:name", payload + "."},
+		{"signature not base64", "worlds:alice@example.com:name", payload + ".!!"},
+		{"payload not base64", "worlds:alice@example.com:name", "!!." + signature},
+		{"garbage", "worlds:alice@example.com:name", "not a cursor"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := signer.Decode(tt.scope, tt.cursor)
+			if err != ErrorInvalidCursor {
+				t.Errorf("Decode() error = %v, want %v", err, ErrorInvalidCursor)
+			}
+		})
+	}
+}
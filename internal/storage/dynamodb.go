@@ -0,0 +1,626 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/jplindgren/rpg-vault/internal/clients"
+)
+
+// DynamoStore stores the tables in DynamoDB, through the client wrapper.
+type DynamoStore struct {
+	db *clients.DynamoDbClientWrapper
+}
+
+func NewDynamo(db *clients.DynamoDbClientWrapper) *DynamoStore {
+	return &DynamoStore{db: db}
+}
+
+func (s *DynamoStore) Put(table string, item interface{}, cond *Condition) error {
+	if cond == nil {
+		_, err := s.db.PutWrapper(table, item, nil)
+		return err
+	}
+
+	builder, err := conditionBuilder(cond)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.PutWithConditionWrapper(table, item, builder)
+	return translateError(err)
+}
+
+func (s *DynamoStore) Get(table string, key interface{}, out interface{}) error {
+	_, err := s.db.GetWrapper(table, key, out)
+	return err
+}
+
+// Query follows LastEvaluatedKey until every matching item is read, so the results
+// aren't truncated at the 1 MB DynamoDB returns per request.
+func (s *DynamoStore) Query(table string, query *Query, out interface{}) error {
+	items, _, err := s.query(table, query, nil, 0)
+	if err != nil {
+		return err
+	}
+
+	return attributevalue.UnmarshalListOfMaps(items, out)
+}
+
+func (s *DynamoStore) QueryPage(table string, query *Query, out interface{}) (Item, error) {
+	items, next, err := s.query(table, query, query.StartKey, query.Limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return next, attributevalue.UnmarshalListOfMaps(items, out)
+}
+
+// query reads pages until limit items are read, or every item when limit is zero. The
+// Limit of a DynamoDB query applies before the filter, so a single request may return
+// fewer items than asked for even when there are more.
+func (s *DynamoStore) query(table string, query *Query, start Item, limit int) ([]Item, Item, error) {
+	keyEx := expression.Key(query.Key).Equal(expression.Value(query.Value))
+	builder := expression.NewBuilder().WithKeyCondition(keyEx)
+
+	if query.Filter != nil {
+		filter, err := conditionBuilder(query.Filter)
+		if err != nil {
+			return nil, nil, err
+		}
+		builder = builder.WithFilter(filter)
+	}
+
+	if len(query.Projection) > 0 {
+		proj := expression.NamesList(expression.Name(query.Projection[0]))
+		for _, name := range query.Projection[1:] {
+			proj = proj.AddNames(expression.Name(name))
+		}
+		builder = builder.WithProjection(proj)
+	}
+
+	expr, err := builder.Build()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	input := &dynamodb.QueryInput{
+		TableName:                 aws.String(table),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		ProjectionExpression:      expr.Projection(),
+		KeyConditionExpression:    expr.KeyCondition(),
+		FilterExpression:          expr.Filter(),
+		ScanIndexForward:          aws.Bool(!query.Descending),
+	}
+
+	if query.Index != "" {
+		input.IndexName = aws.String(query.Index)
+	}
+
+	var items []Item
+	for {
+		input.ExclusiveStartKey = start
+		if limit > 0 {
+			input.Limit = aws.Int32(int32(limit - len(items)))
+		}
+
+		response, err := s.db.Query(context.TODO(), input)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		items = append(items, response.Items...)
+		start = response.LastEvaluatedKey
+
+		if len(start) == 0 {
+			return items, nil, nil
+		}
+
+		if limit > 0 && len(items) >= limit {
+			return items, start, nil
+		}
+	}
+}
+
+func (s *DynamoStore) Scan(table string, filter *Condition, out interface{}) error {
+	return s.ScanIndex(table, "", filter, out)
+}
+
+func (s *DynamoStore) ScanIndex(table, index string, filter *Condition, out interface{}) error {
+	input := &dynamodb.ScanInput{TableName: aws.String(table)}
+	if index != "" {
+		input.IndexName = aws.String(index)
+	}
+
+	if filter != nil {
+		cond, err := conditionBuilder(filter)
+		if err != nil {
+			return err
+		}
+
+		expr, err := expression.NewBuilder().WithFilter(cond).Build()
+		if err != nil {
+			return err
+		}
+
+		input.ExpressionAttributeNames = expr.Names()
+		input.ExpressionAttributeValues = expr.Values()
+		input.FilterExpression = expr.Filter()
+	}
+
+	var items []Item
+
+	paginator := dynamodb.NewScanPaginator(s.db.Client, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return err
+		}
+		items = append(items, page.Items...)
+	}
+
+	return attributevalue.UnmarshalListOfMaps(items, out)
+}
+
+func (s *DynamoStore) Update(table string, key interface{}, update *Update) error {
+	builder := updateBuilder(update)
+
+	if update.Condition() == nil {
+		_, err := s.db.UpdateWrapper(table, key, builder)
+		return err
+	}
+
+	cond, err := conditionBuilder(update.Condition())
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.UpdateWithConditionWrapper(table, key, builder, cond)
+	return translateError(err)
+}
+
+func (s *DynamoStore) Delete(table string, key interface{}) error {
+	_, err := s.db.DeleteWrapper(table, key)
+	return err
+}
+
+// BatchWriteItem takes at most 25 requests, so the deletes are sent in chunks. Requests
+// DynamoDB leaves unprocessed, usually because the table is being throttled, are sent
+// again with an exponential backoff.
+const (
+	batchWriteSize    = 25
+	batchWriteRetries = 8
+	batchWriteBackoff = 50 * time.Millisecond
+)
+
+func (s *DynamoStore) BatchDelete(table string, keys []map[string]string) error {
+	for start := 0; start < len(keys); start += batchWriteSize {
+		end := start + batchWriteSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+
+		requests := make([]types.WriteRequest, 0, end-start)
+		for _, key := range keys[start:end] {
+			av, err := attributevalue.MarshalMap(key)
+			if err != nil {
+				return err
+			}
+
+			requests = append(requests, types.WriteRequest{DeleteRequest: &types.DeleteRequest{Key: av}})
+		}
+
+		err := s.batchWrite(table, requests)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *DynamoStore) batchWrite(table string, requests []types.WriteRequest) error {
+	backoff := batchWriteBackoff
+
+	for attempt := 0; ; attempt++ {
+		output, err := s.db.BatchWriteItem(context.TODO(), &dynamodb.BatchWriteItemInput{
+			RequestItems: map[string][]types.WriteRequest{table: requests},
+		})
+		if err != nil {
+			return err
+		}
+
+		requests = output.UnprocessedItems[table]
+		if len(requests) == 0 {
+			return nil
+		}
+
+		if attempt == batchWriteRetries {
+			return fmt.Errorf("storage: %d items of table %q left unprocessed", len(requests), table)
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// TransactWrite sends the writes in a single TransactWriteItems request.
+func (s *DynamoStore) TransactWrite(writes []Write) error {
+	err := checkWrites(writes)
+	if err != nil {
+		return err
+	}
+
+	items := make([]types.TransactWriteItem, 0, len(writes))
+	for _, w := range writes {
+		item, err := transactWriteItem(w)
+		if err != nil {
+			return err
+		}
+		items = append(items, item)
+	}
+
+	_, err = s.db.TransactWriteItems(context.TODO(), &dynamodb.TransactWriteItemsInput{TransactItems: items})
+	return translateError(err)
+}
+
+func transactWriteItem(w Write) (types.TransactWriteItem, error) {
+	builder := expression.NewBuilder()
+	hasExpression := false
+
+	if w.Item == nil && !w.Delete {
+		builder = builder.WithUpdate(updateBuilder(w.Update))
+		hasExpression = true
+	}
+
+	if cond := w.condition(); cond != nil {
+		c, err := conditionBuilder(cond)
+		if err != nil {
+			return types.TransactWriteItem{}, err
+		}
+		builder = builder.WithCondition(c)
+		hasExpression = true
+	}
+
+	var expr expression.Expression
+	if hasExpression {
+		var err error
+		expr, err = builder.Build()
+		if err != nil {
+			return types.TransactWriteItem{}, err
+		}
+	}
+
+	if w.Item != nil {
+		av, err := attributevalue.MarshalMap(w.Item)
+		if err != nil {
+			return types.TransactWriteItem{}, err
+		}
+
+		return types.TransactWriteItem{Put: &types.Put{
+			TableName:                 aws.String(w.Table),
+			Item:                      av,
+			ConditionExpression:       expr.Condition(),
+			ExpressionAttributeNames:  expr.Names(),
+			ExpressionAttributeValues: expr.Values(),
+		}}, nil
+	}
+
+	key, err := attributevalue.MarshalMap(w.Key)
+	if err != nil {
+		return types.TransactWriteItem{}, err
+	}
+
+	if w.Delete {
+		return types.TransactWriteItem{Delete: &types.Delete{
+			TableName:                 aws.String(w.Table),
+			Key:                       key,
+			ConditionExpression:       expr.Condition(),
+			ExpressionAttributeNames:  expr.Names(),
+			ExpressionAttributeValues: expr.Values(),
+		}}, nil
+	}
+
+	return types.TransactWriteItem{Update: &types.Update{
+		TableName:                 aws.String(w.Table),
+		Key:                       key,
+		UpdateExpression:          expr.Update(),
+		ConditionExpression:       expr.Condition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	}}, nil
+}
+
+// CreateTables creates the tables that don't exist yet, with their keys and global
+// secondary indexes, and waits for them to become active. Tables are billed on demand.
+// The indexes missing from the tables that already exist are added to them.
+func (s *DynamoStore) CreateTables(tables ...Table) error {
+	for _, t := range tables {
+		created, err := s.createTable(t)
+		if err != nil {
+			return fmt.Errorf("storage: creating table %q: %w", t.Name, err)
+		}
+
+		if !created {
+			err = s.createIndexes(t)
+			if err != nil {
+				return fmt.Errorf("storage: creating indexes of table %q: %w", t.Name, err)
+			}
+			continue
+		}
+
+		waiter := dynamodb.NewTableExistsWaiter(s.db.Client)
+		err = waiter.Wait(context.TODO(), &dynamodb.DescribeTableInput{TableName: aws.String(t.Name)}, 5*time.Minute)
+		if err != nil {
+			return fmt.Errorf("storage: waiting for table %q: %w", t.Name, err)
+		}
+
+		if t.TTLAttribute != "" {
+			_, err = s.db.UpdateTimeToLive(context.TODO(), &dynamodb.UpdateTimeToLiveInput{
+				TableName: aws.String(t.Name),
+				TimeToLiveSpecification: &types.TimeToLiveSpecification{
+					AttributeName: aws.String(t.TTLAttribute),
+					Enabled:       aws.Bool(true),
+				},
+			})
+			if err != nil {
+				return fmt.Errorf("storage: enabling TTL on table %q: %w", t.Name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// createTable creates a table, reporting false when it already exists.
+func (s *DynamoStore) createTable(t Table) (bool, error) {
+	keys := &keySchemas{table: t}
+
+	input := &dynamodb.CreateTableInput{
+		TableName:   aws.String(t.Name),
+		KeySchema:   keys.schema(t.PartitionKey, t.SortKey),
+		BillingMode: types.BillingModePayPerRequest,
+	}
+
+	for _, idx := range t.Indexes {
+		input.GlobalSecondaryIndexes = append(input.GlobalSecondaryIndexes, types.GlobalSecondaryIndex{
+			IndexName:  aws.String(idx.Name),
+			KeySchema:  keys.schema(idx.PartitionKey, idx.SortKey),
+			Projection: &types.Projection{ProjectionType: types.ProjectionTypeAll},
+		})
+	}
+
+	input.AttributeDefinitions = keys.attributes
+
+	_, err := s.db.CreateTable(context.TODO(), input)
+	if err != nil {
+		var riu *types.ResourceInUseException
+		switch {
+		case errors.As(err, &riu):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// createIndexes adds the indexes missing from an existing table. DynamoDB builds one
+// index at a time, backfilling it from the items already in the table, so we wait for
+// each index to become active before adding the next one.
+func (s *DynamoStore) createIndexes(t Table) error {
+	existing, err := s.indexStatuses(t.Name)
+	if err != nil {
+		return err
+	}
+
+	for _, idx := range t.Indexes {
+		if _, found := existing[idx.Name]; found {
+			continue
+		}
+
+		keys := &keySchemas{table: t}
+		schema := keys.schema(idx.PartitionKey, idx.SortKey)
+
+		_, err = s.db.UpdateTable(context.TODO(), &dynamodb.UpdateTableInput{
+			TableName:            aws.String(t.Name),
+			AttributeDefinitions: keys.attributes,
+			GlobalSecondaryIndexUpdates: []types.GlobalSecondaryIndexUpdate{{
+				Create: &types.CreateGlobalSecondaryIndexAction{
+					IndexName:  aws.String(idx.Name),
+					KeySchema:  schema,
+					Projection: &types.Projection{ProjectionType: types.ProjectionTypeAll},
+				},
+			}},
+		})
+		if err != nil {
+			return err
+		}
+
+		deadline := time.Now().Add(30 * time.Minute)
+		for {
+			time.Sleep(5 * time.Second)
+
+			statuses, err := s.indexStatuses(t.Name)
+			if err != nil {
+				return err
+			}
+
+			if statuses[idx.Name] == types.IndexStatusActive {
+				break
+			}
+
+			if time.Now().After(deadline) {
+				return fmt.Errorf("index %q is still %s", idx.Name, statuses[idx.Name])
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *DynamoStore) indexStatuses(table string) (map[string]types.IndexStatus, error) {
+	output, err := s.db.DescribeTable(context.TODO(), &dynamodb.DescribeTableInput{TableName: aws.String(table)})
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make(map[string]types.IndexStatus)
+	for _, idx := range output.Table.GlobalSecondaryIndexes {
+		statuses[aws.ToString(idx.IndexName)] = idx.IndexStatus
+	}
+
+	return statuses, nil
+}
+
+// keySchemas builds the key schemas of a table and of its indexes, collecting the
+// definitions of the key attributes along the way.
+type keySchemas struct {
+	table      Table
+	attributes []types.AttributeDefinition
+}
+
+func (k *keySchemas) schema(partitionKey, sortKey string) []types.KeySchemaElement {
+	k.define(partitionKey)
+	schema := []types.KeySchemaElement{
+		{AttributeName: aws.String(partitionKey), KeyType: types.KeyTypeHash},
+	}
+
+	if sortKey != "" {
+		k.define(sortKey)
+		schema = append(schema, types.KeySchemaElement{
+			AttributeName: aws.String(sortKey), KeyType: types.KeyTypeRange,
+		})
+	}
+
+	return schema
+}
+
+func (k *keySchemas) define(name string) {
+	for _, a := range k.attributes {
+		if *a.AttributeName == name {
+			return
+		}
+	}
+
+	attrType := types.ScalarAttributeTypeS
+	if containsString(k.table.BinaryKeys, name) {
+		attrType = types.ScalarAttributeTypeB
+	}
+
+	k.attributes = append(k.attributes, types.AttributeDefinition{
+		AttributeName: aws.String(name),
+		AttributeType: attrType,
+	})
+}
+
+// translateError turns a failed condition check into ErrorConditionFailed. Cancelled
+// transactions are one too when a condition failed or another write raced with them,
+// as in both cases the items changed since they were read.
+func translateError(err error) error {
+	var ccf *types.ConditionalCheckFailedException
+	if errors.As(err, &ccf) {
+		return ErrorConditionFailed
+	}
+
+	var tce *types.TransactionCanceledException
+	if errors.As(err, &tce) {
+		for _, reason := range tce.CancellationReasons {
+			switch aws.ToString(reason.Code) {
+			case "ConditionalCheckFailed", "TransactionConflict":
+				return ErrorConditionFailed
+			}
+		}
+	}
+
+	return err
+}
+
+func conditionBuilder(c *Condition) (expression.ConditionBuilder, error) {
+	name := expression.Name(c.name)
+
+	switch c.op {
+	case opExists:
+		return expression.AttributeExists(name), nil
+	case opNotExists:
+		return expression.AttributeNotExists(name), nil
+	case opEqual:
+		return name.Equal(expression.Value(c.value)), nil
+	case opNotEqual:
+		return name.NotEqual(expression.Value(c.value)), nil
+	case opLessThan:
+		return name.LessThan(expression.Value(c.value)), nil
+	case opLessThanEqual:
+		return name.LessThanEqual(expression.Value(c.value)), nil
+	case opGreaterThan:
+		return name.GreaterThan(expression.Value(c.value)), nil
+	case opGreaterThanEqual:
+		return name.GreaterThanEqual(expression.Value(c.value)), nil
+	case opBeginsWith:
+		return name.BeginsWith(c.value.(string)), nil
+	case opContains:
+		return name.Contains(c.value.(string)), nil
+	case opNot:
+		cond, err := conditionBuilder(c.conds[0])
+		return expression.Not(cond), err
+	case opAnd, opOr:
+		conds := make([]expression.ConditionBuilder, len(c.conds))
+		for i := range c.conds {
+			cond, err := conditionBuilder(c.conds[i])
+			if err != nil {
+				return expression.ConditionBuilder{}, err
+			}
+			conds[i] = cond
+		}
+
+		switch {
+		case len(conds) == 0:
+			return expression.ConditionBuilder{}, errors.New("storage: empty condition")
+		case len(conds) == 1:
+			return conds[0], nil
+		case c.op == opAnd:
+			return expression.And(conds[0], conds[1], conds[2:]...), nil
+		default:
+			return expression.Or(conds[0], conds[1], conds[2:]...), nil
+		}
+	}
+
+	return expression.ConditionBuilder{}, fmt.Errorf("storage: unknown condition operator %d", c.op)
+}
+
+func updateBuilder(u *Update) expression.UpdateBuilder {
+	var builder expression.UpdateBuilder
+
+	for _, s := range u.sets {
+		builder = builder.Set(expression.Name(s.name), expression.Value(s.value))
+	}
+
+	for _, a := range u.adds {
+		builder = builder.Add(
+			expression.Name(a.name),
+			expression.Value(&types.AttributeValueMemberSS{Value: a.value.([]string)}),
+		)
+	}
+
+	for _, d := range u.deletes {
+		builder = builder.Delete(
+			expression.Name(d.name),
+			expression.Value(&types.AttributeValueMemberSS{Value: d.value.([]string)}),
+		)
+	}
+
+	for _, name := range u.removes {
+		builder = builder.Remove(expression.Name(name))
+	}
+
+	return builder
+}
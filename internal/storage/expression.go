@@ -0,0 +1,395 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"reflect"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+type operator int
+
+const (
+	opExists operator = iota
+	opNotExists
+	opEqual
+	opNotEqual
+	opLessThan
+	opLessThanEqual
+	opGreaterThan
+	opGreaterThanEqual
+	opBeginsWith
+	opContains
+	opAnd
+	opOr
+	opNot
+)
+
+// Condition is a predicate on the attributes of an item. It is evaluated natively by
+// DynamoDB and in Go by the other backends, following the DynamoDB semantics: a
+// comparison against a missing attribute is always false. Names may be document paths
+// like "Attributes.class", which reach into map attributes.
+type Condition struct {
+	op    operator
+	name  string
+	value interface{}
+	conds []*Condition
+}
+
+func AttributeExists(name string) *Condition {
+	return &Condition{op: opExists, name: name}
+}
+
+func AttributeNotExists(name string) *Condition {
+	return &Condition{op: opNotExists, name: name}
+}
+
+func Equal(name string, value interface{}) *Condition {
+	return &Condition{op: opEqual, name: name, value: value}
+}
+
+func NotEqual(name string, value interface{}) *Condition {
+	return &Condition{op: opNotEqual, name: name, value: value}
+}
+
+func LessThan(name string, value interface{}) *Condition {
+	return &Condition{op: opLessThan, name: name, value: value}
+}
+
+func LessThanEqual(name string, value interface{}) *Condition {
+	return &Condition{op: opLessThanEqual, name: name, value: value}
+}
+
+func GreaterThan(name string, value interface{}) *Condition {
+	return &Condition{op: opGreaterThan, name: name, value: value}
+}
+
+func GreaterThanEqual(name string, value interface{}) *Condition {
+	return &Condition{op: opGreaterThanEqual, name: name, value: value}
+}
+
+// BeginsWith checks that a string attribute starts with prefix.
+func BeginsWith(name, prefix string) *Condition {
+	return &Condition{op: opBeginsWith, name: name, value: prefix}
+}
+
+// Contains checks that a string attribute contains value, or that a set or a list
+// attribute has value as one of its elements.
+func Contains(name, value string) *Condition {
+	return &Condition{op: opContains, name: name, value: value}
+}
+
+// ContainsFold checks that a string attribute contains value, ignoring case. DynamoDB
+// can't compare strings case-insensitively, so the items keep a lowercase copy of the
+// attribute in lowerName. Items written without the copy are matched case-sensitively.
+func ContainsFold(name, lowerName, value string) *Condition {
+	return Or(
+		Contains(lowerName, strings.ToLower(value)),
+		And(AttributeNotExists(lowerName), Contains(name, value)),
+	)
+}
+
+// And holds when all the conditions do. Nil conditions are left out, so optional
+// filters can be combined with it, and it returns nil when none is left.
+func And(conds ...*Condition) *Condition {
+	var present []*Condition
+	for _, cond := range conds {
+		if cond != nil {
+			present = append(present, cond)
+		}
+	}
+
+	switch len(present) {
+	case 0:
+		return nil
+	case 1:
+		return present[0]
+	}
+
+	return &Condition{op: opAnd, conds: present}
+}
+
+func Or(conds ...*Condition) *Condition {
+	return &Condition{op: opOr, conds: conds}
+}
+
+func Not(cond *Condition) *Condition {
+	return &Condition{op: opNot, conds: []*Condition{cond}}
+}
+
+// Eval reports whether the condition holds for the item. A missing item is represented
+// by an empty one.
+func (c *Condition) Eval(item Item) (bool, error) {
+	switch c.op {
+	case opAnd, opOr:
+		for _, cond := range c.conds {
+			ok, err := cond.Eval(item)
+			if err != nil {
+				return false, err
+			}
+			if c.op == opAnd && !ok {
+				return false, nil
+			}
+			if c.op == opOr && ok {
+				return true, nil
+			}
+		}
+		return c.op == opAnd, nil
+	case opNot:
+		ok, err := c.conds[0].Eval(item)
+		return !ok, err
+	}
+
+	attr, found := lookup(item, c.name)
+
+	switch c.op {
+	case opExists:
+		return found, nil
+	case opNotExists:
+		return !found, nil
+	}
+
+	if !found {
+		return false, nil
+	}
+
+	value, err := attributevalue.Marshal(c.value)
+	if err != nil {
+		return false, err
+	}
+
+	switch c.op {
+	case opEqual:
+		return equalValues(attr, value), nil
+	case opNotEqual:
+		return !equalValues(attr, value), nil
+	case opBeginsWith:
+		a, ok1 := attr.(*types.AttributeValueMemberS)
+		v, ok2 := value.(*types.AttributeValueMemberS)
+		return ok1 && ok2 && strings.HasPrefix(a.Value, v.Value), nil
+	case opContains:
+		return containsValue(attr, value), nil
+	}
+
+	cmp, ok := compareValues(attr, value)
+	if !ok {
+		return false, nil
+	}
+
+	switch c.op {
+	case opLessThan:
+		return cmp < 0, nil
+	case opLessThanEqual:
+		return cmp <= 0, nil
+	case opGreaterThan:
+		return cmp > 0, nil
+	case opGreaterThanEqual:
+		return cmp >= 0, nil
+	}
+
+	return false, fmt.Errorf("storage: unknown condition operator %d", c.op)
+}
+
+// lookup resolves a document path, following the map attributes named by each
+// segment.
+func lookup(item Item, path string) (types.AttributeValue, bool) {
+	names := strings.Split(path, ".")
+
+	attr, found := item[names[0]]
+	for _, name := range names[1:] {
+		m, ok := attr.(*types.AttributeValueMemberM)
+		if !found || !ok {
+			return nil, false
+		}
+		attr, found = m.Value[name]
+	}
+
+	return attr, found
+}
+
+// Update describes the changes made to an item by Store.Update. Build it with Set()
+// or AddToSet() and chain further changes, like the DynamoDB expression builder.
+type Update struct {
+	sets    []assignment
+	adds    []assignment
+	deletes []assignment
+	removes []string
+	cond    *Condition
+}
+
+type assignment struct {
+	name  string
+	value interface{}
+}
+
+func Set(name string, value interface{}) *Update {
+	return (&Update{}).Set(name, value)
+}
+
+func AddToSet(name string, values ...string) *Update {
+	return (&Update{}).AddToSet(name, values...)
+}
+
+func DeleteFromSet(name string, values ...string) *Update {
+	return (&Update{}).DeleteFromSet(name, values...)
+}
+
+// Set replaces the value of an attribute.
+func (u *Update) Set(name string, value interface{}) *Update {
+	u.sets = append(u.sets, assignment{name, value})
+	return u
+}
+
+// AddToSet adds values to a string set attribute, creating it if needed.
+func (u *Update) AddToSet(name string, values ...string) *Update {
+	u.adds = append(u.adds, assignment{name, values})
+	return u
+}
+
+// DeleteFromSet removes values from a string set attribute. Like in DynamoDB, a set
+// left empty is removed from the item.
+func (u *Update) DeleteFromSet(name string, values ...string) *Update {
+	u.deletes = append(u.deletes, assignment{name, values})
+	return u
+}
+
+// Remove deletes an attribute from the item.
+func (u *Update) Remove(name string) *Update {
+	u.removes = append(u.removes, name)
+	return u
+}
+
+// If makes the update conditional. When the condition doesn't hold for the stored item
+// the update fails with ErrorConditionFailed.
+func (u *Update) If(cond *Condition) *Update {
+	u.cond = cond
+	return u
+}
+
+// Condition returns the condition set with If(), if any.
+func (u *Update) Condition() *Condition {
+	return u.cond
+}
+
+// Apply returns a copy of the item with the update applied to it.
+func (u *Update) Apply(item Item) (Item, error) {
+	updated := make(Item, len(item))
+	for name, value := range item {
+		updated[name] = value
+	}
+
+	for _, s := range u.sets {
+		value, err := attributevalue.Marshal(s.value)
+		if err != nil {
+			return nil, err
+		}
+		updated[s.name] = value
+	}
+
+	for _, a := range u.adds {
+		var set []string
+		if current, ok := updated[a.name].(*types.AttributeValueMemberSS); ok {
+			set = append(set, current.Value...)
+		}
+
+		for _, value := range a.value.([]string) {
+			if !containsString(set, value) {
+				set = append(set, value)
+			}
+		}
+		updated[a.name] = &types.AttributeValueMemberSS{Value: set}
+	}
+
+	for _, d := range u.deletes {
+		current, ok := updated[d.name].(*types.AttributeValueMemberSS)
+		if !ok {
+			continue
+		}
+
+		var set []string
+		for _, value := range current.Value {
+			if !containsString(d.value.([]string), value) {
+				set = append(set, value)
+			}
+		}
+
+		if len(set) == 0 {
+			delete(updated, d.name)
+		} else {
+			updated[d.name] = &types.AttributeValueMemberSS{Value: set}
+		}
+	}
+
+	for _, name := range u.removes {
+		delete(updated, name)
+	}
+
+	return updated, nil
+}
+
+// compareValues orders two scalar values of the same type. It reports false when the
+// values can't be ordered.
+func compareValues(a, b types.AttributeValue) (int, bool) {
+	switch a := a.(type) {
+	case *types.AttributeValueMemberS:
+		if b, ok := b.(*types.AttributeValueMemberS); ok {
+			return strings.Compare(a.Value, b.Value), true
+		}
+	case *types.AttributeValueMemberN:
+		if b, ok := b.(*types.AttributeValueMemberN); ok {
+			x, okA := new(big.Float).SetString(a.Value)
+			y, okB := new(big.Float).SetString(b.Value)
+			if okA && okB {
+				return x.Cmp(y), true
+			}
+		}
+	case *types.AttributeValueMemberB:
+		if b, ok := b.(*types.AttributeValueMemberB); ok {
+			return bytes.Compare(a.Value, b.Value), true
+		}
+	}
+
+	return 0, false
+}
+
+func equalValues(a, b types.AttributeValue) bool {
+	if cmp, ok := compareValues(a, b); ok {
+		return cmp == 0
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func containsValue(attr, value types.AttributeValue) bool {
+	v, ok := value.(*types.AttributeValueMemberS)
+	if !ok {
+		return false
+	}
+
+	switch a := attr.(type) {
+	case *types.AttributeValueMemberS:
+		return strings.Contains(a.Value, v.Value)
+	case *types.AttributeValueMemberSS:
+		return containsString(a.Value, v.Value)
+	case *types.AttributeValueMemberL:
+		for _, elem := range a.Value {
+			if equalValues(elem, v) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func TestConditionEval(t *testing.T) {
+	item, err := attributevalue.MarshalMap(map[string]interface{}{
+		"id":         "a1",
+		"name":       "Ravenloft",
+		"nameLower":  "ravenloft",
+		"level":      5,
+		"public":     true,
+		"genres":     []string{"horror", "gothic"},
+		"attributes": map[string]interface{}{"class": "cleric", "stats": map[string]interface{}{"wis": 16}},
+	})
+	if err != nil {
+		t.Fatalf("MarshalMap() returned error: %v", err)
+	}
+	item["roles"] = &types.AttributeValueMemberSS{Value: []string{"owner", "editor"}}
+
+	tests := []struct {
+		name string
+		cond *Condition
+		want bool
+	}{
+		{"exists", AttributeExists("name"), true},
+		{"exists missing", AttributeExists("deletedAt"), false},
+		{"not exists", AttributeNotExists("deletedAt"), true},
+		{"not exists present", AttributeNotExists("id"), false},
+		{"equal string", Equal("name", "Ravenloft"), true},
+		{"equal other string", Equal("name", "Eberron"), false},
+		{"equal number", Equal("level", 5), true},
+		{"equal number as float", Equal("level", 5.0), true},
+		{"equal bool", Equal("public", true), true},
+		{"equal other type", Equal("level", "5"), false},
+		{"equal missing", Equal("deletedAt", ""), false},
+		{"not equal", NotEqual("name", "Eberron"), true},
+		{"not equal same", NotEqual("name", "Ravenloft"), false},
+		{"not equal missing", NotEqual("deletedAt", "x"), false},
+		{"less than", LessThan("level", 10), true},
+		{"less than numerically", LessThan("level", 40), true},
+		{"less than equal", LessThanEqual("level", 5), true},
+		{"greater than", GreaterThan("level", 5), false},
+		{"greater than equal", GreaterThanEqual("level", 5), true},
+		{"greater than string", GreaterThan("name", "Eberron"), true},
+		{"compare other type", LessThan("name", 10), false},
+		{"compare missing", GreaterThan("deletedAt", ""), false},
+		{"begins with", BeginsWith("name", "Raven"), true},
+		{"begins with case", BeginsWith("name", "raven"), false},
+		{"begins with number", BeginsWith("level", "5"), false},
+		{"contains", Contains("name", "venlo"), true},
+		{"contains other substring", Contains("name", "vent"), false},
+		{"contains set element", Contains("roles", "editor"), true},
+		{"contains set substring", Contains("roles", "edit"), false},
+		{"contains list element", Contains("genres", "horror"), true},
+		{"contains missing element", Contains("genres", "sci-fi"), false},
+		{"contains fold", ContainsFold("name", "nameLower", "RAVEN"), true},
+		{"contains fold without copy", ContainsFold("id", "idLower", "A1"), false},
+		{"contains fold exact without copy", ContainsFold("id", "idLower", "a1"), true},
+		{"document path", Equal("attributes.class", "cleric"), true},
+		{"nested document path", GreaterThan("attributes.stats.wis", 15), true},
+		{"document path missing", AttributeExists("attributes.race"), false},
+		{"document path through scalar", AttributeExists("name.first"), false},
+		{"and", And(Equal("name", "Ravenloft"), Equal("level", 5)), true},
+		{"and one false", And(Equal("name", "Ravenloft"), Equal("level", 6)), false},
+		{"and skips nil", And(nil, Equal("level", 5), nil), true},
+		{"or", Or(Equal("level", 6), Equal("level", 5)), true},
+		{"or none", Or(Equal("level", 6), Equal("level", 7)), false},
+		{"not", Not(Equal("level", 6)), true},
+		{"not missing", Not(Equal("deletedAt", "x")), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.cond.Eval(item)
+			if err != nil {
+				t.Fatalf("Eval() returned error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Eval() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConditionEvalMissingItem(t *testing.T) {
+	tests := []struct {
+		name string
+		cond *Condition
+		want bool
+	}{
+		{"not exists", AttributeNotExists("id"), true},
+		{"exists", AttributeExists("id"), false},
+		{"equal", Equal("id", "a1"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.cond.Eval(Item{})
+			if err != nil {
+				t.Fatalf("Eval() returned error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Eval() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
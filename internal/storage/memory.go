@@ -0,0 +1,399 @@
+package storage
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	common "github.com/jplindgren/rpg-vault/internal"
+)
+
+// MemoryStore keeps every table in memory. It follows the DynamoDB semantics closely
+// enough to run the whole API offline and in tests, but nothing survives a restart.
+type MemoryStore struct {
+	mu     sync.RWMutex
+	tables map[string]*memoryTable
+}
+
+type memoryTable struct {
+	schema Table
+	items  map[string]Item
+}
+
+func NewMemory(tables ...Table) *MemoryStore {
+	s := &MemoryStore{
+		tables: make(map[string]*memoryTable, len(tables)),
+	}
+
+	for _, t := range tables {
+		s.tables[t.Name] = &memoryTable{
+			schema: t,
+			items:  make(map[string]Item),
+		}
+	}
+
+	return s
+}
+
+func (s *MemoryStore) Put(table string, item interface{}, cond *Condition) error {
+	av, err := attributevalue.MarshalMap(item)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, err := s.table(table)
+	if err != nil {
+		return err
+	}
+
+	pk, err := t.schema.primaryKey(av)
+	if err != nil {
+		return err
+	}
+
+	err = check(cond, t.items[pk])
+	if err != nil {
+		return err
+	}
+
+	t.items[pk] = av
+	return nil
+}
+
+func (s *MemoryStore) Get(table string, key interface{}, out interface{}) error {
+	av, err := attributevalue.MarshalMap(key)
+	if err != nil {
+		return err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	t, err := s.table(table)
+	if err != nil {
+		return err
+	}
+
+	pk, err := t.schema.primaryKey(av)
+	if err != nil {
+		return err
+	}
+
+	item, found := t.items[pk]
+	if !found {
+		return common.ErrorRecordNotFound
+	}
+
+	return attributevalue.UnmarshalMap(item, out)
+}
+
+func (s *MemoryStore) Query(table string, query *Query, out interface{}) error {
+	items, _, err := s.query(table, query, nil, 0)
+	if err != nil {
+		return err
+	}
+
+	return attributevalue.UnmarshalListOfMaps(items, out)
+}
+
+func (s *MemoryStore) QueryPage(table string, query *Query, out interface{}) (Item, error) {
+	items, next, err := s.query(table, query, query.StartKey, query.Limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return next, attributevalue.UnmarshalListOfMaps(items, out)
+}
+
+func (s *MemoryStore) query(table string, query *Query, start Item, limit int) ([]Item, Item, error) {
+	value, err := attributevalue.Marshal(query.Value)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	t, err := s.table(table)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	_, sortKey, err := t.schema.keys(query.Index)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var items []Item
+	for _, item := range t.items {
+		if attr, found := item[query.Key]; !found || !equalValues(attr, value) {
+			continue
+		}
+
+		// Like in DynamoDB, secondary indexes only hold the items that have all the
+		// key attributes of the index.
+		if _, found := item[sortKey]; sortKey != "" && !found {
+			continue
+		}
+
+		items = append(items, item)
+	}
+
+	return t.schema.page(query, items, start, limit)
+}
+
+func (s *MemoryStore) Scan(table string, filter *Condition, out interface{}) error {
+	return s.ScanIndex(table, "", filter, out)
+}
+
+func (s *MemoryStore) ScanIndex(table, index string, filter *Condition, out interface{}) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	t, err := s.table(table)
+	if err != nil {
+		return err
+	}
+
+	partitionKey, sortKey, err := t.schema.keys(index)
+	if err != nil {
+		return err
+	}
+
+	items := make([]Item, 0, len(t.items))
+	for _, item := range t.items {
+		if !inIndex(item, partitionKey, sortKey) {
+			continue
+		}
+
+		if filter != nil {
+			ok, err := filter.Eval(item)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				continue
+			}
+		}
+
+		items = append(items, item)
+	}
+
+	return attributevalue.UnmarshalListOfMaps(items, out)
+}
+
+// inIndex reports whether an item holds the key attributes of an index, so the index
+// holds it.
+func inIndex(item Item, partitionKey, sortKey string) bool {
+	if _, found := item[partitionKey]; !found {
+		return false
+	}
+	if _, found := item[sortKey]; sortKey != "" && !found {
+		return false
+	}
+	return true
+}
+
+func (s *MemoryStore) Update(table string, key interface{}, update *Update) error {
+	av, err := attributevalue.MarshalMap(key)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, err := s.table(table)
+	if err != nil {
+		return err
+	}
+
+	pk, err := t.schema.primaryKey(av)
+	if err != nil {
+		return err
+	}
+
+	item, found := t.items[pk]
+
+	err = check(update.Condition(), item)
+	if err != nil {
+		return err
+	}
+
+	// Updating a missing item creates it, starting from its key.
+	if !found {
+		item = av
+	}
+
+	updated, err := update.Apply(item)
+	if err != nil {
+		return err
+	}
+
+	t.items[pk] = updated
+	return nil
+}
+
+func (s *MemoryStore) Delete(table string, key interface{}) error {
+	av, err := attributevalue.MarshalMap(key)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, err := s.table(table)
+	if err != nil {
+		return err
+	}
+
+	pk, err := t.schema.primaryKey(av)
+	if err != nil {
+		return err
+	}
+
+	delete(t.items, pk)
+	return nil
+}
+
+func (s *MemoryStore) BatchDelete(table string, keys []map[string]string) error {
+	for _, key := range keys {
+		err := s.Delete(table, key)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// TransactWrite checks the conditions of every write before applying any of them,
+// holding the mutex all along.
+func (s *MemoryStore) TransactWrite(writes []Write) error {
+	err := checkWrites(writes)
+	if err != nil {
+		return err
+	}
+
+	type pending struct {
+		table *memoryTable
+		pk    string
+		item  Item // nil for deletes
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seen := make(map[string]bool, len(writes))
+	results := make([]pending, 0, len(writes))
+
+	for _, w := range writes {
+		key := w.Key
+		if w.Item != nil {
+			key = w.Item
+		}
+
+		av, err := attributevalue.MarshalMap(key)
+		if err != nil {
+			return err
+		}
+
+		t, err := s.table(w.Table)
+		if err != nil {
+			return err
+		}
+
+		pk, err := t.schema.primaryKey(av)
+		if err != nil {
+			return err
+		}
+
+		if seen[w.Table+"\x00"+pk] {
+			return fmt.Errorf("storage: a transaction can't write an item of table %q twice", w.Table)
+		}
+		seen[w.Table+"\x00"+pk] = true
+
+		existing, found := t.items[pk]
+
+		err = check(w.condition(), existing)
+		if err != nil {
+			return err
+		}
+
+		var item Item
+		switch {
+		case w.Item != nil:
+			item = av
+		case !w.Delete:
+			// Updating a missing item creates it, starting from its key.
+			if !found {
+				existing = av
+			}
+			item, err = w.Update.Apply(existing)
+			if err != nil {
+				return err
+			}
+		}
+
+		results = append(results, pending{table: t, pk: pk, item: item})
+	}
+
+	for _, r := range results {
+		if r.item == nil {
+			delete(r.table.items, r.pk)
+		} else {
+			r.table.items[r.pk] = r.item
+		}
+	}
+
+	return nil
+}
+
+// table returns the table with the given name. It must be called with the mutex held.
+func (s *MemoryStore) table(name string) (*memoryTable, error) {
+	t, found := s.tables[name]
+	if !found {
+		return nil, fmt.Errorf("storage: unknown table %q", name)
+	}
+	return t, nil
+}
+
+// check returns ErrorConditionFailed when the condition doesn't hold for the stored
+// item, which is nil when there is no such item.
+func check(cond *Condition, item Item) error {
+	if cond == nil {
+		return nil
+	}
+
+	if item == nil {
+		item = Item{}
+	}
+
+	ok, err := cond.Eval(item)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrorConditionFailed
+	}
+
+	return nil
+}
+
+func project(item Item, names []string) Item {
+	if len(names) == 0 {
+		return item
+	}
+
+	projected := make(Item, len(names))
+	for _, name := range names {
+		if value, found := item[name]; found {
+			projected[name] = value
+		}
+	}
+	return projected
+}
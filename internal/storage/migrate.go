@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The migrations are plain SQL files shared by every dialect, applied in the order of
+// their names. Never edit a migration that has been released; add a new one instead.
+//
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// migrate applies the migrations that haven't been applied yet, recording each one in
+// the schema_migrations table.
+func migrate(db *sql.DB, dialect Dialect) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version TEXT NOT NULL PRIMARY KEY,
+		applied_at TEXT NOT NULL
+	)`)
+	if err != nil {
+		return err
+	}
+
+	entries, err := migrations.ReadDir("migrations")
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, len(entries))
+	for i := range entries {
+		names[i] = entries[i].Name()
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		version := strings.TrimSuffix(name, ".sql")
+
+		var applied int
+		err = db.QueryRow(
+			fmt.Sprintf("SELECT COUNT(*) FROM schema_migrations WHERE version = %s", dialect.placeholder(1)),
+			version,
+		).Scan(&applied)
+		if err != nil {
+			return err
+		}
+
+		if applied > 0 {
+			continue
+		}
+
+		script, err := migrations.ReadFile("migrations/" + name)
+		if err != nil {
+			return err
+		}
+
+		err = applyMigration(db, dialect, version, string(script))
+		if err != nil {
+			return fmt.Errorf("storage: migration %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+func applyMigration(db *sql.DB, dialect Dialect, version, script string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range strings.Split(script, ";") {
+		if strings.TrimSpace(stripComments(stmt)) == "" {
+			continue
+		}
+
+		_, err = tx.Exec(stmt)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(
+		fmt.Sprintf("INSERT INTO schema_migrations (version, applied_at) VALUES (%s, %s)",
+			dialect.placeholder(1), dialect.placeholder(2)),
+		version, time.Now().UTC().Format(time.RFC3339),
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func stripComments(stmt string) string {
+	var lines []string
+	for _, line := range strings.Split(stmt, "\n") {
+		if !strings.HasPrefix(strings.TrimSpace(line), "--") {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,50 @@
+-- Every table has a column for each key attribute of the table and of its indexes,
+-- and an "item" column holding the whole item as JSON. The columns must match the
+-- tables described in services.Tables.
+
+CREATE TABLE IF NOT EXISTS "rpg_users" (
+    "email" TEXT NOT NULL,
+    "item" TEXT NOT NULL,
+    PRIMARY KEY ("email")
+);
+
+CREATE TABLE IF NOT EXISTS "rpg_usertokens" (
+    "hash" TEXT NOT NULL,
+    "email" TEXT,
+    "item" TEXT NOT NULL,
+    PRIMARY KEY ("hash")
+);
+
+CREATE INDEX IF NOT EXISTS "rpg_usertokens_email_idx" ON "rpg_usertokens" ("email");
+
+CREATE TABLE IF NOT EXISTS "rpg_permissions" (
+    "email" TEXT NOT NULL,
+    "item" TEXT NOT NULL,
+    PRIMARY KEY ("email")
+);
+
+CREATE TABLE IF NOT EXISTS "rpg_worlds" (
+    "userId" TEXT NOT NULL,
+    "id" TEXT NOT NULL,
+    "item" TEXT NOT NULL,
+    PRIMARY KEY ("userId", "id")
+);
+
+CREATE TABLE IF NOT EXISTS "rpg_world_members" (
+    "worldId" TEXT NOT NULL,
+    "email" TEXT NOT NULL,
+    "item" TEXT NOT NULL,
+    PRIMARY KEY ("worldId", "email")
+);
+
+CREATE INDEX IF NOT EXISTS "rpg_world_members_email_idx" ON "rpg_world_members" ("email", "worldId");
+
+CREATE TABLE IF NOT EXISTS "rpg_characters" (
+    "worldId" TEXT NOT NULL,
+    "id" TEXT NOT NULL,
+    "ownerId" TEXT,
+    "item" TEXT NOT NULL,
+    PRIMARY KEY ("worldId", "id")
+);
+
+CREATE INDEX IF NOT EXISTS "rpg_characters_owner_idx" ON "rpg_characters" ("ownerId", "id");
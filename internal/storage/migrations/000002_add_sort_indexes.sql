@@ -0,0 +1,19 @@
+-- Worlds and characters can be listed sorted by name, creation and update time, using
+-- an index on each attribute. The columns of the existing rows are filled on startup
+-- by the store, from the items.
+
+ALTER TABLE "rpg_worlds" ADD COLUMN "name" TEXT;
+ALTER TABLE "rpg_worlds" ADD COLUMN "createdAt" TEXT;
+ALTER TABLE "rpg_worlds" ADD COLUMN "updatedAt" TEXT;
+
+CREATE INDEX IF NOT EXISTS "rpg_worlds_name_idx" ON "rpg_worlds" ("userId", "name");
+CREATE INDEX IF NOT EXISTS "rpg_worlds_created_at_idx" ON "rpg_worlds" ("userId", "createdAt");
+CREATE INDEX IF NOT EXISTS "rpg_worlds_updated_at_idx" ON "rpg_worlds" ("userId", "updatedAt");
+
+ALTER TABLE "rpg_characters" ADD COLUMN "name" TEXT;
+ALTER TABLE "rpg_characters" ADD COLUMN "createdAt" TEXT;
+ALTER TABLE "rpg_characters" ADD COLUMN "updatedAt" TEXT;
+
+CREATE INDEX IF NOT EXISTS "rpg_characters_name_idx" ON "rpg_characters" ("worldId", "name");
+CREATE INDEX IF NOT EXISTS "rpg_characters_created_at_idx" ON "rpg_characters" ("worldId", "createdAt");
+CREATE INDEX IF NOT EXISTS "rpg_characters_updated_at_idx" ON "rpg_characters" ("worldId", "updatedAt");
@@ -0,0 +1,10 @@
+-- Deleted worlds and characters are moved to the trash, marked with the time they were
+-- deleted, before being purged. The indexes list the trash of a user, newest first.
+
+ALTER TABLE "rpg_worlds" ADD COLUMN "deletedAt" TEXT;
+
+CREATE INDEX IF NOT EXISTS "rpg_worlds_deleted_at_idx" ON "rpg_worlds" ("userId", "deletedAt");
+
+ALTER TABLE "rpg_characters" ADD COLUMN "deletedAt" TEXT;
+
+CREATE INDEX IF NOT EXISTS "rpg_characters_deleted_at_idx" ON "rpg_characters" ("ownerId", "deletedAt");
@@ -0,0 +1,12 @@
+-- The dice rolled in a world are kept in a log, listed newest first through the index
+-- on the time of each roll.
+
+CREATE TABLE IF NOT EXISTS "rpg_rolls" (
+    "worldId" TEXT NOT NULL,
+    "id" TEXT NOT NULL,
+    "rolledAt" TEXT,
+    "item" TEXT NOT NULL,
+    PRIMARY KEY ("worldId", "id")
+);
+
+CREATE INDEX IF NOT EXISTS "rpg_rolls_rolled_at_idx" ON "rpg_rolls" ("worldId", "rolledAt");
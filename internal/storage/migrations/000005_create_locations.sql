@@ -0,0 +1,16 @@
+-- Locations are listed like characters, sorted by name, creation and update time using
+-- an index on each attribute.
+
+CREATE TABLE IF NOT EXISTS "rpg_locations" (
+    "worldId" TEXT NOT NULL,
+    "id" TEXT NOT NULL,
+    "name" TEXT,
+    "createdAt" TEXT,
+    "updatedAt" TEXT,
+    "item" TEXT NOT NULL,
+    PRIMARY KEY ("worldId", "id")
+);
+
+CREATE INDEX IF NOT EXISTS "rpg_locations_name_idx" ON "rpg_locations" ("worldId", "name");
+CREATE INDEX IF NOT EXISTS "rpg_locations_created_at_idx" ON "rpg_locations" ("worldId", "createdAt");
+CREATE INDEX IF NOT EXISTS "rpg_locations_updated_at_idx" ON "rpg_locations" ("worldId", "updatedAt");
@@ -0,0 +1,27 @@
+-- Item definitions are listed like locations, sorted by name, creation and update time
+-- using an index on each attribute. Inventory entries belong to a world, and are read
+-- per character.
+
+CREATE TABLE IF NOT EXISTS "rpg_items" (
+    "worldId" TEXT NOT NULL,
+    "id" TEXT NOT NULL,
+    "name" TEXT,
+    "createdAt" TEXT,
+    "updatedAt" TEXT,
+    "item" TEXT NOT NULL,
+    PRIMARY KEY ("worldId", "id")
+);
+
+CREATE INDEX IF NOT EXISTS "rpg_items_name_idx" ON "rpg_items" ("worldId", "name");
+CREATE INDEX IF NOT EXISTS "rpg_items_created_at_idx" ON "rpg_items" ("worldId", "createdAt");
+CREATE INDEX IF NOT EXISTS "rpg_items_updated_at_idx" ON "rpg_items" ("worldId", "updatedAt");
+
+CREATE TABLE IF NOT EXISTS "rpg_inventory" (
+    "worldId" TEXT NOT NULL,
+    "id" TEXT NOT NULL,
+    "characterId" TEXT,
+    "item" TEXT NOT NULL,
+    PRIMARY KEY ("worldId", "id")
+);
+
+CREATE INDEX IF NOT EXISTS "rpg_inventory_character_id_idx" ON "rpg_inventory" ("characterId", "id");
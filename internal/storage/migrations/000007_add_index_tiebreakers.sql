@@ -0,0 +1,46 @@
+-- Queries order the items with the same sort key by their primary key, so the pages
+-- are stable. Adding the primary key columns to the indexes lets the database read a
+-- page straight from the index, in order, instead of sorting the whole partition.
+
+DROP INDEX IF EXISTS "rpg_usertokens_email_idx";
+CREATE INDEX IF NOT EXISTS "rpg_usertokens_email_idx" ON "rpg_usertokens" ("email", "hash");
+
+DROP INDEX IF EXISTS "rpg_worlds_name_idx";
+DROP INDEX IF EXISTS "rpg_worlds_created_at_idx";
+DROP INDEX IF EXISTS "rpg_worlds_updated_at_idx";
+DROP INDEX IF EXISTS "rpg_worlds_deleted_at_idx";
+CREATE INDEX IF NOT EXISTS "rpg_worlds_name_idx" ON "rpg_worlds" ("userId", "name", "id");
+CREATE INDEX IF NOT EXISTS "rpg_worlds_created_at_idx" ON "rpg_worlds" ("userId", "createdAt", "id");
+CREATE INDEX IF NOT EXISTS "rpg_worlds_updated_at_idx" ON "rpg_worlds" ("userId", "updatedAt", "id");
+CREATE INDEX IF NOT EXISTS "rpg_worlds_deleted_at_idx" ON "rpg_worlds" ("userId", "deletedAt", "id");
+
+DROP INDEX IF EXISTS "rpg_characters_owner_idx";
+DROP INDEX IF EXISTS "rpg_characters_name_idx";
+DROP INDEX IF EXISTS "rpg_characters_created_at_idx";
+DROP INDEX IF EXISTS "rpg_characters_updated_at_idx";
+DROP INDEX IF EXISTS "rpg_characters_deleted_at_idx";
+CREATE INDEX IF NOT EXISTS "rpg_characters_owner_idx" ON "rpg_characters" ("ownerId", "id", "worldId");
+CREATE INDEX IF NOT EXISTS "rpg_characters_name_idx" ON "rpg_characters" ("worldId", "name", "id");
+CREATE INDEX IF NOT EXISTS "rpg_characters_created_at_idx" ON "rpg_characters" ("worldId", "createdAt", "id");
+CREATE INDEX IF NOT EXISTS "rpg_characters_updated_at_idx" ON "rpg_characters" ("worldId", "updatedAt", "id");
+CREATE INDEX IF NOT EXISTS "rpg_characters_deleted_at_idx" ON "rpg_characters" ("ownerId", "deletedAt", "worldId", "id");
+
+DROP INDEX IF EXISTS "rpg_rolls_rolled_at_idx";
+CREATE INDEX IF NOT EXISTS "rpg_rolls_rolled_at_idx" ON "rpg_rolls" ("worldId", "rolledAt", "id");
+
+DROP INDEX IF EXISTS "rpg_locations_name_idx";
+DROP INDEX IF EXISTS "rpg_locations_created_at_idx";
+DROP INDEX IF EXISTS "rpg_locations_updated_at_idx";
+CREATE INDEX IF NOT EXISTS "rpg_locations_name_idx" ON "rpg_locations" ("worldId", "name", "id");
+CREATE INDEX IF NOT EXISTS "rpg_locations_created_at_idx" ON "rpg_locations" ("worldId", "createdAt", "id");
+CREATE INDEX IF NOT EXISTS "rpg_locations_updated_at_idx" ON "rpg_locations" ("worldId", "updatedAt", "id");
+
+DROP INDEX IF EXISTS "rpg_items_name_idx";
+DROP INDEX IF EXISTS "rpg_items_created_at_idx";
+DROP INDEX IF EXISTS "rpg_items_updated_at_idx";
+CREATE INDEX IF NOT EXISTS "rpg_items_name_idx" ON "rpg_items" ("worldId", "name", "id");
+CREATE INDEX IF NOT EXISTS "rpg_items_created_at_idx" ON "rpg_items" ("worldId", "createdAt", "id");
+CREATE INDEX IF NOT EXISTS "rpg_items_updated_at_idx" ON "rpg_items" ("worldId", "updatedAt", "id");
+
+DROP INDEX IF EXISTS "rpg_inventory_character_id_idx";
+CREATE INDEX IF NOT EXISTS "rpg_inventory_character_id_idx" ON "rpg_inventory" ("characterId", "id", "worldId");
@@ -0,0 +1,788 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	common "github.com/jplindgren/rpg-vault/internal"
+
+	// Register the SQLite (pure Go, no cgo) and PostgreSQL drivers.
+	_ "github.com/lib/pq"
+	_ "modernc.org/sqlite"
+)
+
+// Dialect holds the few differences between the SQL databases we support.
+type Dialect struct {
+	driver string
+	// lockRow is appended to the queries reading an item that is about to be updated.
+	lockRow string
+	// placeholder returns the n-th (1-based) query parameter.
+	placeholder func(n int) string
+}
+
+var (
+	SQLite = Dialect{
+		driver:      "sqlite",
+		placeholder: func(n int) string { return "?" },
+	}
+	Postgres = Dialect{
+		driver:      "postgres",
+		lockRow:     " FOR UPDATE",
+		placeholder: func(n int) string { return fmt.Sprintf("$%d", n) },
+	}
+)
+
+// SQLStore keeps every table in a SQL database. Each table has a column for every key
+// attribute of the table and of its indexes, used to look items up, and an "item"
+// column holding the whole item as JSON. The tables themselves are created by the
+// migrations.
+type SQLStore struct {
+	db      *sql.DB
+	tx      *sql.Tx
+	dialect Dialect
+	tables  map[string]Table
+}
+
+// querier is implemented by both *sql.DB and *sql.Tx.
+type querier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// OpenSQL connects to the database and applies the pending migrations.
+func OpenSQL(dialect Dialect, dsn string, tables ...Table) (*SQLStore, error) {
+	db, err := sql.Open(dialect.driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	if dialect.driver == SQLite.driver {
+		// SQLite only allows a single writer, so use a single connection and wait for
+		// locks instead of failing right away.
+		db.SetMaxOpenConns(1)
+
+		_, err = db.Exec("PRAGMA busy_timeout = 5000")
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+
+	err = db.Ping()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	err = migrate(db, dialect)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	s := &SQLStore{
+		db:      db,
+		dialect: dialect,
+		tables:  make(map[string]Table, len(tables)),
+	}
+
+	for _, t := range tables {
+		s.tables[t.Name] = t
+
+		err = s.reindex(t)
+		if err != nil {
+			db.Close()
+			return nil, fmt.Errorf("storage: reindexing table %q: %w", t.Name, err)
+		}
+	}
+
+	return s, nil
+}
+
+// reindex fills the index columns left empty for the rows written before a migration
+// added them, from the attributes in the item column. Rows without the attributes
+// keep empty columns, and are left out of the index like in DynamoDB.
+func (s *SQLStore) reindex(t Table) error {
+	var columns, empty []string
+	for _, column := range indexedColumns(t) {
+		if !containsString(keyColumns(t), column) {
+			columns = append(columns, column)
+			empty = append(empty, quote(column)+" IS NULL")
+		}
+	}
+
+	if len(columns) == 0 {
+		return nil
+	}
+
+	rows, err := s.db.Query(fmt.Sprintf("SELECT item, %s FROM %s WHERE %s",
+		strings.Join(quoteAll(columns), ", "), quote(t.Name), strings.Join(empty, " OR ")))
+	if err != nil {
+		return err
+	}
+
+	// Read every row before writing, as SQLite only has one connection.
+	var stale []Item
+	for rows.Next() {
+		var data string
+		values := make([]sql.NullString, len(columns))
+		dest := []interface{}{&data}
+		for i := range values {
+			dest = append(dest, &values[i])
+		}
+
+		err = rows.Scan(dest...)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+
+		item, err := decodeItem([]byte(data))
+		if err != nil {
+			rows.Close()
+			return err
+		}
+
+		for i, column := range columns {
+			if _, ok := columnValue(item[column]); ok && !values[i].Valid {
+				stale = append(stale, item)
+				break
+			}
+		}
+	}
+
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return err
+	}
+
+	for _, item := range stale {
+		err = s.write(s.db, t, item, false)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *SQLStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *SQLStore) Put(table string, item interface{}, cond *Condition) error {
+	av, err := attributevalue.MarshalMap(item)
+	if err != nil {
+		return err
+	}
+
+	t, err := s.table(table)
+	if err != nil {
+		return err
+	}
+
+	if cond == nil {
+		return s.write(s.querier(), t, av, false)
+	}
+
+	return s.transact(func(q querier) error {
+		existing, err := s.load(q, t, av, true)
+		if err != nil {
+			return err
+		}
+
+		err = check(cond, existing)
+		if err != nil {
+			return err
+		}
+
+		return s.write(q, t, av, existing == nil)
+	})
+}
+
+func (s *SQLStore) Get(table string, key interface{}, out interface{}) error {
+	av, err := attributevalue.MarshalMap(key)
+	if err != nil {
+		return err
+	}
+
+	t, err := s.table(table)
+	if err != nil {
+		return err
+	}
+
+	item, err := s.load(s.querier(), t, av, false)
+	if err != nil {
+		return err
+	}
+
+	if item == nil {
+		return common.ErrorRecordNotFound
+	}
+
+	return attributevalue.UnmarshalMap(item, out)
+}
+
+func (s *SQLStore) Query(table string, query *Query, out interface{}) error {
+	items, _, err := s.query(table, query, nil, 0)
+	if err != nil {
+		return err
+	}
+
+	return attributevalue.UnmarshalListOfMaps(items, out)
+}
+
+func (s *SQLStore) QueryPage(table string, query *Query, out interface{}) (Item, error) {
+	items, next, err := s.query(table, query, query.StartKey, query.Limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return next, attributevalue.UnmarshalListOfMaps(items, out)
+}
+
+// query reads a page of a partition. The database orders the rows by the sort key of
+// the query and then by the primary key, like Table.page does for the other backends,
+// and starts the page after the start key, both served by the indexes on the key
+// columns. The filter is applied as the rows are read, which stops as soon as the page
+// is full. Key columns hold text, so they are ordered by the collation of the database,
+// which for PostgreSQL may differ from the byte order used by DynamoDB.
+func (s *SQLStore) query(table string, query *Query, start Item, limit int) ([]Item, Item, error) {
+	value, err := attributevalue.Marshal(query.Value)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	t, err := s.table(table)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	partitionKey, sortKey, err := t.keys(query.Index)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	partition, ok := columnValue(value)
+	if !ok {
+		return nil, nil, fmt.Errorf("storage: invalid partition key value for %q", query.Key)
+	}
+
+	// Within the partition, items are ordered by the sort key and then by the primary
+	// key columns that aren't already fixed or ordered.
+	var order []string
+	if sortKey != "" {
+		order = append(order, sortKey)
+	}
+	for _, column := range keyColumns(t) {
+		if column != query.Key && column != sortKey {
+			order = append(order, column)
+		}
+	}
+
+	args := []interface{}{partition}
+	stmt := fmt.Sprintf("SELECT item FROM %s WHERE %s = %s",
+		quote(t.Name), quote(query.Key), s.dialect.placeholder(1))
+
+	if sortKey != "" {
+		stmt += fmt.Sprintf(" AND %s IS NOT NULL", quote(sortKey))
+	}
+
+	direction, after := "", ">"
+	if query.Descending {
+		direction, after = " DESC", "<"
+	}
+
+	if start != nil && len(order) > 0 {
+		placeholders := make([]string, len(order))
+		for i, column := range order {
+			v, ok := columnValue(start[column])
+			if !ok {
+				return nil, nil, fmt.Errorf("storage: start key is missing attribute %q", column)
+			}
+
+			args = append(args, v)
+			placeholders[i] = s.dialect.placeholder(len(args))
+		}
+
+		stmt += fmt.Sprintf(" AND (%s) %s (%s)",
+			strings.Join(quoteAll(order), ", "), after, strings.Join(placeholders, ", "))
+	}
+
+	if len(order) > 0 {
+		columns := quoteAll(order)
+		for i := range columns {
+			columns[i] += direction
+		}
+		stmt += " ORDER BY " + strings.Join(columns, ", ")
+	}
+
+	// Without a filter every row makes the page, so only read one more row than the
+	// page holds, to know whether there is a next page.
+	if limit > 0 && query.Filter == nil {
+		stmt += fmt.Sprintf(" LIMIT %d", limit+1)
+	}
+
+	rows, err := s.querier().Query(stmt, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var items []Item
+	for rows.Next() {
+		var data string
+		err = rows.Scan(&data)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		item, err := decodeItem([]byte(data))
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if query.Filter != nil {
+			ok, err := query.Filter.Eval(item)
+			if err != nil {
+				return nil, nil, err
+			}
+			if !ok {
+				continue
+			}
+		}
+
+		items = append(items, project(item, query.Projection))
+
+		if limit > 0 && len(items) == limit {
+			if rows.Next() {
+				return items, t.lastKey(item, partitionKey, sortKey), nil
+			}
+			break
+		}
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return items, nil, nil
+}
+
+func (s *SQLStore) Scan(table string, filter *Condition, out interface{}) error {
+	return s.ScanIndex(table, "", filter, out)
+}
+
+// ScanIndex leaves out the rows whose index columns are empty, which the database
+// reads from the index of the columns.
+func (s *SQLStore) ScanIndex(table, index string, filter *Condition, out interface{}) error {
+	t, err := s.table(table)
+	if err != nil {
+		return err
+	}
+
+	partitionKey, sortKey, err := t.keys(index)
+	if err != nil {
+		return err
+	}
+
+	stmt := fmt.Sprintf("SELECT item FROM %s", quote(t.Name))
+	if index != "" {
+		stmt += fmt.Sprintf(" WHERE %s IS NOT NULL", quote(partitionKey))
+		if sortKey != "" {
+			stmt += fmt.Sprintf(" AND %s IS NOT NULL", quote(sortKey))
+		}
+	}
+
+	rows, err := s.querier().Query(stmt)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var items []Item
+	for rows.Next() {
+		var data string
+		err = rows.Scan(&data)
+		if err != nil {
+			return err
+		}
+
+		item, err := decodeItem([]byte(data))
+		if err != nil {
+			return err
+		}
+
+		if filter != nil {
+			ok, err := filter.Eval(item)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				continue
+			}
+		}
+
+		items = append(items, item)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return err
+	}
+
+	return attributevalue.UnmarshalListOfMaps(items, out)
+}
+
+func (s *SQLStore) Update(table string, key interface{}, update *Update) error {
+	av, err := attributevalue.MarshalMap(key)
+	if err != nil {
+		return err
+	}
+
+	t, err := s.table(table)
+	if err != nil {
+		return err
+	}
+
+	return s.transact(func(q querier) error {
+		existing, err := s.load(q, t, av, true)
+		if err != nil {
+			return err
+		}
+
+		err = check(update.Condition(), existing)
+		if err != nil {
+			return err
+		}
+
+		// Updating a missing item creates it, starting from its key.
+		item := existing
+		if item == nil {
+			item = av
+		}
+
+		updated, err := update.Apply(item)
+		if err != nil {
+			return err
+		}
+
+		return s.write(q, t, updated, existing == nil && update.Condition() != nil)
+	})
+}
+
+func (s *SQLStore) Delete(table string, key interface{}) error {
+	av, err := attributevalue.MarshalMap(key)
+	if err != nil {
+		return err
+	}
+
+	t, err := s.table(table)
+	if err != nil {
+		return err
+	}
+
+	where, args, err := s.whereKey(t, av)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.querier().Exec(fmt.Sprintf("DELETE FROM %s WHERE %s", quote(t.Name), where), args...)
+	return err
+}
+
+func (s *SQLStore) BatchDelete(table string, keys []map[string]string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	return s.Transact(func(tx Store) error {
+		for _, key := range keys {
+			err := tx.Delete(table, key)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// TransactWrite applies the writes inside a single database transaction, locking each
+// item while its condition is checked.
+func (s *SQLStore) TransactWrite(writes []Write) error {
+	err := checkWrites(writes)
+	if err != nil {
+		return err
+	}
+
+	return s.transact(func(q querier) error {
+		seen := make(map[string]bool, len(writes))
+
+		for _, w := range writes {
+			key := w.Key
+			if w.Item != nil {
+				key = w.Item
+			}
+
+			av, err := attributevalue.MarshalMap(key)
+			if err != nil {
+				return err
+			}
+
+			t, err := s.table(w.Table)
+			if err != nil {
+				return err
+			}
+
+			pk, err := t.primaryKey(av)
+			if err != nil {
+				return err
+			}
+
+			if seen[w.Table+"\x00"+pk] {
+				return fmt.Errorf("storage: a transaction can't write an item of table %q twice", w.Table)
+			}
+			seen[w.Table+"\x00"+pk] = true
+
+			existing, err := s.load(q, t, av, true)
+			if err != nil {
+				return err
+			}
+
+			err = check(w.condition(), existing)
+			if err != nil {
+				return err
+			}
+
+			switch {
+			case w.Item != nil:
+				err = s.write(q, t, av, existing == nil && w.Cond != nil)
+			case w.Delete:
+				where, args, whereErr := s.whereKey(t, av)
+				if whereErr != nil {
+					return whereErr
+				}
+				_, err = q.Exec(fmt.Sprintf("DELETE FROM %s WHERE %s", quote(t.Name), where), args...)
+			default:
+				item := existing
+				if item == nil {
+					item = av
+				}
+
+				var updated Item
+				updated, err = w.Update.Apply(item)
+				if err != nil {
+					return err
+				}
+				err = s.write(q, t, updated, existing == nil && w.Update.Condition() != nil)
+			}
+
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Transact runs fn inside a database transaction. Calling it from a store that is
+// already bound to a transaction simply reuses it.
+func (s *SQLStore) Transact(fn func(tx Store) error) error {
+	if s.tx != nil {
+		return fn(s)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	err = fn(&SQLStore{db: s.db, tx: tx, dialect: s.dialect, tables: s.tables})
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// transact runs fn with the current transaction, or inside a new one.
+func (s *SQLStore) transact(fn func(q querier) error) error {
+	return s.Transact(func(tx Store) error {
+		return fn(tx.(*SQLStore).tx)
+	})
+}
+
+func (s *SQLStore) querier() querier {
+	if s.tx != nil {
+		return s.tx
+	}
+	return s.db
+}
+
+func (s *SQLStore) table(name string) (Table, error) {
+	t, found := s.tables[name]
+	if !found {
+		return Table{}, fmt.Errorf("storage: unknown table %q", name)
+	}
+	return t, nil
+}
+
+// load reads the item with the given key, returning nil when there is no such item.
+func (s *SQLStore) load(q querier, t Table, key Item, lock bool) (Item, error) {
+	where, args, err := s.whereKey(t, key)
+	if err != nil {
+		return nil, err
+	}
+
+	stmt := fmt.Sprintf("SELECT item FROM %s WHERE %s", quote(t.Name), where)
+	if lock {
+		stmt += s.dialect.lockRow
+	}
+
+	var data string
+	err = q.QueryRow(stmt, args...).Scan(&data)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil
+		default:
+			return nil, err
+		}
+	}
+
+	return decodeItem([]byte(data))
+}
+
+// write inserts or replaces an item. With insertOnly, an item that was created in the
+// meantime is left alone and ErrorConditionFailed is returned instead.
+func (s *SQLStore) write(q querier, t Table, item Item, insertOnly bool) error {
+	data, err := encodeItem(item)
+	if err != nil {
+		return err
+	}
+
+	columns := indexedColumns(t)
+	names := make([]string, 0, len(columns)+1)
+	placeholders := make([]string, 0, len(columns)+1)
+	updates := make([]string, 0, len(columns)+1)
+	args := make([]interface{}, 0, len(columns)+1)
+
+	for _, column := range append(columns, "item") {
+		if column == "item" {
+			args = append(args, string(data))
+		} else if value, ok := columnValue(item[column]); ok {
+			args = append(args, value)
+		} else {
+			args = append(args, nil)
+		}
+
+		names = append(names, quote(column))
+		placeholders = append(placeholders, s.dialect.placeholder(len(args)))
+		updates = append(updates, fmt.Sprintf("%s = excluded.%s", quote(column), quote(column)))
+	}
+
+	conflict := "DO UPDATE SET " + strings.Join(updates, ", ")
+	if insertOnly {
+		conflict = "DO NOTHING"
+	}
+
+	stmt := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) ON CONFLICT (%s) %s",
+		quote(t.Name),
+		strings.Join(names, ", "),
+		strings.Join(placeholders, ", "),
+		strings.Join(quoteAll(keyColumns(t)), ", "),
+		conflict,
+	)
+
+	res, err := q.Exec(stmt, args...)
+	if err != nil {
+		return err
+	}
+
+	if insertOnly {
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return ErrorConditionFailed
+		}
+	}
+
+	return nil
+}
+
+func (s *SQLStore) whereKey(t Table, key Item) (string, []interface{}, error) {
+	var conds []string
+	var args []interface{}
+
+	for _, column := range keyColumns(t) {
+		value, ok := columnValue(key[column])
+		if !ok {
+			return "", nil, fmt.Errorf("storage: key of table %q is missing attribute %q", t.Name, column)
+		}
+
+		args = append(args, value)
+		conds = append(conds, fmt.Sprintf("%s = %s", quote(column), s.dialect.placeholder(len(args))))
+	}
+
+	return strings.Join(conds, " AND "), args, nil
+}
+
+// keyColumns returns the primary key columns of a table.
+func keyColumns(t Table) []string {
+	if t.SortKey == "" {
+		return []string{t.PartitionKey}
+	}
+	return []string{t.PartitionKey, t.SortKey}
+}
+
+// indexedColumns returns the key columns of a table and of its indexes.
+func indexedColumns(t Table) []string {
+	columns := keyColumns(t)
+	for _, idx := range t.Indexes {
+		for _, column := range []string{idx.PartitionKey, idx.SortKey} {
+			if column != "" && !containsString(columns, column) {
+				columns = append(columns, column)
+			}
+		}
+	}
+	return columns
+}
+
+// columnValue converts a key attribute to the text stored in its column.
+func columnValue(av types.AttributeValue) (string, bool) {
+	switch v := av.(type) {
+	case *types.AttributeValueMemberS:
+		return v.Value, true
+	case *types.AttributeValueMemberN:
+		return v.Value, true
+	case *types.AttributeValueMemberB:
+		return hex.EncodeToString(v.Value), true
+	}
+	return "", false
+}
+
+// quote quotes an identifier, which keeps the camelCase attribute names intact in
+// PostgreSQL.
+func quote(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+func quoteAll(names []string) []string {
+	quoted := make([]string, len(names))
+	for i := range names {
+		quoted[i] = quote(names[i])
+	}
+	return quoted
+}
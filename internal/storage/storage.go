@@ -0,0 +1,261 @@
+package storage
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// ErrorConditionFailed is returned when the condition of a put or an update doesn't
+// hold for the stored item.
+var ErrorConditionFailed = errors.New("condition failed")
+
+// Item is a stored record. Every backend uses the DynamoDB attribute value
+// representation, so the dynamodbav struct tags work the same way everywhere.
+type Item = map[string]types.AttributeValue
+
+// Store is implemented by every storage backend. Items are marshalled with the
+// attributevalue package, and lookups that find nothing return
+// common.ErrorRecordNotFound.
+type Store interface {
+	// Put creates or replaces an item. When cond is not nil, the item is only written
+	// if the condition holds for the stored item, otherwise ErrorConditionFailed is
+	// returned.
+	Put(table string, item interface{}, cond *Condition) error
+	// Get reads the item with the given key into out.
+	Get(table string, key interface{}, out interface{}) error
+	// Query reads every item matching the query into out, which must be a pointer to
+	// a slice.
+	Query(table string, query *Query, out interface{}) error
+	// QueryPage reads up to query.Limit matching items into out, starting after
+	// query.StartKey, and returns the key to start the next page from, or nil when
+	// there are no more items.
+	QueryPage(table string, query *Query, out interface{}) (Item, error)
+	// Scan reads every item of the table passing the filter, which may be nil, into out,
+	// which must be a pointer to a slice. It's meant for maintenance tasks, like
+	// rebuilding the search index on startup.
+	Scan(table string, filter *Condition, out interface{}) error
+	// ScanIndex is like Scan, but only reads the items in a secondary index. Like in
+	// DynamoDB, indexes are sparse: the items missing a key attribute of the index
+	// aren't in it.
+	ScanIndex(table, index string, filter *Condition, out interface{}) error
+	// Update applies the update to the item with the given key, creating it when it
+	// doesn't exist yet.
+	Update(table string, key interface{}, update *Update) error
+	Delete(table string, key interface{}) error
+	BatchDelete(table string, keys []map[string]string) error
+	// TransactWrite applies the writes atomically, on every backend: either all of them
+	// are applied or, when one of their conditions doesn't hold, none is and
+	// ErrorConditionFailed is returned. Like in DynamoDB, a transaction writes each item
+	// at most once, and at most MaxTransactWrites items.
+	TransactWrite(writes []Write) error
+}
+
+// MaxTransactWrites is the most writes a TransactWrite can apply, the DynamoDB limit.
+const MaxTransactWrites = 100
+
+// Write is one of the writes of a TransactWrite. It puts Item when it is set, deletes
+// the item with Key when Delete is set, and applies Update to the item with Key
+// otherwise. Cond is the condition of puts and deletes, updates carry their own.
+type Write struct {
+	Table  string
+	Item   interface{}
+	Key    interface{}
+	Update *Update
+	Delete bool
+	Cond   *Condition
+}
+
+// condition returns the condition that must hold for the write to be applied.
+func (w Write) condition() *Condition {
+	if w.Item == nil && !w.Delete {
+		return w.Update.Condition()
+	}
+	return w.Cond
+}
+
+// checkWrites rejects the transactions DynamoDB would reject, so every backend behaves
+// the same.
+func checkWrites(writes []Write) error {
+	if len(writes) > MaxTransactWrites {
+		return fmt.Errorf("storage: a transaction can't write more than %d items", MaxTransactWrites)
+	}
+	return nil
+}
+
+// Transactor is implemented by the stores that can apply several operations
+// atomically.
+type Transactor interface {
+	// Transact runs fn with a store bound to a transaction, which is committed when fn
+	// returns nil and rolled back otherwise.
+	Transact(fn func(tx Store) error) error
+}
+
+// Transact runs fn inside a transaction when the store supports them. Otherwise the
+// operations of fn are applied one after the other.
+func Transact(s Store, fn func(tx Store) error) error {
+	if t, ok := s.(Transactor); ok {
+		return t.Transact(fn)
+	}
+	return fn(s)
+}
+
+// Table describes the keys and secondary indexes of a table.
+type Table struct {
+	Name         string
+	PartitionKey string
+	SortKey      string
+	Indexes      []Index
+	// BinaryKeys lists the key attributes holding binary values. The other key
+	// attributes hold strings.
+	BinaryKeys []string
+	// TTLAttribute, when set, names the attribute holding the Unix time after which
+	// DynamoDB may delete the item.
+	TTLAttribute string
+}
+
+type Index struct {
+	Name         string
+	PartitionKey string
+	SortKey      string
+}
+
+// Query selects the items of a partition, either from the table itself or from one of
+// its secondary indexes.
+type Query struct {
+	Index string
+	// Key and Value select the partition.
+	Key   string
+	Value interface{}
+	// Filter, when set, drops the items for which the condition doesn't hold.
+	Filter *Condition
+	// Projection, when set, limits the attributes that are read.
+	Projection []string
+	// Descending reverses the order of the results, which are otherwise sorted by the
+	// sort key in ascending order.
+	Descending bool
+	// Limit and StartKey select a page of the results. They are only used by
+	// QueryPage.
+	Limit    int
+	StartKey Item
+}
+
+// keys returns the partition and sort key of the table or of the index used by the
+// query.
+func (t Table) keys(index string) (string, string, error) {
+	if index == "" {
+		return t.PartitionKey, t.SortKey, nil
+	}
+
+	for _, idx := range t.Indexes {
+		if idx.Name == index {
+			return idx.PartitionKey, idx.SortKey, nil
+		}
+	}
+
+	return "", "", fmt.Errorf("storage: table %q has no index %q", t.Name, index)
+}
+
+// primaryKey encodes the key attributes of an item as a string, which is used to
+// identify the item by the backends that don't have native keys.
+func (t Table) primaryKey(item Item) (string, error) {
+	names := []string{t.PartitionKey}
+	if t.SortKey != "" {
+		names = append(names, t.SortKey)
+	}
+
+	parts := make([]string, len(names))
+	for i, name := range names {
+		var part string
+
+		switch v := item[name].(type) {
+		case *types.AttributeValueMemberS:
+			part = "S" + v.Value
+		case *types.AttributeValueMemberN:
+			part = "N" + v.Value
+		case *types.AttributeValueMemberB:
+			part = "B" + hex.EncodeToString(v.Value)
+		default:
+			return "", fmt.Errorf("storage: item of table %q is missing key attribute %q", t.Name, name)
+		}
+
+		parts[i] = part
+	}
+
+	return strings.Join(parts, "\x00"), nil
+}
+
+// page sorts the items of a partition by the sort key of the query, and returns the
+// ones following start which pass the filter, up to limit, along with
+// the key of the last one when there are more items. It's how the backends without
+// native pagination page through their results. Items with the same sort key are
+// ordered by their primary key, so the order is stable between pages.
+func (t Table) page(query *Query, items []Item, start Item, limit int) ([]Item, Item, error) {
+	partitionKey, sortKey, err := t.keys(query.Index)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	compare := func(a, b Item) int {
+		cmp := 0
+		if sortKey != "" {
+			cmp, _ = compareValues(a[sortKey], b[sortKey])
+		}
+
+		if cmp == 0 {
+			pa, _ := t.primaryKey(a)
+			pb, _ := t.primaryKey(b)
+			cmp = strings.Compare(pa, pb)
+		}
+
+		if query.Descending {
+			return -cmp
+		}
+		return cmp
+	}
+
+	sort.SliceStable(items, func(i, j int) bool {
+		return compare(items[i], items[j]) < 0
+	})
+
+	var results []Item
+	for i, item := range items {
+		if start != nil && compare(item, start) <= 0 {
+			continue
+		}
+
+		if query.Filter != nil {
+			ok, err := query.Filter.Eval(item)
+			if err != nil {
+				return nil, nil, err
+			}
+			if !ok {
+				continue
+			}
+		}
+
+		results = append(results, project(item, query.Projection))
+
+		if limit > 0 && len(results) == limit && i < len(items)-1 {
+			return results, t.lastKey(item, partitionKey, sortKey), nil
+		}
+	}
+
+	return results, nil, nil
+}
+
+// lastKey returns the key a page ending with item continues from. Like DynamoDB's
+// LastEvaluatedKey, it holds the attributes of the primary key and of the index.
+func (t Table) lastKey(item Item, partitionKey, sortKey string) Item {
+	last := Item{}
+	for _, name := range []string{t.PartitionKey, t.SortKey, partitionKey, sortKey} {
+		if attr, found := item[name]; name != "" && found {
+			last[name] = attr
+		}
+	}
+	return last
+}
@@ -0,0 +1,253 @@
+package storage
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+var testItemsTable = Table{Name: "rpg_items", PartitionKey: "worldId", SortKey: "id", Indexes: []Index{
+	{Name: "name-index", PartitionKey: "worldId", SortKey: "name"},
+	{Name: "createdAt-index", PartitionKey: "worldId", SortKey: "createdAt"},
+}}
+
+type testItem struct {
+	WorldId   string `dynamodbav:"worldId"`
+	Id        string `dynamodbav:"id"`
+	Name      string `dynamodbav:"name"`
+	Rarity    string `dynamodbav:"rarity,omitempty"`
+	CreatedAt string `dynamodbav:"createdAt,omitempty"`
+}
+
+// testStores returns every store that can be tested without a server, holding the
+// items table.
+func testStores(t *testing.T) map[string]Store {
+	sqlite, err := OpenSQL(SQLite, filepath.Join(t.TempDir(), "test.db"), testItemsTable)
+	if err != nil {
+		t.Fatalf("OpenSQL() returned error: %v", err)
+	}
+	t.Cleanup(func() { sqlite.Close() })
+
+	return map[string]Store{
+		"memory": NewMemory(testItemsTable),
+		"sqlite": sqlite,
+	}
+}
+
+func TestQueryPage(t *testing.T) {
+	// Names repeat, so the pages of the name-index must break ties on the id. Some
+	// items have no createdAt, and are left out of that index.
+	var items []testItem
+	for i := 0; i < 11; i++ {
+		item := testItem{
+			WorldId: "w1",
+			Id:      fmt.Sprintf("i%02d", (i*7)%11),
+			Name:    []string{"rope", "torch", "dagger"}[i%3],
+			Rarity:  []string{"common", "rare"}[i%2],
+		}
+		if i%4 != 0 {
+			item.CreatedAt = fmt.Sprintf("2024-01-%02d", 20-i)
+		}
+		items = append(items, item)
+	}
+	others := []testItem{
+		{WorldId: "w2", Id: "i00", Name: "rope", Rarity: "common", CreatedAt: "2024-01-01"},
+		{WorldId: "w0", Id: "i99", Name: "torch", Rarity: "rare", CreatedAt: "2024-01-01"},
+	}
+
+	byId := func(a, b testItem) bool { return a.Id < b.Id }
+	byName := func(a, b testItem) bool {
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.Id < b.Id
+	}
+	byCreatedAt := func(a, b testItem) bool { return a.CreatedAt < b.CreatedAt }
+
+	tests := []struct {
+		name       string
+		query      Query
+		keep       func(testItem) bool
+		less       func(a, b testItem) bool
+		descending bool
+	}{
+		{"table", Query{}, nil, byId, false},
+		{"table descending", Query{Descending: true}, nil, byId, true},
+		{"index with ties", Query{Index: "name-index"}, nil, byName, false},
+		{"index with ties descending", Query{Index: "name-index", Descending: true}, nil, byName, true},
+		{"sparse index", Query{Index: "createdAt-index"}, func(i testItem) bool { return i.CreatedAt != "" }, byCreatedAt, false},
+		{
+			name:  "filter",
+			query: Query{Index: "name-index", Filter: Equal("rarity", "rare")},
+			keep:  func(i testItem) bool { return i.Rarity == "rare" },
+			less:  byName,
+		},
+		{
+			name:       "filter descending",
+			query:      Query{Descending: true, Filter: And(Equal("rarity", "common"), NotEqual("name", "torch"))},
+			keep:       func(i testItem) bool { return i.Rarity == "common" && i.Name != "torch" },
+			less:       byId,
+			descending: true,
+		},
+		{"filter matching nothing", Query{Filter: Equal("rarity", "legendary")}, func(testItem) bool { return false }, byId, false},
+	}
+
+	for name, store := range testStores(t) {
+		for _, item := range append(items, others...) {
+			err := store.Put(testItemsTable.Name, item, nil)
+			if err != nil {
+				t.Fatalf("%s: Put() returned error: %v", name, err)
+			}
+		}
+
+		for _, tt := range tests {
+			var want []testItem
+			for _, item := range items {
+				if tt.keep == nil || tt.keep(item) {
+					want = append(want, item)
+				}
+			}
+			sort.Slice(want, func(i, j int) bool {
+				if tt.descending {
+					return tt.less(want[j], want[i])
+				}
+				return tt.less(want[i], want[j])
+			})
+
+			for _, limit := range []int{1, 2, 3, 4, len(items), len(items) + 1} {
+				t.Run(fmt.Sprintf("%s/%s/limit %d", name, tt.name, limit), func(t *testing.T) {
+					query := tt.query
+					query.Key = "worldId"
+					query.Value = "w1"
+					query.Limit = limit
+
+					var got []testItem
+					for pages := 0; ; pages++ {
+						if pages > len(items)+1 {
+							t.Fatalf("QueryPage() keeps returning pages, got %d items", len(got))
+						}
+
+						var page []testItem
+						next, err := store.QueryPage(testItemsTable.Name, &query, &page)
+						if err != nil {
+							t.Fatalf("QueryPage() returned error: %v", err)
+						}
+
+						// Only the last page can be short, as the items left may not
+						// pass the filter.
+						if len(page) > limit || (next != nil && len(page) < limit) {
+							t.Fatalf("QueryPage() returned %d items with limit %d, next = %v", len(page), limit, next != nil)
+						}
+
+						got = append(got, page...)
+						if next == nil {
+							break
+						}
+						query.StartKey = next
+					}
+
+					if !reflect.DeepEqual(got, want) {
+						t.Errorf("QueryPage() pages = %v, want %v", got, want)
+					}
+				})
+			}
+		}
+	}
+}
+
+func TestQueryPageProjection(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			for i := 0; i < 3; i++ {
+				item := testItem{WorldId: "w1", Id: fmt.Sprintf("i%d", i), Name: "rope", Rarity: "rare"}
+				err := store.Put(testItemsTable.Name, item, nil)
+				if err != nil {
+					t.Fatalf("Put() returned error: %v", err)
+				}
+			}
+
+			query := &Query{Index: "name-index", Key: "worldId", Value: "w1", Projection: []string{"worldId", "id"}, Limit: 2}
+
+			var page []testItem
+			next, err := store.QueryPage(testItemsTable.Name, query, &page)
+			if err != nil {
+				t.Fatalf("QueryPage() returned error: %v", err)
+			}
+
+			want := []testItem{{WorldId: "w1", Id: "i0"}, {WorldId: "w1", Id: "i1"}}
+			if !reflect.DeepEqual(page, want) {
+				t.Errorf("QueryPage() = %v, want %v", page, want)
+			}
+
+			// The next key holds the sort key of the index even when it isn't projected.
+			wantKeys := []string{"id", "name", "worldId"}
+			var keys []string
+			for k := range next {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			if !reflect.DeepEqual(keys, wantKeys) {
+				t.Errorf("QueryPage() next key attributes = %v, want %v", keys, wantKeys)
+			}
+		})
+	}
+}
+
+func TestScanIndex(t *testing.T) {
+	items := []testItem{
+		{WorldId: "w1", Id: "i1", Name: "rope", Rarity: "common", CreatedAt: "2024-01-01"},
+		{WorldId: "w1", Id: "i2", Name: "torch", Rarity: "rare"},
+		{WorldId: "w2", Id: "i3", Name: "dagger", Rarity: "rare", CreatedAt: "2024-01-03"},
+	}
+
+	tests := []struct {
+		name   string
+		index  string
+		filter *Condition
+		want   []string
+	}{
+		{"table", "", nil, []string{"i1", "i2", "i3"}},
+		{"table with filter", "", Equal("rarity", "rare"), []string{"i2", "i3"}},
+		{"sparse index", "createdAt-index", nil, []string{"i1", "i3"}},
+		{"sparse index with filter", "createdAt-index", Equal("rarity", "rare"), []string{"i3"}},
+	}
+
+	for name, store := range testStores(t) {
+		for _, item := range items {
+			err := store.Put(testItemsTable.Name, item, nil)
+			if err != nil {
+				t.Fatalf("%s: Put() returned error: %v", name, err)
+			}
+		}
+
+		for _, tt := range tests {
+			t.Run(name+"/"+tt.name, func(t *testing.T) {
+				var result []testItem
+				err := store.ScanIndex(testItemsTable.Name, tt.index, tt.filter, &result)
+				if err != nil {
+					t.Fatalf("ScanIndex() returned error: %v", err)
+				}
+
+				var got []string
+				for _, item := range result {
+					got = append(got, item.Id)
+				}
+				sort.Strings(got)
+
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("ScanIndex() = %v, want %v", got, tt.want)
+				}
+			})
+		}
+
+		t.Run(name+"/unknown index", func(t *testing.T) {
+			var result []testItem
+			err := store.ScanIndex(testItemsTable.Name, "missing-index", nil, &result)
+			if err == nil {
+				t.Errorf("ScanIndex() succeeded on an unknown index")
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+package uploader
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	common "github.com/jplindgren/rpg-vault/internal"
+)
+
+var ErrorInvalidPath = errors.New("invalid file path")
+
+// DiskStore keeps the files in a local directory. The API serves them itself from
+// the /v1/files/ route, so baseURL should point there.
+type DiskStore struct {
+	dir     string
+	baseURL string
+}
+
+func NewDiskStore(dir, baseURL string) (*DiskStore, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DiskStore{
+		dir:     dir,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+	}, nil
+}
+
+func (d *DiskStore) Upload(contents []byte, destinationPath string) (string, error) {
+	file, err := d.file(destinationPath)
+	if err != nil {
+		return "", err
+	}
+
+	err = os.MkdirAll(filepath.Dir(file), 0o755)
+	if err != nil {
+		return "", err
+	}
+
+	err = os.WriteFile(file, contents, 0o644)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s/%s", d.baseURL, destinationPath), nil
+}
+
+func (d *DiskStore) Read(filePath string) ([]byte, error) {
+	file, err := d.file(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	contents, err := os.ReadFile(file)
+	if err != nil {
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			return nil, common.ErrorRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return contents, nil
+}
+
+// Delete removes a file. Deleting a file that doesn't exist is not an error, like in
+// S3.
+func (d *DiskStore) Delete(filePath string) error {
+	file, err := d.file(filePath)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(file)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+func (d *DiskStore) DeletePrefix(prefix string) error {
+	// Only the directory holding the prefix has to be walked.
+	dir := d.dir
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		var err error
+		dir, err = d.file(prefix[:i])
+		if err != nil {
+			return err
+		}
+	}
+
+	err := filepath.WalkDir(dir, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(d.dir, file)
+		if err != nil {
+			return err
+		}
+
+		if entry.IsDir() || !strings.HasPrefix(filepath.ToSlash(rel), prefix) {
+			return nil
+		}
+
+		return os.Remove(file)
+	})
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	// Directories left empty are removed too, so a deleted world leaves nothing behind.
+	if strings.HasSuffix(prefix, "/") {
+		removeEmptyDirs(dir)
+	}
+
+	return nil
+}
+
+func removeEmptyDirs(dir string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			removeEmptyDirs(filepath.Join(dir, entry.Name()))
+		}
+	}
+
+	// Remove fails on directories which still hold files, leaving them in place.
+	os.Remove(dir)
+}
+
+// file maps a slash separated path to a file inside the directory, rejecting the paths
+// that would escape it.
+func (d *DiskStore) file(filePath string) (string, error) {
+	cleaned := path.Clean("/" + filePath)
+	if cleaned == "/" || cleaned != "/"+filePath {
+		return "", ErrorInvalidPath
+	}
+
+	return filepath.Join(d.dir, filepath.FromSlash(cleaned)), nil
+}
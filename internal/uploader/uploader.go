@@ -2,13 +2,31 @@ package uploader
 
 import (
 	"encoding/base64"
+	"errors"
 	"fmt"
+	"net/http"
 	"strings"
-
-	"github.com/jplindgren/rpg-vault/internal/clients"
 )
 
-func UploadCoverImage(s3 *clients.S3ClientWrapper, base64Image string, destination string) (string, error) {
+// ErrorInvalidImage is returned when an uploaded image isn't base64 encoded, or isn't
+// an image. The files are served back from the API origin, so anything else, like an
+// HTML page, must be rejected.
+var ErrorInvalidImage = errors.New("must be a base64 encoded image")
+
+// BlobStore stores binary files, like cover images. Upload returns the URL the file can
+// be downloaded from, and Read returns common.ErrorRecordNotFound for missing files.
+// DeletePrefix removes every file whose path starts with the prefix, like all the files
+// of a world.
+type BlobStore interface {
+	Upload(contents []byte, destinationPath string) (string, error)
+	Read(path string) ([]byte, error)
+	Delete(path string) error
+	DeletePrefix(prefix string) error
+}
+
+// UploadCoverImage decodes a base64 image, optionally prefixed by a data URL header, and
+// uploads it to destination, returning its URL. An empty image uploads nothing.
+func UploadCoverImage(blobs BlobStore, base64Image string, destination string) (string, error) {
 	if base64Image == "" {
 		return "", nil
 	}
@@ -32,13 +50,23 @@ func UploadCoverImage(s3 *clients.S3ClientWrapper, base64Image string, destinati
 	b64data := base64Image[strings.IndexByte(base64Image, ',')+1:]
 	imgBytes, err := base64.StdEncoding.DecodeString(b64data)
 	if err != nil {
-		return "", fmt.Errorf("error decoding base64 image: %w", err)
+		return "", fmt.Errorf("%w: %v", ErrorInvalidImage, err)
 	}
 
-	url, err := s3.Upload(imgBytes, destination)
+	if !IsImage(http.DetectContentType(imgBytes)) {
+		return "", ErrorInvalidImage
+	}
+
+	url, err := blobs.Upload(imgBytes, destination)
 	if err != nil {
 		return "", err
 	}
 
 	return url, nil
 }
+
+// IsImage reports whether a sniffed content type is an image format browsers render
+// as an image. SVG, which can hold scripts, is never sniffed as one.
+func IsImage(contentType string) bool {
+	return strings.HasPrefix(contentType, "image/")
+}
@@ -0,0 +1,46 @@
+package uploader
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+)
+
+// A 1x1 GIF, the smallest image the content sniffer recognizes.
+var gif = []byte("GIF89a\x01\x00\x01\x00\x80\x00\x00\x00\x00\x00\xff\xff\xff!\xf9\x04\x01\x00\x00\x00\x00,\x00\x00\x00\x00\x01\x00\x01\x00\x00\x02\x02D\x01\x00;")
+
+func TestUploadCoverImage(t *testing.T) {
+	tests := []struct {
+		name  string
+		image string
+		err   error
+	}{
+		{"empty", "", nil},
+		{"image", base64.StdEncoding.EncodeToString(gif), nil},
+		{"data url", "data:image/gif;base64," + base64.StdEncoding.EncodeToString(gif), nil},
+		{"not base64", "not an image!", ErrorInvalidImage},
+		{"html", base64.StdEncoding.EncodeToString([]byte("<html><script>alert(1)</script></html>")), ErrorInvalidImage},
+		{"svg", base64.StdEncoding.EncodeToString([]byte(`<svg xmlns="http://www.w3.org/2000/svg" onload="alert(1)"/>`)), ErrorInvalidImage},
+		{"text", base64.StdEncoding.EncodeToString([]byte("hello")), ErrorInvalidImage},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			blobs, err := NewDiskStore(t.TempDir(), "http://localhost/v1/files")
+			if err != nil {
+				t.Fatalf("NewDiskStore() returned error: %v", err)
+			}
+
+			url, err := UploadCoverImage(blobs, tt.image, "w1/world/cover.png")
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("UploadCoverImage() error = %v, want %v", err, tt.err)
+			}
+
+			_, readErr := blobs.Read("w1/world/cover.png")
+			uploaded := readErr == nil
+			if want := tt.err == nil && tt.image != ""; uploaded != want || (url != "") != want {
+				t.Errorf("UploadCoverImage() uploaded = %v with url %q, want uploaded %v", uploaded, url, want)
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package users
 
 import (
+	"encoding/hex"
 	"errors"
 	"time"
 
@@ -67,9 +68,38 @@ func (p *password) Matches(plainTextPassword string) (bool, error) {
 }
 
 type Token struct {
-	Plaintext string    `json:"token"`
-	Email     string    `json:"email"`
-	Hash      []byte    `json:"-"`
-	Expiry    time.Time `json:"expiry"`
-	Scope     string    `json:"-"`
+	Plaintext  string    `json:"token"`
+	Email      string    `json:"email"`
+	Hash       []byte    `json:"-"`
+	Expiry     time.Time `json:"expiry"`
+	Scope      string    `json:"-"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"-"`
+	Family     string    `json:"-"`
+	Used       bool      `json:"-"`
+}
+
+// Session describes an active authentication token without exposing it. The id is the
+// hex-encoded token hash, which can't be used to authenticate.
+type Session struct {
+	Id         string     `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	Expiry     time.Time  `json:"expiry"`
+	Current    bool       `json:"current"`
+}
+
+// Session returns the session view of the token.
+func (t *Token) Session() Session {
+	session := Session{
+		Id:        hex.EncodeToString(t.Hash),
+		CreatedAt: t.CreatedAt,
+		Expiry:    t.Expiry,
+	}
+
+	if !t.LastUsedAt.IsZero() {
+		session.LastUsedAt = &t.LastUsedAt
+	}
+
+	return session
 }
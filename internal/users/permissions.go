@@ -0,0 +1,155 @@
+package users
+
+import (
+	"errors"
+	"strings"
+
+	common "github.com/jplindgren/rpg-vault/internal"
+	"github.com/jplindgren/rpg-vault/internal/storage"
+)
+
+// Define the permission codes checked by the requirePermission() middleware.
+const (
+	PermissionWorldsRead      = "worlds:read"
+	PermissionWorldsWrite     = "worlds:write"
+	PermissionCharactersRead  = "characters:read"
+	PermissionCharactersWrite = "characters:write"
+	PermissionUsersAdmin      = "users:admin"
+)
+
+// PermissionCodes lists every permission code that can be granted.
+var PermissionCodes = []string{
+	PermissionWorldsRead,
+	PermissionWorldsWrite,
+	PermissionCharactersRead,
+	PermissionCharactersWrite,
+	PermissionUsersAdmin,
+}
+
+// Permissions holds the permission codes (like "worlds:read" and "characters:write")
+// granted to a single user.
+type Permissions []string
+
+// Include checks whether the Permissions slice contains a specific permission code.
+func (p Permissions) Include(code string) bool {
+	for i := range p {
+		if code == p[i] {
+			return true
+		}
+	}
+	return false
+}
+
+type dbPermissions struct {
+	Email string   `dynamodbav:"email"`
+	Codes []string `dynamodbav:"codes,stringset,omitempty"`
+}
+
+// PermissionSettings holds the codes of the users without a permissions record, which
+// registered before their codes were stored, and the emails of the administrators, who
+// are always granted PermissionUsersAdmin so they can manage everyone else's codes.
+type PermissionSettings struct {
+	Defaults []string
+	Admins   []string
+}
+
+type PermissionService struct {
+	Store     storage.Store
+	TableName string
+	Settings  PermissionSettings
+}
+
+func NewPermissionSrv(store storage.Store, tableName string, settings PermissionSettings) *PermissionService {
+	return &PermissionService{
+		Store:     store,
+		TableName: tableName,
+		Settings:  settings,
+	}
+}
+
+// GetAllForUser returns all permission codes for a specific user. A user without any
+// permissions record has the default codes, so that is not reported as an error.
+func (s *PermissionService) GetAllForUser(email string) (Permissions, error) {
+	key := &UserKeyBasedStruct{
+		Email: email,
+	}
+
+	result := &dbPermissions{}
+	err := s.Store.Get(s.TableName, key, result)
+	if err != nil {
+		switch {
+		case errors.Is(err, common.ErrorRecordNotFound):
+			result.Codes = append([]string{}, s.Settings.Defaults...)
+		default:
+			return nil, err
+		}
+	}
+
+	permissions := Permissions(result.Codes)
+	if permissions == nil {
+		permissions = Permissions{}
+	}
+
+	for _, admin := range s.Settings.Admins {
+		if strings.EqualFold(admin, email) && !permissions.Include(PermissionUsersAdmin) {
+			permissions = append(permissions, PermissionUsersAdmin)
+		}
+	}
+
+	return permissions, nil
+}
+
+// AddForUser grants the provided permission codes to a specific user. The codes are
+// stored in a string set, so granting a code the user already has is a no-op.
+func (s *PermissionService) AddForUser(email string, codes ...string) error {
+	if len(codes) == 0 {
+		return nil
+	}
+
+	err := s.ensureRecord(email)
+	if err != nil {
+		return err
+	}
+
+	key := &UserKeyBasedStruct{
+		Email: email,
+	}
+
+	return s.Store.Update(s.TableName, key, storage.AddToSet("codes", codes...))
+}
+
+// RemoveForUser revokes the provided permission codes from a specific user. Revoking a
+// code the user doesn't have is a no-op.
+func (s *PermissionService) RemoveForUser(email string, codes ...string) error {
+	if len(codes) == 0 {
+		return nil
+	}
+
+	err := s.ensureRecord(email)
+	if err != nil {
+		return err
+	}
+
+	key := &UserKeyBasedStruct{
+		Email: email,
+	}
+
+	return s.Store.Update(s.TableName, key, storage.DeleteFromSet("codes", codes...))
+}
+
+// ensureRecord stores the default codes for a user without a permissions record, so
+// granting or revoking a code changes the codes the user actually has instead of
+// replacing them.
+func (s *PermissionService) ensureRecord(email string) error {
+	record := dbPermissions{
+		Email: email,
+		Codes: s.Settings.Defaults,
+	}
+
+	err := s.Store.Put(s.TableName, record, storage.AttributeNotExists("email"))
+	if err != nil && !errors.Is(err, storage.ErrorConditionFailed) {
+		return err
+	}
+
+	return nil
+}
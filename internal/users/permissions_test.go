@@ -0,0 +1,84 @@
+package users
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/jplindgren/rpg-vault/internal/storage"
+)
+
+func newTestPermissionSrv() *PermissionService {
+	table := storage.Table{Name: "permissions", PartitionKey: "email"}
+	settings := PermissionSettings{
+		Defaults: []string{PermissionWorldsRead, PermissionCharactersRead},
+		Admins:   []string{"Admin@example.com"},
+	}
+	return NewPermissionSrv(storage.NewMemory(table), table.Name, settings)
+}
+
+func TestPermissions(t *testing.T) {
+	tests := []struct {
+		name   string
+		email  string
+		grant  []string
+		revoke []string
+		want   []string
+	}{
+		{"defaults without a record", "alice@example.com", nil, nil, []string{PermissionCharactersRead, PermissionWorldsRead}},
+		{"admin", "admin@example.com", nil, nil, []string{PermissionCharactersRead, PermissionUsersAdmin, PermissionWorldsRead}},
+		{"grant keeps the defaults", "alice@example.com", []string{PermissionWorldsWrite}, nil, []string{PermissionCharactersRead, PermissionWorldsRead, PermissionWorldsWrite}},
+		{"grant twice", "alice@example.com", []string{PermissionWorldsRead, PermissionWorldsRead}, nil, []string{PermissionCharactersRead, PermissionWorldsRead}},
+		{"revoke a default", "alice@example.com", nil, []string{PermissionWorldsRead}, []string{PermissionCharactersRead}},
+		{"revoke a missing code", "alice@example.com", nil, []string{PermissionUsersAdmin}, []string{PermissionCharactersRead, PermissionWorldsRead}},
+		{"revoke every code", "alice@example.com", nil, []string{PermissionWorldsRead, PermissionCharactersRead}, []string{}},
+		{"grant and revoke", "alice@example.com", []string{PermissionCharactersWrite}, []string{PermissionCharactersRead}, []string{PermissionCharactersWrite, PermissionWorldsRead}},
+		{"admins can't lose users:admin", "admin@example.com", nil, []string{PermissionUsersAdmin}, []string{PermissionCharactersRead, PermissionUsersAdmin, PermissionWorldsRead}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestPermissionSrv()
+
+			err := s.AddForUser(tt.email, tt.grant...)
+			if err != nil {
+				t.Fatalf("AddForUser() returned error: %v", err)
+			}
+
+			err = s.RemoveForUser(tt.email, tt.revoke...)
+			if err != nil {
+				t.Fatalf("RemoveForUser() returned error: %v", err)
+			}
+
+			got, err := s.GetAllForUser(tt.email)
+			if err != nil {
+				t.Fatalf("GetAllForUser() returned error: %v", err)
+			}
+
+			sort.Strings(got)
+			if !reflect.DeepEqual([]string(got), tt.want) {
+				t.Errorf("GetAllForUser() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPermissionsInclude(t *testing.T) {
+	p := Permissions{PermissionWorldsRead, PermissionCharactersWrite}
+
+	tests := []struct {
+		code string
+		want bool
+	}{
+		{PermissionWorldsRead, true},
+		{PermissionCharactersWrite, true},
+		{PermissionWorldsWrite, false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		if got := p.Include(tt.code); got != tt.want {
+			t.Errorf("Include(%q) = %v, want %v", tt.code, got, tt.want)
+		}
+	}
+}
@@ -4,33 +4,67 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base32"
+	"errors"
 	"time"
 
-	"github.com/jplindgren/rpg-vault/internal/clients"
+	common "github.com/jplindgren/rpg-vault/internal"
+	"github.com/jplindgren/rpg-vault/internal/storage"
 	"github.com/jplindgren/rpg-vault/internal/validator"
 )
 
 // Define constants for the token scope. For now we just define the scope "activation"
 // but we'll add additional scopes later in the book.
 const (
-	ScopeActivation = "activation"
-	Authentication  = "authentication"
+	ScopeActivation    = "activation"
+	Authentication     = "authentication"
+	ScopePasswordReset = "password-reset"
+	ScopeRefresh       = "refresh"
 )
 
+// ErrorTokenReused is returned when a refresh token that has already been exchanged is
+// presented again, which means it has probably been stolen.
+var ErrorTokenReused = errors.New("token has already been used")
+
+// The tokens table is keyed by the token hash and has a global secondary index keyed by
+// email, used to find all the tokens of a user.
+const tokenEmailIndex = "email-index"
+
 type dbToken struct {
 	Hash  []byte `dynamodbav:"hash"`
 	Email string `dynamodbav:"email"`
 	//Expiry string `dynamodbav:"expiry"`
-	Expiry int64  `dynamodbav:"expiry"`
-	Scope  string `dynamodbav:"scope"`
+	Expiry     int64  `dynamodbav:"expiry"`
+	Scope      string `dynamodbav:"scope"`
+	CreatedAt  int64  `dynamodbav:"created_at"`
+	LastUsedAt int64  `dynamodbav:"last_used_at"`
+	Family     string `dynamodbav:"family,omitempty"`
+	Used       bool   `dynamodbav:"used"`
+}
+
+func (t *dbToken) toToken() *Token {
+	token := &Token{
+		Hash:      t.Hash,
+		Email:     t.Email,
+		Expiry:    time.Unix(t.Expiry, 0),
+		Scope:     t.Scope,
+		CreatedAt: time.Unix(t.CreatedAt, 0),
+		Family:    t.Family,
+		Used:      t.Used,
+	}
+
+	if t.LastUsedAt != 0 {
+		token.LastUsedAt = time.Unix(t.LastUsedAt, 0)
+	}
+
+	return token
 }
 
 type TokenService struct {
-	Store     *clients.DynamoDbClientWrapper
+	Store     storage.Store
 	TableName string
 }
 
-func NewTokenSrv(store *clients.DynamoDbClientWrapper, tableName string) *TokenService {
+func NewTokenSrv(store storage.Store, tableName string) *TokenService {
 	return &TokenService{
 		Store:     store,
 		TableName: tableName,
@@ -38,11 +72,19 @@ func NewTokenSrv(store *clients.DynamoDbClientWrapper, tableName string) *TokenS
 }
 
 func (s *TokenService) New(email string, ttl time.Duration, scope string) (*Token, error) {
+	return s.NewInFamily(email, ttl, scope, "")
+}
+
+// NewInFamily creates a token belonging to a token family. Every token issued from the
+// same login, including the ones obtained by refreshing, shares the family, so they can
+// all be revoked together.
+func (s *TokenService) NewInFamily(email string, ttl time.Duration, scope, family string) (*Token, error) {
 	token, err := generateToken(email, ttl, scope)
 	if err != nil {
 		return nil, err
 	}
 
+	token.Family = family
 	err = s.Insert(token)
 
 	return token, err
@@ -50,14 +92,15 @@ func (s *TokenService) New(email string, ttl time.Duration, scope string) (*Toke
 
 func (s *TokenService) Insert(token *Token) error {
 	item := dbToken{
-		Email:  token.Email,
-		Hash:   token.Hash,
-		Expiry: token.Expiry.Unix(),
-		Scope:  token.Scope,
+		Email:     token.Email,
+		Hash:      token.Hash,
+		Expiry:    token.Expiry.Unix(),
+		Scope:     token.Scope,
+		CreatedAt: token.CreatedAt.Unix(),
+		Family:    token.Family,
 	}
 
-	_, putItemErr := s.Store.PutWrapper(s.TableName, item, nil)
-	return putItemErr
+	return s.Store.Put(s.TableName, item, nil)
 }
 
 type TokenKeyBasedStruct struct {
@@ -73,17 +116,162 @@ func (s *TokenService) Get(tokenPlaintext string) (*Token, error) {
 	}
 
 	result := &dbToken{}
-	_, err := s.Store.GetWrapper(s.TableName, key, result)
+	err := s.Store.Get(s.TableName, key, result)
 	if err != nil {
 		return &Token{}, err
 	}
 
-	return &Token{
-		Hash:   result.Hash,
-		Email:  result.Email,
-		Expiry: time.Unix(result.Expiry, 0),
-		Scope:  result.Scope,
-	}, nil
+	return result.toToken(), nil
+}
+
+// GetForScope retrieves a token by its plaintext value, making sure it was issued for
+// the given scope and hasn't expired yet. DynamoDB deletes expired items lazily, so the
+// expiry has to be checked here. Any mismatch is reported as ErrorRecordNotFound.
+func (s *TokenService) GetForScope(scope, tokenPlaintext string) (*Token, error) {
+	token, err := s.Get(tokenPlaintext)
+	if err != nil {
+		return nil, err
+	}
+
+	if token.Scope != scope || !token.Expiry.After(time.Now()) {
+		return nil, common.ErrorRecordNotFound
+	}
+
+	return token, nil
+}
+
+// Touch records that the token has just been used.
+func (s *TokenService) Touch(token *Token) error {
+	key := &TokenKeyBasedStruct{
+		Hash: token.Hash,
+	}
+
+	token.LastUsedAt = time.Now()
+
+	// Like MarkUsed, require the token to still exist, so a session revoked in the
+	// meantime isn't recreated by the update. Touching a deleted token is a no-op.
+	update := storage.Set("last_used_at", token.LastUsedAt.Unix()).If(storage.AttributeExists("hash"))
+
+	err := s.Store.Update(s.TableName, key, update)
+	if err != nil && !errors.Is(err, storage.ErrorConditionFailed) {
+		return err
+	}
+
+	return nil
+}
+
+// MarkUsed flags a refresh token as exchanged. The update is conditional, so when two
+// requests race with the same token only one of them wins and the other one gets
+// ErrorTokenReused.
+func (s *TokenService) MarkUsed(token *Token) error {
+	key := &TokenKeyBasedStruct{
+		Hash: token.Hash,
+	}
+
+	// Also require the token to still exist, otherwise a token deleted in the meantime
+	// would be recreated by the update.
+	update := storage.Set("used", true).If(storage.And(
+		storage.AttributeExists("hash"),
+		storage.Equal("used", false),
+	))
+
+	err := s.Store.Update(s.TableName, key, update)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrorConditionFailed):
+			return ErrorTokenReused
+		default:
+			return err
+		}
+	}
+
+	token.Used = true
+	return nil
+}
+
+// ListActiveForUser returns the tokens of a specific scope for a user that haven't
+// expired yet. For the authentication scope these are the user's open sessions.
+func (s *TokenService) ListActiveForUser(scope, email string) ([]*Token, error) {
+	filter := storage.And(
+		storage.Equal("scope", scope),
+		storage.GreaterThan("expiry", time.Now().Unix()),
+	)
+
+	items, err := s.queryForUser(email, filter, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := make([]*Token, len(items))
+	for i := range items {
+		tokens[i] = items[i].toToken()
+	}
+
+	return tokens, nil
+}
+
+// Delete removes a single token, revoking it.
+func (s *TokenService) Delete(token *Token) error {
+	key := &TokenKeyBasedStruct{
+		Hash: token.Hash,
+	}
+
+	return s.Store.Delete(s.TableName, key)
+}
+
+// DeleteAllForUser deletes all tokens of a specific scope for a user.
+func (s *TokenService) DeleteAllForUser(scope, email string) error {
+	return s.deleteForUser(email, storage.Equal("scope", scope))
+}
+
+// DeleteFamily deletes every token of a token family, whatever its scope.
+func (s *TokenService) DeleteFamily(email, family string) error {
+	return s.deleteForUser(email, storage.Equal("family", family))
+}
+
+// DeleteAll deletes every token of a user, whatever its scope.
+func (s *TokenService) DeleteAll(email string) error {
+	return s.deleteForUser(email, nil)
+}
+
+func (s *TokenService) deleteForUser(email string, filter *storage.Condition) error {
+	items, err := s.queryForUser(email, filter, []string{"hash"})
+	if err != nil {
+		return err
+	}
+
+	for i := range items {
+		key := &TokenKeyBasedStruct{
+			Hash: items[i].Hash,
+		}
+
+		err = s.Store.Delete(s.TableName, key)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// queryForUser finds the tokens of a user through the email index, optionally
+// filtering and projecting the results.
+func (s *TokenService) queryForUser(email string, filter *storage.Condition, proj []string) ([]dbToken, error) {
+	query := &storage.Query{
+		Index:      tokenEmailIndex,
+		Key:        "email",
+		Value:      email,
+		Filter:     filter,
+		Projection: proj,
+	}
+
+	var items []dbToken
+	err := s.Store.Query(s.TableName, query, &items)
+	if err != nil {
+		return nil, err
+	}
+
+	return items, nil
 }
 
 // Check that the plaintext token has been provided and is exactly 26 bytes long.
@@ -94,9 +282,10 @@ func ValidateTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
 
 func generateToken(email string, ttl time.Duration, scope string) (*Token, error) {
 	token := &Token{
-		Email:  email,
-		Expiry: time.Now().Add(ttl),
-		Scope:  scope,
+		Email:     email,
+		Expiry:    time.Now().Add(ttl),
+		Scope:     scope,
+		CreatedAt: time.Now(),
 	}
 
 	// Initialize a zero-valued byte slice with a length of 16 bytes.
@@ -0,0 +1,153 @@
+package users
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	common "github.com/jplindgren/rpg-vault/internal"
+	"github.com/jplindgren/rpg-vault/internal/storage"
+)
+
+func newTestTokenSrv() *TokenService {
+	table := storage.Table{Name: "tokens", PartitionKey: "hash", BinaryKeys: []string{"hash"}, Indexes: []storage.Index{
+		{Name: tokenEmailIndex, PartitionKey: "email"},
+	}}
+	return NewTokenSrv(storage.NewMemory(table), table.Name)
+}
+
+func TestGetForScope(t *testing.T) {
+	s := newTestTokenSrv()
+
+	valid, err := s.New("alice@example.com", time.Hour, Authentication)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, err := s.New("alice@example.com", -time.Minute, Authentication)
+	if err != nil {
+		t.Fatal(err)
+	}
+	activation, err := s.New("alice@example.com", time.Hour, ScopeActivation)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		scope     string
+		plaintext string
+		wantErr   error
+	}{
+		{"valid", Authentication, valid.Plaintext, nil},
+		{"expired", Authentication, expired.Plaintext, common.ErrorRecordNotFound},
+		{"other scope", Authentication, activation.Plaintext, common.ErrorRecordNotFound},
+		{"own scope", ScopeActivation, activation.Plaintext, nil},
+		{"unknown", Authentication, "ABCDEFGHIJKLMNOPQRSTUVWXYZ", common.ErrorRecordNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := s.GetForScope(tt.scope, tt.plaintext)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("GetForScope() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && token.Email != "alice@example.com" {
+				t.Errorf("GetForScope() email = %q, want %q", token.Email, "alice@example.com")
+			}
+		})
+	}
+}
+
+func TestDeleteTokens(t *testing.T) {
+	tests := []struct {
+		name   string
+		delete func(s *TokenService) error
+		want   []string
+	}{
+		{"family", func(s *TokenService) error { return s.DeleteFamily("alice@example.com", "one") }, []string{"alice two", "bob one"}},
+		{"scope", func(s *TokenService) error { return s.DeleteAllForUser(ScopeRefresh, "alice@example.com") }, []string{"alice one", "alice two", "bob one"}},
+		{"all", func(s *TokenService) error { return s.DeleteAll("alice@example.com") }, []string{"bob one"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestTokenSrv()
+			issued := map[string]*Token{}
+			for _, owner := range []struct{ name, email, family string }{
+				{"alice one", "alice@example.com", "one"},
+				{"alice two", "alice@example.com", "two"},
+				{"bob one", "bob@example.com", "one"},
+			} {
+				token, err := s.NewInFamily(owner.email, time.Hour, Authentication, owner.family)
+				if err != nil {
+					t.Fatal(err)
+				}
+				issued[owner.name] = token
+
+				_, err = s.NewInFamily(owner.email, time.Hour, ScopeRefresh, owner.family)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err := tt.delete(s)
+			if err != nil {
+				t.Fatalf("deleting tokens: %v", err)
+			}
+
+			var got []string
+			for _, name := range []string{"alice one", "alice two", "bob one"} {
+				_, err := s.GetForScope(Authentication, issued[name].Plaintext)
+				if err == nil {
+					got = append(got, name)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("remaining tokens = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMarkUsed(t *testing.T) {
+	s := newTestTokenSrv()
+
+	token, err := s.NewInFamily("alice@example.com", time.Hour, ScopeRefresh, "one")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deleted, err := s.NewInFamily("alice@example.com", time.Hour, ScopeRefresh, "two")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Delete(deleted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		token   *Token
+		wantErr error
+	}{
+		{"first use", token, nil},
+		{"reuse", token, ErrorTokenReused},
+		{"deleted", deleted, ErrorTokenReused},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := s.MarkUsed(tt.token)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("MarkUsed() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	// The deleted token must not be recreated by the failed update.
+	_, err = s.GetForScope(ScopeRefresh, deleted.Plaintext)
+	if !errors.Is(err, common.ErrorRecordNotFound) {
+		t.Errorf("GetForScope() error = %v, want %v", err, common.ErrorRecordNotFound)
+	}
+}
@@ -3,8 +3,8 @@ package users
 import (
 	"errors"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/jplindgren/rpg-vault/internal/clients"
+	common "github.com/jplindgren/rpg-vault/internal"
+	"github.com/jplindgren/rpg-vault/internal/storage"
 	"github.com/jplindgren/rpg-vault/internal/validator"
 )
 
@@ -24,19 +24,23 @@ type dbUserItem struct {
 	Version       int    `dynamodbav:"version"`
 }
 
-func New(store *clients.DynamoDbClientWrapper, tableName string) *UserService {
+func New(store storage.Store, tableName, permissionsTable string) *UserService {
 	return &UserService{
-		Store:     store,
-		TableName: tableName,
+		Store:            store,
+		TableName:        tableName,
+		PermissionsTable: permissionsTable,
 	}
 }
 
 type UserService struct {
-	Store     *clients.DynamoDbClientWrapper
-	TableName string
+	Store            storage.Store
+	TableName        string
+	PermissionsTable string
 }
 
-func (s UserService) Insert(user *User) error {
+// Insert creates a user along with their permissions record, holding the provided
+// codes, in a single transaction, so a user is never left without their codes.
+func (s UserService) Insert(user *User, permissions ...string) error {
 	item := dbUserItem{
 		Email:         user.Email,
 		CreatedAt:     user.CreatedAt,
@@ -46,8 +50,22 @@ func (s UserService) Insert(user *User) error {
 		Version:       user.Version,
 	}
 
-	_, putItemErr := s.Store.PutWrapper(s.TableName, item, aws.String("attribute_not_exists(email)"))
-	return putItemErr
+	writes := []storage.Write{
+		{Table: s.TableName, Item: item, Cond: storage.AttributeNotExists("email")},
+		{Table: s.PermissionsTable, Item: dbPermissions{Email: user.Email, Codes: permissions}},
+	}
+
+	putItemErr := s.Store.TransactWrite(writes)
+	if putItemErr != nil {
+		switch {
+		case errors.Is(putItemErr, storage.ErrorConditionFailed):
+			return ErrorDuplicateEmail
+		default:
+			return putItemErr
+		}
+	}
+
+	return nil
 }
 
 type UserKeyBasedStruct struct {
@@ -62,7 +80,7 @@ func (s UserService) GetByEmail(email string) (*User, error) {
 		Email: email,
 	}
 	result := &dbUserItem{}
-	_, err := s.Store.GetWrapper(s.TableName, key, result)
+	err := s.Store.Get(s.TableName, key, result)
 	if err != nil {
 		return &User{}, err
 	}
@@ -76,12 +94,32 @@ func (s UserService) GetByEmail(email string) (*User, error) {
 }
 
 // Update the details for a specific user. Notice that we check against the version
-// field to help prevent any race conditions during the request cycle, just like we did
-// when updating a movie. And we also check for a violation of the "users_email_key"
-// constraint when performing the update, just like we did when inserting the user
-// record originally.
+// field to help prevent any race conditions during the request cycle: the update only
+// goes through if nobody else changed the user since we read it, in which case the
+// version is incremented. Otherwise we return ErrorEditConflict.
 func (s UserService) Update(user *User) error {
-	panic("missing Update")
+	key := &UserKeyBasedStruct{
+		Email: user.Email,
+	}
+
+	update := storage.Set("name", user.Name).
+		Set("password_hash", user.Password.hash).
+		Set("activated", user.Activated).
+		Set("version", user.Version+1).
+		If(storage.Equal("version", user.Version))
+
+	err := s.Store.Update(s.TableName, key, update)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrorConditionFailed):
+			return common.ErrorEditConflict
+		default:
+			return err
+		}
+	}
+
+	user.Version++
+	return nil
 }
 
 func ValidateEmail(v *validator.Validator, email string) {
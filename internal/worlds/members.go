@@ -0,0 +1,147 @@
+package worlds
+
+import (
+	"errors"
+
+	common "github.com/jplindgren/rpg-vault/internal"
+	"github.com/jplindgren/rpg-vault/internal/storage"
+	"github.com/jplindgren/rpg-vault/internal/validator"
+)
+
+//const memberTableName = "rpg_world_members"
+
+// The members table is keyed by worldId/email and has a global secondary index keyed
+// by email/worldId, used to find every world a user belongs to.
+const memberEmailIndex = "email-index"
+
+var ErrorAlreadyMember = errors.New("already a member")
+
+type MemberKey struct {
+	WorldId string `dynamodbav:"worldId"`
+	Email   string `dynamodbav:"email"`
+}
+
+type MemberService struct {
+	db        storage.Store
+	tableName string
+}
+
+func NewMemberSrv(db storage.Store, tableName string) *MemberService {
+	return &MemberService{
+		db:        db,
+		tableName: tableName,
+	}
+}
+
+// Insert adds a new member to a world. Inviting someone who already has a membership
+// (pending or accepted) returns ErrorAlreadyMember.
+func (ms *MemberService) Insert(member *Member) error {
+	member.CreatedAt = common.GetIsoString()
+	member.UpdatedAt = ""
+
+	err := ms.db.Put(ms.tableName, member, storage.AttributeNotExists("email"))
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrorConditionFailed):
+			return ErrorAlreadyMember
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (ms *MemberService) Get(worldId, email string) (*Member, error) {
+	key := MemberKey{
+		WorldId: worldId,
+		Email:   email,
+	}
+
+	var result Member
+	err := ms.db.Get(ms.tableName, key, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// List returns every member (pending or accepted) of a world.
+func (ms *MemberService) List(worldId string) (*[]Member, error) {
+	query := &storage.Query{Key: "worldId", Value: worldId}
+
+	var resultArr []Member
+	err := ms.db.Query(ms.tableName, query, &resultArr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &resultArr, nil
+}
+
+// ListForUser returns every membership of a user across all worlds.
+func (ms *MemberService) ListForUser(email string) (*[]Member, error) {
+	query := &storage.Query{Index: memberEmailIndex, Key: "email", Value: email}
+
+	var resultArr []Member
+	err := ms.db.Query(ms.tableName, query, &resultArr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &resultArr, nil
+}
+
+// ListSharedWithUser returns a page of the accepted memberships of a user in worlds
+// owned by someone else, along with the key of the next page, or nil on the last one.
+func (ms *MemberService) ListSharedWithUser(email string, limit int, start storage.Item) (*[]Member, storage.Item, error) {
+	query := &storage.Query{
+		Index:    memberEmailIndex,
+		Key:      "email",
+		Value:    email,
+		Filter:   storage.And(storage.Equal("status", MemberAccepted), storage.NotEqual("role", RoleOwner)),
+		Limit:    limit,
+		StartKey: start,
+	}
+
+	var resultArr []Member
+	next, err := ms.db.QueryPage(ms.tableName, query, &resultArr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &resultArr, next, nil
+}
+
+// Accept turns a pending invitation into an active membership.
+func (ms *MemberService) Accept(member *Member) error {
+	key := MemberKey{
+		WorldId: member.WorldId,
+		Email:   member.Email,
+	}
+
+	member.Status = MemberAccepted
+	member.UpdatedAt = common.GetIsoString()
+
+	update := storage.Set("status", member.Status).
+		Set("updatedAt", member.UpdatedAt)
+
+	return ms.db.Update(ms.tableName, key, update)
+}
+
+func (ms *MemberService) Delete(worldId, email string) error {
+	key := MemberKey{
+		WorldId: worldId,
+		Email:   email,
+	}
+
+	return ms.db.Delete(ms.tableName, key)
+}
+
+func ValidateMember(v *validator.Validator, member *Member) {
+	v.Check(member.Email != "", "email", "must be provided")
+	v.Check(validator.Matches(member.Email, validator.EmailRX), "email", "must be a valid email address")
+
+	v.Check(validator.PermittedValue(member.Role, RoleGameMaster, RolePlayer, RoleViewer), "role", "must be gm, player or viewer")
+}
@@ -1,12 +1,82 @@
 package worlds
 
+import "github.com/jplindgren/rpg-vault/internal/sheets"
+
+// World is a campaign setting. Sheet is the template the attributes of its characters
+// must follow; worlds without one accept any attributes. System is the id of the game
+// system the world was created from, if any.
 type World struct {
-	UserId     string   `json:"userId" dynamodbav:"userId"`
-	Id         string   `json:"id" dynamodbav:"id"`
-	Name       string   `json:"name" dynamodbav:"name"`
-	Intro      string   `json:"intro" dynamodbav:"intro"`
-	Genres     []string `json:"genres" dynamodbav:"genres,stringset,omitempty"`
-	CoverImage string   `json:"coverImage" dynamodbav:"coverImage"`
-	CreatedAt  string   `json:"createdAt" dynamodbav:"createdAt"`
-	UpdatedAt  string   `json:"updatedAt" dynamodbav:"updatedAt"`
+	UserId     string           `json:"userId" dynamodbav:"userId"`
+	Id         string           `json:"id" dynamodbav:"id"`
+	Name       string           `json:"name" dynamodbav:"name"`
+	NameLower  string           `json:"-" dynamodbav:"nameLower,omitempty"`
+	Intro      string           `json:"intro" dynamodbav:"intro"`
+	Genres     []string         `json:"genres" dynamodbav:"genres,stringset,omitempty"`
+	CoverImage string           `json:"coverImage" dynamodbav:"coverImage"`
+	System     string           `json:"system,omitempty" dynamodbav:"system,omitempty"`
+	Sheet      *sheets.Template `json:"sheet,omitempty" dynamodbav:"sheet,omitempty"`
+	CreatedAt  string           `json:"createdAt" dynamodbav:"createdAt"`
+	UpdatedAt  string           `json:"updatedAt" dynamodbav:"updatedAt"`
+	DeletedAt  string           `json:"deletedAt,omitempty" dynamodbav:"deletedAt,omitempty"`
+	Role       string           `json:"role,omitempty" dynamodbav:"-"`
+}
+
+// Define the roles a user can have inside a world, from the most to the least
+// privileged one.
+const (
+	RoleOwner      = "owner"
+	RoleGameMaster = "gm"
+	RolePlayer     = "player"
+	RoleViewer     = "viewer"
+)
+
+// Define the states of a membership. Invitations must be accepted before they grant
+// access to the world.
+const (
+	MemberInvited  = "invited"
+	MemberAccepted = "accepted"
+)
+
+var roleRanks = map[string]int{
+	RoleViewer:     1,
+	RolePlayer:     2,
+	RoleGameMaster: 3,
+	RoleOwner:      4,
+}
+
+type Member struct {
+	WorldId   string `json:"worldId" dynamodbav:"worldId"`
+	Email     string `json:"email" dynamodbav:"email"`
+	OwnerId   string `json:"ownerId" dynamodbav:"ownerId"`
+	Role      string `json:"role" dynamodbav:"role"`
+	Status    string `json:"status" dynamodbav:"status"`
+	InvitedBy string `json:"invitedBy" dynamodbav:"invitedBy"`
+	CreatedAt string `json:"createdAt" dynamodbav:"createdAt"`
+	UpdatedAt string `json:"updatedAt" dynamodbav:"updatedAt"`
+}
+
+// Can reports whether the member has accepted the invitation and holds at least the
+// given role in the world.
+func (m *Member) Can(role string) bool {
+	return m.Status == MemberAccepted && RoleAtLeast(m.Role, role)
+}
+
+// RoleAtLeast reports whether role is equal to or more privileged than min.
+func RoleAtLeast(role, min string) bool {
+	return roleRanks[role] >= roleRanks[min]
+}
+
+// Outranks reports whether role is strictly more privileged than other.
+func Outranks(role, other string) bool {
+	return roleRanks[role] > roleRanks[other]
+}
+
+// Filters selects and orders the worlds of a listing. Name matches the worlds whose
+// name contains it, ignoring case, and Genres the worlds having all of them.
+type Filters struct {
+	Name   string
+	Genres []string
+	Sort   string
 }
+
+var SortSafelist = []string{"name", "createdAt", "updatedAt", "-name", "-createdAt", "-updatedAt"}
@@ -0,0 +1,51 @@
+package worlds
+
+import "testing"
+
+func TestRoles(t *testing.T) {
+	tests := []struct {
+		role, other  string
+		wantAtLeast  bool
+		wantOutranks bool
+	}{
+		{RoleOwner, RoleOwner, true, false},
+		{RoleOwner, RoleGameMaster, true, true},
+		{RoleGameMaster, RoleOwner, false, false},
+		{RoleGameMaster, RolePlayer, true, true},
+		{RolePlayer, RoleViewer, true, true},
+		{RolePlayer, RolePlayer, true, false},
+		{RoleViewer, RolePlayer, false, false},
+		{"", RoleViewer, false, false},
+		{RoleViewer, "", true, true},
+	}
+
+	for _, tt := range tests {
+		if got := RoleAtLeast(tt.role, tt.other); got != tt.wantAtLeast {
+			t.Errorf("RoleAtLeast(%q, %q) = %v, want %v", tt.role, tt.other, got, tt.wantAtLeast)
+		}
+		if got := Outranks(tt.role, tt.other); got != tt.wantOutranks {
+			t.Errorf("Outranks(%q, %q) = %v, want %v", tt.role, tt.other, got, tt.wantOutranks)
+		}
+	}
+}
+
+func TestMemberCan(t *testing.T) {
+	tests := []struct {
+		name   string
+		member Member
+		role   string
+		want   bool
+	}{
+		{"accepted gm as player", Member{Role: RoleGameMaster, Status: MemberAccepted}, RolePlayer, true},
+		{"accepted viewer as player", Member{Role: RoleViewer, Status: MemberAccepted}, RolePlayer, false},
+		{"invited gm as viewer", Member{Role: RoleGameMaster, Status: MemberInvited}, RoleViewer, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.member.Can(tt.role); got != tt.want {
+				t.Errorf("Can(%q) = %v, want %v", tt.role, got, tt.want)
+			}
+		})
+	}
+}
@@ -1,11 +1,17 @@
 package worlds
 
 import (
+	"errors"
 	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 
-	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
 	common "github.com/jplindgren/rpg-vault/internal"
-	"github.com/jplindgren/rpg-vault/internal/clients"
+	"github.com/jplindgren/rpg-vault/internal/search"
+	"github.com/jplindgren/rpg-vault/internal/sheets"
+	"github.com/jplindgren/rpg-vault/internal/storage"
 	"github.com/jplindgren/rpg-vault/internal/uploader"
 	"github.com/jplindgren/rpg-vault/internal/validator"
 )
@@ -13,24 +19,60 @@ import (
 //const worldTableName = "rpg_worlds"
 
 type WorldService struct {
-	db        *clients.DynamoDbClientWrapper
-	s3        *clients.S3ClientWrapper
-	tableName string
+	db              storage.Store
+	blobs           uploader.BlobStore
+	index           *search.Index
+	tableName       string
+	membersTable    string
+	charactersTable string
+	rollsTable      string
+	locationsTable  string
+	itemsTable      string
+	inventoryTable  string
+}
+
+// Tables names the table of the worlds and the tables holding the items that belong to
+// a world, which are deleted along with it.
+type Tables struct {
+	Worlds     string
+	Members    string
+	Characters string
+	Rolls      string
+	Locations  string
+	Items      string
+	Inventory  string
 }
 
-func New(db *clients.DynamoDbClientWrapper, s3 *clients.S3ClientWrapper, tableName string) *WorldService {
+func New(db storage.Store, blobs uploader.BlobStore, index *search.Index, tables Tables) *WorldService {
 	return &WorldService{
-		db:        db,
-		s3:        s3,
-		tableName: tableName,
+		db:              db,
+		blobs:           blobs,
+		index:           index,
+		tableName:       tables.Worlds,
+		membersTable:    tables.Members,
+		charactersTable: tables.Characters,
+		rollsTable:      tables.Rolls,
+		locationsTable:  tables.Locations,
+		itemsTable:      tables.Items,
+		inventoryTable:  tables.Inventory,
 	}
 }
 
-const coverImageDestination = "%s/world/cover.png"
+// Deleted worlds stay in the table, marked with the time they were moved to the trash.
+// The sparse deletedAt-index only holds them.
+const deletedIndex = "deletedAt-index"
+
+var notDeleted = storage.AttributeNotExists("deletedAt")
+
+// The files of a world are kept under its id.
+const (
+	filesPrefix           = "%s/"
+	coverImageDestination = "%s/world/cover.png"
+)
 
 func (ws *WorldService) Insert(world *World) error {
 	world.Id = common.GenerateToken()
-	coverUrl, err := uploader.UploadCoverImage(ws.s3,
+	coverUrl, err := uploader.UploadCoverImage(ws.blobs,
 		world.CoverImage,
 		fmt.Sprintf(coverImageDestination, world.Id),
 	)
@@ -39,10 +81,17 @@ func (ws *WorldService) Insert(world *World) error {
 	}
 
 	world.CreatedAt = common.GetIsoString()
+	world.UpdatedAt = world.CreatedAt
+	world.NameLower = strings.ToLower(world.Name)
 	world.CoverImage = coverUrl
 
-	_, err = ws.db.PutWrapper(ws.tableName, world, nil)
-	return err
+	err = ws.db.Put(ws.tableName, world, nil)
+	if err != nil {
+		return err
+	}
+
+	ws.index.Put(searchDocument(world))
+	return nil
 }
 
 type WorldKey struct {
@@ -56,14 +105,21 @@ func (ws *WorldService) Get(userId, id string) (*World, error) {
 		Id:     id,
 	}
 	var result World
-	_, err := ws.db.GetWrapper(ws.tableName, key, &result)
+	err := ws.db.Get(ws.tableName, key, &result)
 	if err != nil {
 		return nil, err
 	}
 
+	// Worlds in the trash are only reachable through ListDeleted and Restore.
+	if result.DeletedAt != "" {
+		return nil, common.ErrorRecordNotFound
+	}
+
 	return &result, nil
 }
 
+// Update saves the changes to a world. It fails with common.ErrorRecordNotFound when
+// the world was moved to the trash or purged meanwhile.
 func (ws *WorldService) Update(userId, id string, world *World, imageUpdated bool) error {
 	key := &WorldKey{
 		UserId: userId,
@@ -71,7 +127,7 @@ func (ws *WorldService) Update(userId, id string, world *World, imageUpdated boo
 	}
 
 	if imageUpdated {
-		coverUrl, err := uploader.UploadCoverImage(ws.s3,
+		coverUrl, err := uploader.UploadCoverImage(ws.blobs,
 			world.CoverImage,
 			fmt.Sprintf(coverImageDestination, world.Id),
 		)
@@ -83,41 +139,334 @@ func (ws *WorldService) Update(userId, id string, world *World, imageUpdated boo
 
 	world.UpdatedAt = common.GetIsoString()
 
-	update := expression.Set(
-		expression.Name("name"), expression.Value(world.Name),
-	).Set(
-		expression.Name("intro"), expression.Value(world.Intro),
-	).Set(
-		expression.Name("genres"), expression.Value(world.Genres),
-	).Set(
-		expression.Name("coverImage"), expression.Value(world.CoverImage),
-	).Set(
-		expression.Name("updatedAt"), expression.Value(world.UpdatedAt),
-	)
+	update := storage.Set("name", world.Name).
+		Set("nameLower", strings.ToLower(world.Name)).
+		Set("intro", world.Intro).
+		Set("genres", world.Genres).
+		Set("coverImage", world.CoverImage).
+		Set("updatedAt", world.UpdatedAt)
+
+	if world.Sheet != nil {
+		update = update.Set("sheet", world.Sheet)
+	} else {
+		update = update.Remove("sheet")
+	}
 
-	_, err := ws.db.UpdateWrapper(ws.tableName, key, update)
-	return err
+	// Don't recreate a world purged meanwhile, or edit one moved to the trash.
+	update = update.If(storage.And(storage.AttributeExists("id"), notDeleted))
+
+	err := ws.db.Update(ws.tableName, key, update)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrorConditionFailed):
+			return common.ErrorRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	ws.index.Put(searchDocument(world))
+	return nil
 }
 
-func (ws *WorldService) List(userId string) (*[]World, error) {
-	keyEx := expression.Key("userId").Equal(expression.Value(userId))
+// List returns a page of the worlds created by a user matching the filters, starting
+// after the start key, along with the key of the next page, or nil on the last one.
+// The worlds are read from the index of the sort attribute, already sorted.
+func (ws *WorldService) List(userId string, filters Filters, limit int, start storage.Item) (*[]World, storage.Item, error) {
+	query := &storage.Query{
+		Index:      common.SortColumn(filters.Sort) + "-index",
+		Key:        "userId",
+		Value:      userId,
+		Filter:     storage.And(notDeleted, filters.condition()),
+		Descending: common.SortDescending(filters.Sort),
+		Limit:      limit,
+		StartKey:   start,
+	}
+
 	var resultArr []World
-	_, err := ws.db.QueryWrapper(ws.tableName, keyEx, &resultArr)
+	next, err := ws.db.QueryPage(ws.tableName, query, &resultArr)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	return &resultArr, nil
+	return &resultArr, next, nil
 }
 
+// Delete moves a world to the trash. It disappears from Get and List, and from the
+// search results, until it is restored or purged.
 func (ws *WorldService) Delete(userId, id string) error {
 	key := WorldKey{
 		UserId: userId,
 		Id:     id,
 	}
 
-	_, err := ws.db.DeleteWrapper(ws.tableName, key)
-	return err
+	update := storage.Set("deletedAt", common.GetIsoString()).
+		If(storage.And(storage.AttributeExists("id"), notDeleted))
+
+	err := ws.db.Update(ws.tableName, key, update)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrorConditionFailed):
+			return common.ErrorRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	ws.index.Delete(search.KindWorld, id)
+	return nil
+}
+
+// Restore takes a world out of the trash and returns it.
+func (ws *WorldService) Restore(userId, id string) (*World, error) {
+	key := WorldKey{
+		UserId: userId,
+		Id:     id,
+	}
+
+	update := storage.Set("updatedAt", common.GetIsoString()).
+		Remove("deletedAt").
+		If(storage.AttributeExists("deletedAt"))
+
+	err := ws.db.Update(ws.tableName, key, update)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrorConditionFailed):
+			return nil, common.ErrorRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	world, err := ws.Get(userId, id)
+	if err != nil {
+		return nil, err
+	}
+
+	ws.index.Put(searchDocument(world))
+	return world, nil
+}
+
+// ListDeleted returns a page of the worlds of a user in the trash, the most recently
+// deleted first, along with the key of the next page, or nil on the last one.
+func (ws *WorldService) ListDeleted(userId string, limit int, start storage.Item) (*[]World, storage.Item, error) {
+	query := &storage.Query{
+		Index:      deletedIndex,
+		Key:        "userId",
+		Value:      userId,
+		Descending: true,
+		Limit:      limit,
+		StartKey:   start,
+	}
+
+	var resultArr []World
+	next, err := ws.db.QueryPage(ws.tableName, query, &resultArr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &resultArr, next, nil
+}
+
+// PurgeDeleted permanently deletes the worlds moved to the trash before the given
+// time, returning how many were purged. A world failing to be purged doesn't stop the
+// others: the failures are returned together, along with the count of the rest, and
+// the next purge tries those worlds again.
+func (ws *WorldService) PurgeDeleted(before time.Time) (int, error) {
+	// Only the trashed worlds are in the sparse deletedAt index. Their deletedAt is
+	// compared as a time, as the older ones weren't always written in UTC.
+	var resultArr []World
+	err := ws.db.ScanIndex(ws.tableName, deletedIndex, nil, &resultArr)
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	var failures common.Errors
+	for _, world := range resultArr {
+		deletedAt, err := time.Parse(time.RFC3339, world.DeletedAt)
+		if err != nil || !deletedAt.Before(before) {
+			continue
+		}
+
+		err = common.Guard(func() error {
+			return ws.Purge(world.UserId, world.Id)
+		})
+		if err != nil {
+			failures = append(failures, fmt.Errorf("purging world %s: %w", world.Id, err))
+			continue
+		}
+		purged++
+	}
+
+	return purged, failures.Err()
+}
+
+// Purge permanently removes a world along with everything that belongs to it: its
+// characters and their inventories, its items, its locations, its rolls, its
+// memberships and its files. The world itself goes last, so when a step fails the
+// world is still there, and purging it again picks up where it stopped.
+func (ws *WorldService) Purge(userId, id string) error {
+	err := ws.deleteChildren(ws.charactersTable, id, []string{"worldId", "id"}, func(key map[string]string) {
+		ws.index.Delete(search.KindCharacter, key["id"])
+	})
+	if err != nil {
+		return fmt.Errorf("deleting characters of world %s: %w", id, err)
+	}
+
+	err = ws.deleteChildren(ws.inventoryTable, id, []string{"worldId", "id"}, nil)
+	if err != nil {
+		return fmt.Errorf("deleting inventories of world %s: %w", id, err)
+	}
+
+	err = ws.deleteChildren(ws.itemsTable, id, []string{"worldId", "id"}, nil)
+	if err != nil {
+		return fmt.Errorf("deleting items of world %s: %w", id, err)
+	}
+
+	err = ws.deleteChildren(ws.locationsTable, id, []string{"worldId", "id"}, nil)
+	if err != nil {
+		return fmt.Errorf("deleting locations of world %s: %w", id, err)
+	}
+
+	err = ws.deleteChildren(ws.rollsTable, id, []string{"worldId", "id"}, nil)
+	if err != nil {
+		return fmt.Errorf("deleting rolls of world %s: %w", id, err)
+	}
+
+	err = ws.deleteChildren(ws.membersTable, id, []string{"worldId", "email"}, nil)
+	if err != nil {
+		return fmt.Errorf("deleting members of world %s: %w", id, err)
+	}
+
+	err = ws.blobs.DeletePrefix(fmt.Sprintf(filesPrefix, id))
+	if err != nil {
+		return fmt.Errorf("deleting files of world %s: %w", id, err)
+	}
+
+	key := WorldKey{
+		UserId: userId,
+		Id:     id,
+	}
+
+	err = ws.db.Delete(ws.tableName, key)
+	if err != nil {
+		return err
+	}
+
+	ws.index.Delete(search.KindWorld, id)
+	return nil
+}
+
+// The children of a world are read and deleted a page at a time, sized like a DynamoDB
+// batch write.
+const deletePageSize = 25
+
+// deleteChildren deletes the items of a world from a table partitioned by worldId. keys
+// names the attributes of the primary key of the table, and deleted, when not nil, is
+// called with the key of every deleted item.
+func (ws *WorldService) deleteChildren(table, worldId string, keys []string, deleted func(key map[string]string)) error {
+	query := &storage.Query{
+		Key:        "worldId",
+		Value:      worldId,
+		Projection: keys,
+		Limit:      deletePageSize,
+	}
+
+	for {
+		var page []map[string]string
+		next, err := ws.db.QueryPage(table, query, &page)
+		if err != nil {
+			return err
+		}
+
+		err = ws.db.BatchDelete(table, page)
+		if err != nil {
+			return err
+		}
+
+		if deleted != nil {
+			for _, key := range page {
+				deleted(key)
+			}
+		}
+
+		if next == nil {
+			return nil
+		}
+		query.StartKey = next
+	}
+}
+
+// Reindex adds every world out of the trash to the search index.
+func (ws *WorldService) Reindex() (int, error) {
+	var resultArr []World
+	err := ws.db.Scan(ws.tableName, notDeleted, &resultArr)
+	if err != nil {
+		return 0, err
+	}
+
+	for i := range resultArr {
+		ws.index.Put(searchDocument(&resultArr[i]))
+	}
+
+	return len(resultArr), nil
+}
+
+func searchDocument(world *World) search.Document {
+	return search.Document{
+		Kind:    search.KindWorld,
+		Id:      world.Id,
+		WorldId: world.Id,
+		OwnerId: world.UserId,
+		Name:    world.Name,
+		Fields: map[string]string{
+			"name":   world.Name,
+			"intro":  world.Intro,
+			"genres": strings.Join(world.Genres, " "),
+		},
+	}
+}
+
+// condition returns the condition a world must meet to match the filters, or nil when
+// every world does.
+func (f Filters) condition() *storage.Condition {
+	var conds []*storage.Condition
+
+	if f.Name != "" {
+		conds = append(conds, storage.ContainsFold("name", "nameLower", f.Name))
+	}
+
+	for _, genre := range f.Genres {
+		conds = append(conds, storage.Contains("genres", genre))
+	}
+
+	if len(conds) == 0 {
+		return nil
+	}
+
+	return storage.And(conds...)
+}
+
+// Matches reports whether a world matches the filters.
+func (f Filters) Matches(world *World) (bool, error) {
+	cond := f.condition()
+	if cond == nil {
+		return true, nil
+	}
+
+	item, err := attributevalue.MarshalMap(world)
+	if err != nil {
+		return false, err
+	}
+
+	return cond.Eval(item)
+}
+
+func ValidateFilters(v *validator.Validator, f Filters) {
+	v.Check(validator.PermittedValue(f.Sort, SortSafelist...), "sort", "invalid sort value")
+	v.Check(len(f.Name) <= 200, "name", "must not be more than 200 characters long")
+	v.Check(len(f.Genres) <= 5, "genres", "must not contain more than 5 genres")
 }
 
 func ValidateWorld(v *validator.Validator, world *World) {
@@ -126,4 +475,13 @@ func ValidateWorld(v *validator.Validator, world *World) {
 
 	v.Check(validator.Unique(world.Genres), "genres", "must not contain duplicate values")
 	v.Check(len(world.Genres) <= 5, "genres", "must not contain more than 5 genres")
+
+	if world.System != "" {
+		_, found := sheets.LookupSystem(world.System)
+		v.Check(found, "system", "must be one of the game systems")
+	}
+
+	if world.Sheet != nil {
+		sheets.ValidateTemplate(v, world.Sheet)
+	}
 }